@@ -0,0 +1,183 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrBuilderNoContent is returned by Builder.Build when there is no text, html, nor attachment.
+var ErrBuilderNoContent = errors.New("Message must have text, html, or an attachment")
+
+// Builder composes a Message with chained method calls, such as:
+//
+//	msg, err := NewBuilder().
+//	    From("from@host.com").To("to@host.com").Subject("Subject").
+//	    Text("text").HTML("<p>html</p>").
+//	    Attach("report.pdf", pdf).
+//	    Build()
+//
+// The zero value is ready to use.
+type Builder struct {
+	header      Header
+	text        *string
+	html        *string
+	inlines     []*Message
+	attachments []*Message
+	err         error
+}
+
+// NewBuilder returns an empty Builder.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// headers returns the header, creating it if needed.
+func (b *Builder) headers() Header {
+	if b.header == nil {
+		b.header = Header{}
+	}
+	return b.header
+}
+
+// From sets the From address.
+func (b *Builder) From(email string) *Builder {
+	b.headers().SetFrom(email)
+	return b
+}
+
+// To sets the To addresses.
+func (b *Builder) To(emails ...string) *Builder {
+	b.headers().SetTo(emails...)
+	return b
+}
+
+// Cc sets the Cc addresses.
+func (b *Builder) Cc(emails ...string) *Builder {
+	b.headers().SetCc(emails...)
+	return b
+}
+
+// Bcc sets the Bcc addresses.
+func (b *Builder) Bcc(emails ...string) *Builder {
+	b.headers().SetBcc(emails...)
+	return b
+}
+
+// Subject sets the Subject.
+func (b *Builder) Subject(subject string) *Builder {
+	b.headers().SetSubject(subject)
+	return b
+}
+
+// Header sets any other header field.
+func (b *Builder) Header(key, value string) *Builder {
+	b.headers().Set(key, value)
+	return b
+}
+
+// Text sets the plain text content.
+func (b *Builder) Text(textPlain string) *Builder {
+	b.text = &textPlain
+	return b
+}
+
+// HTML sets the html content.
+func (b *Builder) HTML(html string) *Builder {
+	b.html = &html
+	return b
+}
+
+// Attach adds an attachment, using the filename's mime type.
+func (b *Builder) Attach(filename string, raw []byte) *Builder {
+	b.attachments = append(b.attachments, NewPartAttachmentFromBytes(raw, filename))
+	return b
+}
+
+// AttachStream adds an attachment with content that is streamed from the reader when the
+// message is written out, like NewPartAttachmentStream, such as from an open file.
+// The size is the length of the content in bytes, or -1 if it is unknown.
+func (b *Builder) AttachStream(filename, contentType string, size int64, r io.Reader) *Builder {
+	b.attachments = append(b.attachments, NewPartAttachmentStream(r, filename, contentType, size))
+	return b
+}
+
+// Inline adds an inline part for the html, such as an image, using the filename's mime type,
+// which the html refers to as "cid:" followed by the contentID (do not wrap with angle brackets).
+// If the contentID is empty, one is created with GenContentID.
+func (b *Builder) Inline(filename string, raw []byte, contentID string) *Builder {
+	if len(contentID) == 0 {
+		var err error
+		if contentID, err = GenContentID(filename); err != nil {
+			if b.err == nil {
+				b.err = err
+			}
+			return b
+		}
+	}
+	b.inlines = append(b.inlines, NewPartInlineFromBytes(raw, filename, contentID))
+	return b
+}
+
+// Build validates the header, and returns the Message with the multipart structure needed
+// for its content: multipart/alternative for both text and html, multipart/related for html
+// with inline parts, and multipart/mixed when there are attachments, such as:
+//
+//	multipart/mixed
+//	    multipart/alternative
+//	        text/plain
+//	        multipart/related
+//	            text/html
+//	            image/png (inline with Content-ID)
+//	    application/pdf (attachment)
+//
+// A single part without any others is not wrapped in a multipart.
+func (b *Builder) Build() (*Message, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if err := b.header.Validate(); err != nil {
+		return nil, err
+	}
+	if len(b.inlines) > 0 && b.html == nil {
+		return nil, errors.New("Message with inline parts must have html")
+	}
+
+	var content []*Message
+	var htmlPart *Message
+	if b.html != nil {
+		htmlPart = NewPartHTML(*b.html)
+		if len(b.inlines) > 0 {
+			htmlPart = NewPartMultipart("related", append([]*Message{htmlPart}, b.inlines...)...)
+		}
+	}
+	switch {
+	case b.text != nil && htmlPart != nil:
+		content = append(content, NewPartMultipart("alternative", NewPartText(*b.text), htmlPart))
+	case b.text != nil:
+		content = append(content, NewPartText(*b.text))
+	case htmlPart != nil:
+		content = append(content, htmlPart)
+	}
+	content = append(content, b.attachments...)
+
+	var root *Message
+	switch len(content) {
+	case 0:
+		return nil, ErrBuilderNoContent
+	case 1:
+		root = content[0]
+	default:
+		root = NewPartMultipart("mixed", content...)
+	}
+
+	msg := *root
+	msg.Header = b.header.Clone()
+	for key, values := range root.Header {
+		msg.Header[key] = values
+	}
+	return &msg, nil
+}
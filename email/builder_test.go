@@ -0,0 +1,106 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestBuilder ...
+func TestBuilder(t *testing.T) {
+	t.Parallel()
+
+	msg, err := NewBuilder().
+		From("Test Name <test.from@host.com>").
+		To("test.to@host.com").
+		Cc("test.cc@host.com").
+		Subject("Test Subject").
+		Text("text").
+		HTML("<p>html <img src=\"cid:logo@host.com\"></p>").
+		Inline("logo.png", []byte("png"), "logo@host.com").
+		Attach("report.pdf", []byte("pdf")).
+		Build()
+	if err != nil {
+		t.Fatal("Could not build message:", err)
+	}
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatal("Could not write message:", err)
+	}
+	parsed, err := ParseMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+
+	if parsed.Header.Get("From") != "Test Name <test.from@host.com>" || parsed.Header.Get("Cc") != "test.cc@host.com" || parsed.Header.Subject() != "Test Subject" {
+		t.Fatalf("Incorrect header: %q", parsed.Header)
+	}
+	expected := []string{"multipart/mixed", "multipart/alternative", "text/plain", "multipart/related", "text/html", "image/png", "application/pdf"}
+	all := parsed.MessagesAll()
+	if len(all) != len(expected) {
+		t.Fatal("Incorrect number of parts:", len(all))
+	}
+	for i, part := range all {
+		if mediaType, _, _ := part.Header.ContentType(); mediaType != expected[i] {
+			t.Fatalf("Expected %q for part %d, got %q", expected[i], i, mediaType)
+		}
+	}
+	if all[5].Header.Get("Content-Id") != "<logo@host.com>" || string(all[5].Body) != "png" || string(all[6].Body) != "pdf" {
+		t.Fatalf("Incorrect inline or attachment: %q %q", all[5].Header, all[6].Header)
+	}
+}
+
+// TestBuilderStructure ...
+func TestBuilderStructure(t *testing.T) {
+	t.Parallel()
+
+	builder := func() *Builder {
+		return NewBuilder().From("test.from@host.com").To("test.to@host.com").Subject("Test Subject")
+	}
+
+	msg, err := builder().Text("text").Build()
+	if err != nil || msg.HasParts() || string(msg.Body) != "text" || msg.Header.Get("To") != "test.to@host.com" {
+		t.Fatal("Expected a single text/plain message:", msg, err)
+	}
+	msg, err = builder().HTML("<p>html</p>").Attach("report.pdf", []byte("pdf")).Build()
+	if mediaType, _, _ := msg.Header.ContentType(); err != nil || mediaType != "multipart/mixed" || len(msg.Parts) != 2 || msg.Parts[0].HasParts() {
+		t.Fatal("Expected a multipart/mixed with html and an attachment:", msg, err)
+	}
+
+	if _, err = builder().Build(); err != ErrBuilderNoContent {
+		t.Fatal("Expected an error without any content:", err)
+	}
+	if _, err = builder().Text("text").Inline("logo.png", []byte("png"), "").Build(); err == nil {
+		t.Fatal("Expected an error for inline parts without html")
+	}
+	if _, err = NewBuilder().From("test.from@host.com").Subject("Test Subject").Text("text").Build(); err == nil {
+		t.Fatal("Expected an error without any recipient")
+	}
+}
+
+// TestBuilderStreamOnly ...
+func TestBuilderStreamOnly(t *testing.T) {
+	t.Parallel()
+
+	msg, err := NewBuilder().From("test.from@host.com").To("test.to@host.com").Subject("Test Subject").
+		AttachStream("data.bin", "application/octet-stream", 5, strings.NewReader("hello")).
+		Build()
+	if err != nil {
+		t.Fatal("Could not build message:", err)
+	}
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatal("Could not write message:", err)
+	}
+	parsed, err := ParseMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	if string(parsed.Body) != "hello" || parsed.Header.Get("To") != "test.to@host.com" {
+		t.Fatalf("Incorrect streamed attachment: %q", raw)
+	}
+}
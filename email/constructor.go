@@ -5,10 +5,13 @@
 package email
 
 import (
+	"errors"
 	"io"
 	"io/ioutil"
 	"mime"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // NewMessage will create a multipart email containing plain text, html,
@@ -22,13 +25,13 @@ import (
 //     * * application/pdf (attachment)
 func NewMessage(headers Header, textPlain string, html string, attachments ...*Message) *Message {
 
-	headers.Set("Content-Type", "multipart/mixed; boundary=\""+randomBoundary()+"\"")
-
 	alternativePart := NewPartMultipart("alternative", NewPartText(textPlain), NewPartHTML(html))
 
 	parts := make([]*Message, 0, 1+len(attachments))
 	parts = append(parts, alternativePart)
 	parts = append(parts, attachments...)
+
+	headers.Set("Content-Type", "multipart/mixed; boundary=\""+GenSafeBoundary(parts...)+"\"")
 	return &Message{Header: headers, Parts: parts}
 }
 
@@ -48,8 +51,6 @@ func NewMessage(headers Header, textPlain string, html string, attachments ...*M
 //     * * application/pdf (attachment)
 func NewMessageWithInlines(headers Header, textPlain string, html string, inlines []*Message, attachments ...*Message) *Message {
 
-	headers.Set("Content-Type", "multipart/mixed; boundary=\""+randomBoundary()+"\"")
-
 	inlineParts := []*Message{NewPartHTML(html)}
 	inlineParts = append(inlineParts, inlines...)
 	relatedPart := NewPartMultipart("related", inlineParts...)
@@ -59,6 +60,8 @@ func NewMessageWithInlines(headers Header, textPlain string, html string, inline
 	parts := make([]*Message, 0, 1+len(attachments))
 	parts = append(parts, alternativePart)
 	parts = append(parts, attachments...)
+
+	headers.Set("Content-Type", "multipart/mixed; boundary=\""+GenSafeBoundary(parts...)+"\"")
 	return &Message{Header: headers, Parts: parts}
 }
 
@@ -67,10 +70,30 @@ func NewMessageWithInlines(headers Header, textPlain string, html string, inline
 // Example: if "mixed" is passed in as multipartSubType, then a "multipart/mixed" part is created.
 func NewPartMultipart(multipartSubType string, parts ...*Message) *Message {
 	return &Message{
-		Header: Header{"Content-Type": []string{"multipart/" + multipartSubType + "; boundary=\"" + randomBoundary() + "\""}},
+		Header: Header{"Content-Type": []string{"multipart/" + multipartSubType + "; boundary=\"" + GenSafeBoundary(parts...) + "\""}},
 		Parts:  parts}
 }
 
+// NewPartMessage creates a "message/rfc822" part, with the message encapsulated in it,
+// such as to forward a message as an attachment.
+func NewPartMessage(msg *Message) *Message {
+	return &Message{
+		Header:     Header{"Content-Type": []string{"message/rfc822"}},
+		SubMessage: msg}
+}
+
+// NewPartDigest creates a "multipart/digest" part, such as for a mailing list digest,
+// with each of the messages encapsulated in a "message/rfc822" part, which is the
+// default Content-Type of the parts of a digest (RFC 2046 5.1.5).
+// More messages can be added to its Parts with NewPartMessage.
+func NewPartDigest(messages ...*Message) *Message {
+	parts := make([]*Message, 0, len(messages))
+	for _, msg := range messages {
+		parts = append(parts, NewPartMessage(msg))
+	}
+	return NewPartMultipart("digest", parts...)
+}
+
 // NewPartText creates a "text/plain" part, with the text string as its content
 // (do not encode, this will happen automatically when needed).
 func NewPartText(textPlain string) *Message {
@@ -87,6 +110,22 @@ func NewPartHTML(html string) *Message {
 		Body:   []byte(html)}
 }
 
+// NewPartCalendar creates a "text/calendar" part for an invite, with the raw iCalendar
+// bytes as its content, encoded as base64 to keep its CRLF line endings intact.
+// Common values for parameter method are: REQUEST, REPLY, and CANCEL, which must
+// match the METHOD in the iCalendar content. Example invite structure:
+//     * multipart/alternative
+//     * * text/plain
+//     * * text/html
+//     * * text/calendar; method=REQUEST
+func NewPartCalendar(ics []byte, method string) *Message {
+	return &Message{
+		Header: Header{
+			"Content-Type":              []string{"text/calendar; charset=\"UTF-8\"; method=" + strings.ToUpper(method)},
+			"Content-Transfer-Encoding": []string{"base64"}},
+		Body: ics}
+}
+
 // NewPartAttachment creates an attachment part,
 // using the filename's mime type, and with the reader's content
 // (do not encode, this will happen automatically when needed).
@@ -102,7 +141,7 @@ func NewPartAttachment(r io.Reader, filename string) (*Message, error) {
 // using the filename's mime type, and with the bytes as its content
 // (do not encode, this will happen automatically when needed).
 func NewPartAttachmentFromBytes(raw []byte, filename string) *Message {
-	return newPartFromBytes(raw, mime.TypeByExtension(filepath.Ext(filename)), "attachment; filename=\""+filename+"\"", "")
+	return newPartFromBytes(raw, mime.TypeByExtension(filepath.Ext(filename)), dispositionWithFilename("attachment", filename), "")
 }
 
 // NewPartInline creates an inline part,
@@ -122,7 +161,7 @@ func NewPartInline(r io.Reader, filename string, contentID string) (*Message, er
 // (do not wrap with angle brackets), and with the bytes as its content
 // (do not encode, this will happen automatically when needed).
 func NewPartInlineFromBytes(raw []byte, filename string, contentID string) *Message {
-	return newPartFromBytes(raw, mime.TypeByExtension(filepath.Ext(filename)), "inline; filename=\""+filename+"\"", contentID)
+	return newPartFromBytes(raw, mime.TypeByExtension(filepath.Ext(filename)), dispositionWithFilename("inline", filename), contentID)
 }
 
 // newPartFromBytes creates a generic binary part,
@@ -148,3 +187,111 @@ func newPartFromBytes(raw []byte, contentType string, contentDisposition string,
 
 	return &Message{Header: headers, Body: raw}
 }
+
+// AttachmentBudget is the maximum total size in bytes of the attachments added to a
+// message with AttachStream, or unlimited if it is 0.
+var AttachmentBudget int64
+
+// ErrAttachmentBudget is returned when adding an attachment would exceed the AttachmentBudget.
+var ErrAttachmentBudget = errors.New("Attachments exceed the attachment budget")
+
+// ErrNotMultipart ...
+var ErrNotMultipart = errors.New("Message does not have a Content-Type of multipart")
+
+// AttachStream adds an attachment part to this multipart message, using the contentType
+// (or the filename's mime type if it is empty), with content that is streamed from the
+// reader and base64 encoded when the message is written out, rather than held in memory.
+// The size is the length of the content in bytes, which is checked against the
+// AttachmentBudget, or -1 if it is unknown, in which case the content is only read
+// in (up to the remaining budget) if there is a budget.
+func (m *Message) AttachStream(filename, contentType string, size int64, r io.Reader) error {
+	if !m.HasParts() {
+		return ErrNotMultipart
+	}
+	if len(contentType) == 0 {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	used := m.attachmentsSize()
+
+	if size < 0 && AttachmentBudget > 0 {
+		raw, err := ioutil.ReadAll(io.LimitReader(r, AttachmentBudget-used+1))
+		if err != nil {
+			return err
+		}
+		if used+int64(len(raw)) > AttachmentBudget {
+			return ErrAttachmentBudget
+		}
+		m.Parts = append(m.Parts, newPartFromBytes(raw, contentType, dispositionWithFilename("attachment", filename), ""))
+		return nil
+	}
+	if AttachmentBudget > 0 && used+size > AttachmentBudget {
+		return ErrAttachmentBudget
+	}
+
+	m.Parts = append(m.Parts, NewPartAttachmentStream(r, filename, contentType, size))
+	return nil
+}
+
+// NewPartAttachmentStream creates an attachment part, using the contentType (or the
+// filename's mime type if it is empty), with content that is streamed from the reader
+// and base64 encoded when the message is written out, rather than held in memory,
+// such as from an open file. The size is the length of the content in bytes, or -1
+// if it is unknown, in which case Size returns ErrUnknownSize for the message.
+// Unlike AttachStream, it is not checked against the AttachmentBudget.
+// The part can only be written out once, as that reads the content.
+func NewPartAttachmentStream(r io.Reader, filename, contentType string, size int64) *Message {
+	if len(contentType) == 0 {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	disposition := dispositionWithFilename("attachment", filename)
+	if size >= 0 {
+		disposition += "; size=" + strconv.FormatInt(size, 10)
+		r = &sizedReader{r: r, remaining: size}
+	}
+	part := newPartFromBytes(nil, contentType, disposition, "")
+	part.Header.Set("Content-Transfer-Encoding", "base64")
+	part.BodyReader = r
+	return part
+}
+
+// attachmentsSize returns the total size in bytes of the attachments in this message,
+// using the size parameter of the Content-Disposition for those that are streamed.
+func (m *Message) attachmentsSize() int64 {
+	var total int64
+	for _, part := range m.MessagesAll() {
+		disposition, params, err := part.Header.ContentDisposition()
+		if err != nil || disposition != "attachment" {
+			continue
+		}
+		if part.Body == nil && part.BodyReader != nil {
+			size, _ := strconv.ParseInt(params["size"], 10, 64)
+			total += size
+		} else {
+			total += int64(len(part.Body))
+		}
+	}
+	return total
+}
+
+// sizedReader reads from a reader of a known size, returning an error
+// if its content turns out to be longer or shorter than that size.
+type sizedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+// Read ...
+func (s *sizedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > s.remaining+1 {
+		p = p[:s.remaining+1] // read one extra byte, to detect content that is too long
+	}
+	n, err := s.r.Read(p)
+	if int64(n) > s.remaining {
+		return int(s.remaining), errors.New("Attachment is longer than its size")
+	}
+	s.remaining -= int64(n)
+	if err == io.EOF && s.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
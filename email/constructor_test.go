@@ -14,7 +14,9 @@ import (
 	"mime"
 	"mime/multipart"
 	"net/mail"
+	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -266,6 +268,253 @@ func TestInlineEmailCreation(t *testing.T) {
 	testInlineAgainstStdLib(t, msg, rawBytes)
 }
 
+// TestCalendarInviteCreation ...
+func TestCalendarInviteCreation(t *testing.T) {
+	t.Parallel()
+
+	ics := []byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nMETHOD:REQUEST\r\nBEGIN:VEVENT\r\n" +
+		"UID:123@host.com\r\nSUMMARY:Planning meeting\r\nDTSTART:20240102T150000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+	invite := NewPartMultipart("alternative", NewPartText("Invite"), NewPartHTML("<p>Invite</p>"), NewPartCalendar(ics, "request"))
+	msg := &Message{Header: NewHeader("test.from@host.com", "Invitation", "test.to@host.com"), Parts: []*Message{invite}}
+	msg.Header.Set("Content-Type", "multipart/mixed; boundary=\""+newBoundary()+"\"")
+
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatal("Could not write invite:", err)
+	}
+	parsed, err := ParseMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse invite:", err)
+	}
+	calendars := parsed.MessagesContentTypePrefix("text/calendar")
+	if len(calendars) != 1 || !bytes.Equal(calendars[0].Body, ics) {
+		t.Fatal("Calendar part did not round-trip:", calendars)
+	}
+	contentType, params, err := calendars[0].Header.ContentType()
+	if err != nil || contentType != "text/calendar" || params["method"] != "REQUEST" || params["charset"] != "UTF-8" {
+		t.Fatal("Incorrect calendar Content-Type:", contentType, params, err)
+	}
+	if !strings.Contains(string(raw), "Content-Transfer-Encoding: base64\nContent-Type: text/calendar;") {
+		t.Fatalf("Calendar part not encoded as base64: %q", raw)
+	}
+}
+
+// TestDigestCreation ...
+func TestDigestCreation(t *testing.T) {
+	t.Parallel()
+
+	first := NewMessage(NewHeader("first@host.com", "First Post", "list@host.com"), "first text", "")
+	second := NewMessage(NewHeader("second@host.com", "Second Post", "list@host.com"), "second text", "<p>second html</p>")
+	msg := &Message{Header: NewHeader("list@host.com", "Digest", "reader@host.com"),
+		Parts: []*Message{NewPartText("Two posts today"), NewPartDigest(first, second)}}
+	msg.Header.Set("Content-Type", "multipart/mixed; boundary=\""+newBoundary()+"\"")
+
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatal("Could not write digest:", err)
+	}
+	parsed, err := ParseMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse digest:", err)
+	}
+	digests := parsed.MessagesContentTypePrefix("multipart/digest")
+	if len(digests) != 1 || len(digests[0].Parts) != 2 {
+		t.Fatal("Expected a digest of two messages:", digests)
+	}
+	for i, expected := range []*Message{first, second} {
+		part := digests[0].Parts[i]
+		if !part.HasSubMessage() || part.SubMessage.Header.Subject() != expected.Header.Subject() ||
+			part.SubMessage.Header.From() != expected.Header.From() {
+			t.Fatal("Digest message did not round-trip:", part.Header, part.SubMessage)
+		}
+	}
+	texts := digests[0].Parts[1].SubMessage.MessagesContentTypePrefix("text/plain")
+	if len(texts) != 1 || string(texts[0].Body) != "second text" {
+		t.Fatal("Expected the parts of the second message:", texts)
+	}
+
+	// Parts of a digest without a Content-Type are messages
+	raw = []byte("Subject: Digest\nContent-Type: multipart/digest; boundary=\"b\"\n\n" +
+		"--b\n\nSubject: First Post\n\nfirst text\n" +
+		"--b\nContent-Type: text/plain\n\nnot a message\n" +
+		"--b--\n")
+	parsed, err = ParseMessage(bytes.NewReader(raw))
+	if err != nil || len(parsed.Parts) != 2 {
+		t.Fatal("Could not parse digest:", parsed, err)
+	}
+	if !parsed.Parts[0].HasSubMessage() || parsed.Parts[0].SubMessage.Header.Subject() != "First Post" ||
+		string(parsed.Parts[0].SubMessage.Body) != "first text" {
+		t.Fatal("Expected a message/rfc822 part by default:", parsed.Parts[0].Header, parsed.Parts[0].SubMessage)
+	}
+	if parsed.Parts[1].HasSubMessage() || string(parsed.Parts[1].Body) != "not a message" {
+		t.Fatal("Expected an explicit Content-Type to be kept:", parsed.Parts[1].Header)
+	}
+
+	read, err := ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not read digest:", err)
+	}
+	if parts, err := read.ReadParts(); err != nil || len(parts) != 2 || !parts[0].HasSubMessage() {
+		t.Fatal("Expected ReadParts to default to message/rfc822:", parts, err)
+	}
+}
+
+// TestAttachStream ...
+func TestAttachStream(t *testing.T) {
+	t.Parallel()
+
+	content := bytes.Repeat([]byte("streamed content \x00\xff "), 10000)
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		for i := 0; i < len(content); i += 1000 {
+			pipeWriter.Write(content[i : i+1000])
+		}
+		pipeWriter.Close()
+	}()
+
+	msg := NewMessage(NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"), "text", "<p>html</p>")
+	if err := msg.AttachStream("data.bin", "", int64(len(content)), pipeReader); err != nil {
+		t.Fatal("Could not attach stream:", err)
+	}
+	attachment := msg.Parts[len(msg.Parts)-1]
+	if attachment.Body != nil || attachment.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Fatal("Expected the stream to not be read in yet:", attachment.Header)
+	}
+
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatal("Could not write message:", err)
+	}
+	encoded := raw[bytes.Index(raw, []byte("Content-Type: application/octet-stream\n\n")):]
+	if !confirmBodyLineLength(encoded[:bytes.Index(encoded, []byte("\n--"))]) {
+		t.Fatal("Streamed attachment lines are too long")
+	}
+	parsed, err := ParseMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	parts := parsed.MessagesContentTypePrefix("application/octet-stream")
+	if len(parts) != 1 || !bytes.Equal(parts[0].Body, content) {
+		t.Fatal("Streamed attachment did not round-trip")
+	}
+	if disposition, params, err := parts[0].Header.ContentDisposition(); err != nil || disposition != "attachment" ||
+		params["filename"] != "data.bin" || params["size"] != strconv.Itoa(len(content)) {
+		t.Fatal("Incorrect Content-Disposition:", disposition, params, err)
+	}
+
+	// The stream must match its size
+	msg = NewMessage(NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"), "text", "<p>html</p>")
+	if err = msg.AttachStream("short.txt", "text/plain", 100, strings.NewReader("too short")); err != nil {
+		t.Fatal("Could not attach stream:", err)
+	}
+	if _, err = msg.Bytes(); err != io.ErrUnexpectedEOF {
+		t.Fatal("Expected an error for a stream shorter than its size:", err)
+	}
+
+	if err = NewPartText("text").AttachStream("file.txt", "", 1, strings.NewReader("a")); err != ErrNotMultipart {
+		t.Fatal("Expected an error attaching to a part that is not multipart:", err)
+	}
+}
+
+// TestAttachStreamFromFile ...
+func TestAttachStreamFromFile(t *testing.T) {
+	t.Parallel()
+
+	content := bytes.Repeat([]byte("file content \x00\x01\xfe\xff\n"), 50000)
+	file, err := ioutil.TempFile("", "attachment-*.bin")
+	if err != nil {
+		t.Fatal("Could not create temp file:", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err = file.Write(content); err != nil {
+		t.Fatal("Could not write temp file:", err)
+	}
+
+	open := func() *os.File {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			t.Fatal("Could not rewind temp file:", err)
+		}
+		return file
+	}
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal("Could not stat temp file:", err)
+	}
+
+	msg, err := NewBuilder().From("test.from@host.com").To("test.to@host.com").Subject("Test Subject").
+		Text("text").AttachStream("report.bin", "application/x-report", info.Size(), open()).Build()
+	if err != nil {
+		t.Fatal("Could not build message:", err)
+	}
+	size, err := msg.Size()
+	if err != nil {
+		t.Fatal("Could not size message with a known length:", err)
+	}
+	raw, err := msg.Bytes()
+	if err != nil || int64(len(raw)) != size {
+		t.Fatal("Expected the size to match the written message:", len(raw), size, err)
+	}
+	parsed, err := ParseMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	parts := parsed.MessagesContentTypePrefix("application/x-report")
+	if len(parts) != 1 || !bytes.Equal(parts[0].Body, content) {
+		t.Fatal("Streamed file did not round-trip")
+	}
+
+	// Without a known length, the size is unknown
+	msg = NewMessage(NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"), "text", "",
+		NewPartAttachmentStream(open(), "report.bin", "", -1))
+	if _, err = msg.Size(); err != ErrUnknownSize {
+		t.Fatal("Expected ErrUnknownSize:", err)
+	}
+	if raw, err = msg.Bytes(); err != nil {
+		t.Fatal("Could not write message:", err)
+	}
+	if parsed, err = ParseMessage(bytes.NewReader(raw)); err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	if parts = parsed.MessagesContentTypePrefix("application/octet-stream"); len(parts) != 1 || !bytes.Equal(parts[0].Body, content) {
+		t.Fatal("Streamed file of unknown length did not round-trip")
+	}
+}
+
+// TestAttachStreamBudget ...
+func TestAttachStreamBudget(t *testing.T) {
+	defer func(original int64) { AttachmentBudget = original }(AttachmentBudget)
+
+	AttachmentBudget = 100
+	msg := NewMessage(NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"), "text", "<p>html</p>")
+	if err := msg.AttachStream("large.bin", "", 150, strings.NewReader(strings.Repeat("a", 150))); err != ErrAttachmentBudget {
+		t.Fatal("Expected a known size over the budget to be rejected:", err)
+	}
+	if err := msg.AttachStream("first.bin", "", 60, strings.NewReader(strings.Repeat("a", 60))); err != nil {
+		t.Fatal("Could not attach stream within the budget:", err)
+	}
+
+	// Unknown sizes are read in, up to the remaining budget
+	if err := msg.AttachStream("second.bin", "", -1, strings.NewReader(strings.Repeat("b", 40))); err != nil {
+		t.Fatal("Could not attach stream of unknown size within the budget:", err)
+	}
+	if body := msg.Parts[len(msg.Parts)-1].Body; string(body) != strings.Repeat("b", 40) {
+		t.Fatalf("Expected the stream of unknown size to be read in: %q", body)
+	}
+	if err := msg.AttachStream("third.bin", "", -1, strings.NewReader("c")); err != ErrAttachmentBudget {
+		t.Fatal("Expected an unknown size over the budget to be rejected:", err)
+	}
+	if len(msg.Parts) != 3 {
+		t.Fatal("Expected only the attachments within the budget:", len(msg.Parts))
+	}
+
+	// Without a budget, unknown sizes are streamed
+	AttachmentBudget = 0
+	if err := msg.AttachStream("fourth.bin", "", -1, strings.NewReader("d")); err != nil || msg.Parts[3].BodyReader == nil {
+		t.Fatal("Expected the stream of unknown size to be streamed:", err)
+	}
+}
+
 func testMultipartInlineStructure(t *testing.T, part *Message) {
 
 	// confirm msg's part is empty except two parts
@@ -432,6 +681,11 @@ func testMessageAgainstSelf(t *testing.T, msg *Message) []byte {
 		t.Fatal("Could not parse in message:", err)
 	}
 
+	// the parsed field order is checked by TestParseKeepsPartFieldOrder
+	for _, part := range parsedMsg.MessagesAll() {
+		part.FieldOrder = nil
+	}
+
 	// confirm they are deeply equal
 	if !reflect.DeepEqual(msg, parsedMsg) {
 		t.Fatal("Message does not match its parsed counterpart")
@@ -0,0 +1,189 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultDKIMHeaders are the header fields that Sign signs when none are given.
+var DefaultDKIMHeaders = []string{"From", "To", "Cc", "Subject", "Date", "Message-Id", "MIME-Version", "Content-Type"}
+
+// Sign adds a DKIM-Signature (RFC 6376) before any others, signed with the key for the selector
+// and domain, such as selector "mail" and domain "host.com" for the public key published at
+// "mail._domainkey.host.com". It signs the named header fields (DefaultDKIMHeaders if there are
+// none, and always the From) with relaxed canonicalization, and the body with simple
+// canonicalization, as they are written out by WriteTo and sent with CRLF line endings.
+// Save is called first, so that the Date and Message-Id are stable, and the message must not
+// be changed once it is signed. A message with a streamed BodyReader can not be signed.
+func (m *Message) Sign(selector, domain string, key *rsa.PrivateKey, headers []string) error {
+	if key == nil || len(selector) == 0 || len(domain) == 0 {
+		return errors.New("DKIM signing requires a key, selector, and domain")
+	}
+	for _, msg := range m.MessagesAll() {
+		if msg.Body == nil && msg.BodyReader != nil {
+			return errors.New("May not sign a message with a streamed body")
+		}
+	}
+	if err := m.Save(); err != nil {
+		return err
+	}
+
+	buffer := &bytes.Buffer{}
+	if _, err := m.WriteToWith(buffer, WriteOptions{UseCRLF: true}); err != nil {
+		return err
+	}
+	raw := buffer.Bytes()
+	headerEnd := bytes.Index(raw, []byte("\r\n\r\n"))
+	if headerEnd < 0 {
+		headerEnd = len(raw)
+		raw = append(raw, "\r\n\r\n"...)
+	}
+	fields := dkimHeaderFields(raw[:headerEnd+2])
+
+	if len(headers) == 0 {
+		headers = DefaultDKIMHeaders
+	}
+	signed := make([]string, 0, len(headers)+1)
+	hasFrom := false
+	for _, name := range headers {
+		if !strings.EqualFold(name, "DKIM-Signature") {
+			signed = append(signed, strings.ToLower(name))
+			hasFrom = hasFrom || strings.EqualFold(name, "From")
+		}
+	}
+	if !hasFrom {
+		signed = append(signed, "from")
+	}
+
+	bodyHash := sha256.Sum256(CanonicalizeBodySimple(raw[headerEnd+4:]))
+	signature := fmt.Sprintf("v=1; a=rsa-sha256; c=relaxed/simple; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		domain, selector, Clock().Unix(), strings.Join(signed, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+
+	hash := sha256.New()
+	used := make(map[int]bool)
+	for _, name := range signed {
+		// Repeated fields are signed from the bottom up, and missing ones are skipped
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(fields[i][0], name) {
+				used[i] = true
+				hash.Write([]byte(dkimRelaxedHeader(fields[i][0], fields[i][1]) + "\r\n"))
+				break
+			}
+		}
+	}
+	hash.Write([]byte(dkimRelaxedHeader("DKIM-Signature", signature)))
+
+	b, err := rsa.SignPKCS1v15(Rand, key, crypto.SHA256, hash.Sum(nil))
+	if err != nil {
+		return err
+	}
+	m.Header["Dkim-Signature"] = append([]string{signature + base64.StdEncoding.EncodeToString(b)}, m.Header["Dkim-Signature"]...)
+	return nil
+}
+
+// DKIMSignatures parses each DKIM-Signature header field into a map of its tags to their values,
+// such as "d" to the signing domain, and "b" to the base64 signature. Whitespace from folding
+// is removed from within the "b", "bh", "h", and "z" values, and trimmed from the others.
+// An error is returned if any signature has a malformed or repeated tag.
+func (h Header) DKIMSignatures() ([]map[string]string, error) {
+	signatures := make([]map[string]string, 0, len(h["Dkim-Signature"]))
+	for _, val := range h["Dkim-Signature"] {
+		tags := make(map[string]string)
+		for _, tag := range strings.Split(val, ";") {
+			if len(strings.TrimSpace(tag)) == 0 {
+				continue // the last tag may be followed by a semicolon
+			}
+			equals := strings.IndexByte(tag, '=')
+			if equals < 0 {
+				return nil, &HeaderFieldError{Field: "Dkim-Signature", Err: fmt.Errorf("Tag without a value: %q", strings.TrimSpace(tag))}
+			}
+			name, value := strings.TrimSpace(tag[:equals]), strings.TrimSpace(tag[equals+1:])
+			if _, ok := tags[name]; ok || len(name) == 0 {
+				return nil, &HeaderFieldError{Field: "Dkim-Signature", Err: fmt.Errorf("Invalid or repeated tag: %q", name)}
+			}
+			switch name {
+			case "b", "bh", "h", "z":
+				value = strings.Join(strings.Fields(value), "")
+			}
+			tags[name] = value
+		}
+		signatures = append(signatures, tags)
+	}
+	return signatures, nil
+}
+
+// dkimHeaderFields splits a raw header, with CRLF line endings, into its fields as written,
+// each a pair of the name and the (still folded) value.
+func dkimHeaderFields(raw []byte) [][2]string {
+	var fields [][2]string
+	for _, line := range strings.SplitAfter(string(raw), "\r\n") {
+		if len(line) == 0 {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1][1] += line
+			continue
+		}
+		if colon := strings.IndexByte(line, ':'); colon > 0 {
+			fields = append(fields, [2]string{line[:colon], line[colon+1:]})
+		}
+	}
+	return fields
+}
+
+// dkimRelaxedHeader returns the header field in the relaxed canonical form (RFC 6376 3.4.2),
+// without a line ending: the name in lower-case, and the value unfolded, with runs of
+// whitespace reduced to a single space, and no leading or trailing whitespace.
+func dkimRelaxedHeader(name, value string) string {
+	value = strings.Replace(value, "\r\n", "", -1)
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.Join(strings.FieldsFunc(value, func(r rune) bool {
+		return r == ' ' || r == '\t'
+	}), " ")
+}
+
+// CanonicalizeBodySimple returns the body in the DKIM simple canonical form (RFC 6376 3.4.3),
+// as it is hashed for the "bh=" of a signature: every empty line at the end is removed, and it
+// ends with a single CRLF, even if it is empty. Line endings are normalized to CRLF first.
+func CanonicalizeBodySimple(body []byte) []byte {
+	body = NormalizeCRLF(body)
+	for bytes.HasSuffix(body, []byte("\r\n")) {
+		body = body[:len(body)-2]
+	}
+	return append(body[:len(body):len(body)], "\r\n"...)
+}
+
+// CanonicalizeBodyRelaxed returns the body in the DKIM relaxed canonical form (RFC 6376 3.4.4):
+// whitespace at the end of each line is removed, and other runs of whitespace are reduced to
+// a single space, then every empty line at the end is removed, and a non-empty body ends with
+// a single CRLF. Line endings are normalized to CRLF first.
+func CanonicalizeBodyRelaxed(body []byte) []byte {
+	lines := bytes.Split(NormalizeCRLF(body), []byte("\r\n"))
+	canonical := make([]byte, 0, len(body))
+	blank := 0 // empty lines that are only kept if followed by a line that is not empty
+	for _, line := range lines {
+		line = bytes.TrimRight(dkimWhitespace.ReplaceAll(line, []byte(" ")), " ")
+		if len(line) == 0 {
+			blank++
+			continue
+		}
+		for ; blank > 0; blank-- {
+			canonical = append(canonical, "\r\n"...)
+		}
+		canonical = append(append(canonical, line...), "\r\n"...)
+	}
+	return canonical
+}
+
+// dkimWhitespace matches a run of whitespace within a line.
+var dkimWhitespace = regexp.MustCompile(`[ \t]+`)
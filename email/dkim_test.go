@@ -0,0 +1,207 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestSign ...
+func TestSign(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("Could not generate key:", err)
+	}
+	header := NewHeader("Test Name <test.from@host.com>", "Test Subject with   extra  spaces", "test.to@host.com")
+	header.SetCc("First Recipient <first@host.com>, Second Recipient <second@host.com>, Third Recipient <third@host.com>")
+	msg := NewMessage(header, "text\n\n\n", "<p>html</p>", NewPartAttachmentFromBytes([]byte("attachment"), "file.txt"))
+
+	if err = msg.Sign("mail", "host.com", key, []string{"From", "To", "Cc", "Subject", "Date", "Message-Id", "Reply-To"}); err != nil {
+		t.Fatal("Could not sign message:", err)
+	}
+	if len(msg.Header.MessageID()) == 0 || len(msg.Header.Get("Date")) == 0 {
+		t.Fatal("Expected the message to be saved before signing:", msg.Header)
+	}
+
+	// Sent as written out, with the CRLF line endings that net/smtp converts to
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatal("Could not write message:", err)
+	}
+	sent := strings.Replace(string(raw), "\n", "\r\n", -1)
+	if err = verifyDKIM(sent, &key.PublicKey); err != nil {
+		t.Fatal("Signature does not verify:", err)
+	}
+
+	// Changes to the signed headers or body are detected
+	if err = verifyDKIM(strings.Replace(sent, "Test Subject", "Changed Subject", 1), &key.PublicKey); err == nil {
+		t.Fatal("Expected a changed subject to fail verification")
+	}
+	if err = verifyDKIM(strings.Replace(sent, "<p>html</p>", "<p>HTML</p>", 1), &key.PublicKey); err == nil {
+		t.Fatal("Expected a changed body to fail verification")
+	}
+
+	streamed := NewMessage(NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"), "text", "<p>html</p>")
+	if err = streamed.AttachStream("file.txt", "text/plain", 4, strings.NewReader("data")); err != nil {
+		t.Fatal("Could not attach stream:", err)
+	}
+	if err = streamed.Sign("mail", "host.com", key, nil); err == nil {
+		t.Fatal("Expected an error signing a streamed body")
+	}
+}
+
+// dkimTag matches a tag=value pair of a DKIM-Signature.
+var dkimTag = regexp.MustCompile(`\s*([a-z]+)\s*=\s*([^;]*);?`)
+
+// verifyDKIM verifies the first DKIM-Signature of a raw message, with its own
+// relaxed/simple canonicalization, as a receiving server would.
+func verifyDKIM(raw string, key *rsa.PublicKey) error {
+	parts := strings.SplitN(raw, "\r\n\r\n", 2)
+	var fields []string
+	for _, line := range strings.SplitAfter(parts[0]+"\r\n", "\r\n") {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			fields[len(fields)-1] += line
+		} else if len(line) > 0 {
+			fields = append(fields, line)
+		}
+	}
+	relaxed := func(field string) string {
+		colon := strings.Index(field, ":")
+		value := regexp.MustCompile(`[ \t]+`).ReplaceAllString(strings.Replace(field[colon+1:], "\r\n", "", -1), " ")
+		return strings.ToLower(strings.TrimSpace(field[:colon])) + ":" + strings.TrimSpace(value)
+	}
+
+	var signature string
+	for _, field := range fields {
+		if strings.HasPrefix(strings.ToLower(field), "dkim-signature:") {
+			signature = field
+			break
+		}
+	}
+	if len(signature) == 0 {
+		return errors.New("No DKIM-Signature")
+	}
+	tags := make(map[string]string)
+	for _, match := range dkimTag.FindAllStringSubmatch(strings.Replace(signature[strings.Index(signature, ":")+1:], "\r\n", "", -1), -1) {
+		tags[match[1]] = strings.Join(strings.Fields(match[2]), "")
+	}
+	if tags["v"] != "1" || tags["a"] != "rsa-sha256" || tags["c"] != "relaxed/simple" || tags["d"] != "host.com" || tags["s"] != "mail" {
+		return errors.New("Unexpected DKIM-Signature tags: " + signature)
+	}
+
+	body := parts[1]
+	for strings.HasSuffix(body, "\r\n") {
+		body = strings.TrimSuffix(body, "\r\n")
+	}
+	body += "\r\n"
+	bodyHash := sha256.Sum256([]byte(body))
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return errors.New("Body hash does not match")
+	}
+
+	hash := sha256.New()
+	used := make(map[int]bool)
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.HasPrefix(strings.ToLower(fields[i]), name+":") {
+				used[i] = true
+				hash.Write([]byte(relaxed(fields[i]) + "\r\n"))
+				break
+			}
+		}
+	}
+	hash.Write([]byte(regexp.MustCompile(`b=[^;]*$`).ReplaceAllString(relaxed(signature), "b=")))
+	b, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash.Sum(nil), b)
+}
+
+// TestDKIMSignatures ...
+func TestDKIMSignatures(t *testing.T) {
+	t.Parallel()
+
+	raw := "DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed;\n" +
+		"        d=host.com; s=20161025;\n" +
+		"        h=mime-version:from:date:message-id:subject:to;\n" +
+		"        bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\n" +
+		"        b=Yy1gZ5TPd0daUL4W8XP7X+DxDFdZEAt5gDhGPrUUBmCSE2XG8oAt1FAS9Z6wR0Xj5o\n" +
+		"         kd9q1c0pkYxpUNKxBm4Gdud21EmTWDy5l8Jnr/3cDpXiZDd2iHbRduKYjd0Z7MvRAYME\n" +
+		"         V0Yg==\n" +
+		"DKIM-Signature: v=1; a=rsa-sha256; d=relay.com; s=mail; h=from : to;\n" +
+		"        bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=; b=c2lnbmF0dXJl;\n" +
+		"Subject: Test Subject\n\nbody"
+	msg, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	signatures, err := msg.Header.DKIMSignatures()
+	if err != nil || len(signatures) != 2 {
+		t.Fatal("Could not parse the DKIM signatures:", signatures, err)
+	}
+
+	expected := map[string]string{
+		"v":  "1",
+		"a":  "rsa-sha256",
+		"c":  "relaxed/relaxed",
+		"d":  "host.com",
+		"s":  "20161025",
+		"h":  "mime-version:from:date:message-id:subject:to",
+		"bh": "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=",
+		"b":  "Yy1gZ5TPd0daUL4W8XP7X+DxDFdZEAt5gDhGPrUUBmCSE2XG8oAt1FAS9Z6wR0Xj5okd9q1c0pkYxpUNKxBm4Gdud21EmTWDy5l8Jnr/3cDpXiZDd2iHbRduKYjd0Z7MvRAYMEV0Yg==",
+	}
+	if !reflect.DeepEqual(signatures[0], expected) {
+		t.Fatalf("Expected %q, got %q", expected, signatures[0])
+	}
+	if signatures[1]["d"] != "relay.com" || signatures[1]["h"] != "from:to" || signatures[1]["b"] != "c2lnbmF0dXJl" {
+		t.Fatalf("Incorrect second signature: %q", signatures[1])
+	}
+
+	for _, invalid := range []string{"v=1; a=rsa-sha256; d", "v=1; v=1", "v=1; =value"} {
+		header := Header{"Dkim-Signature": []string{invalid}}
+		if _, err = header.DKIMSignatures(); err == nil {
+			t.Fatal("Expected an error for a malformed signature:", invalid)
+		}
+	}
+}
+
+// TestCanonicalizeBody ...
+func TestCanonicalizeBody(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		body    string
+		simple  string
+		relaxed string
+	}{
+		// The example from RFC 6376 3.4.5
+		{" C \r\nD \t E\r\n\r\n\r\n", " C \r\nD \t E\r\n", " C\r\nD E\r\n"},
+		{"", "\r\n", ""},
+		{"\r\n\r\n", "\r\n", ""},
+		{"no line ending", "no line ending\r\n", "no line ending\r\n"},
+		{"a\r\n\r\n \t \r\nb \r\n \r\n", "a\r\n\r\n \t \r\nb \r\n \r\n", "a\r\n\r\n\r\nb\r\n"},
+		{"lf\n\nline endings\n\n", "lf\r\n\r\nline endings\r\n", "lf\r\n\r\nline endings\r\n"},
+	}
+	for _, test := range tests {
+		if simple := string(CanonicalizeBodySimple([]byte(test.body))); simple != test.simple {
+			t.Fatalf("Expected simple %q for %q, got %q", test.simple, test.body, simple)
+		}
+		if relaxed := string(CanonicalizeBodyRelaxed([]byte(test.body))); relaxed != test.relaxed {
+			t.Fatalf("Expected relaxed %q for %q, got %q", test.relaxed, test.body, relaxed)
+		}
+	}
+}
@@ -6,11 +6,18 @@ package email
 
 import (
 	"bytes"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"mime"
 	"net/mail"
 	"net/textproto"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -70,6 +77,42 @@ func (h Header) Get(key string) string {
 	return v[0]
 }
 
+// GetInt gets the first value associated with the given key as an integer,
+// ignoring surrounding whitespace, or an error if it is missing or not an integer.
+func (h Header) GetInt(key string) (int, error) {
+	val, err := h.getNumeric(key)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, &HeaderFieldError{Field: textproto.CanonicalMIMEHeaderKey(key), Err: fmt.Errorf("Not an integer: %q", val)}
+	}
+	return i, nil
+}
+
+// GetFloat gets the first value associated with the given key as a float,
+// ignoring surrounding whitespace, or an error if it is missing or not a number.
+func (h Header) GetFloat(key string) (float64, error) {
+	val, err := h.getNumeric(key)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, &HeaderFieldError{Field: textproto.CanonicalMIMEHeaderKey(key), Err: fmt.Errorf("Not a number: %q", val)}
+	}
+	return f, nil
+}
+
+// getNumeric ...
+func (h Header) getNumeric(key string) (string, error) {
+	if !h.IsSet(key) {
+		return "", &HeaderFieldError{Field: textproto.CanonicalMIMEHeaderKey(key), Err: ErrHeadersMissingField}
+	}
+	return strings.TrimSpace(h.Get(key)), nil
+}
+
 // IsSet tests if a key is present in the Header
 func (h Header) IsSet(key string) bool {
 	if h == nil {
@@ -79,11 +122,74 @@ func (h Header) IsSet(key string) bool {
 	return ok
 }
 
+// HasAny tests if any of the keys is present in the Header.
+// It is false if there are no keys.
+func (h Header) HasAny(keys ...string) bool {
+	for _, key := range keys {
+		if h.IsSet(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAll tests if all of the keys are present in the Header.
+// It is true if there are no keys.
+func (h Header) HasAll(keys ...string) bool {
+	for _, key := range keys {
+		if !h.IsSet(key) {
+			return false
+		}
+	}
+	return true
+}
+
 // Del deletes the values associated with key.
 func (h Header) Del(key string) {
 	delete(h, textproto.CanonicalMIMEHeaderKey(key))
 }
 
+// Clone returns a deep copy of the header, so that changing the copy,
+// including adding values to an existing key, does not change this header.
+func (h Header) Clone() Header {
+	if h == nil {
+		return nil
+	}
+	clone := make(Header, len(h))
+	for key, values := range h {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+// Merge copies the fields of other into this header, with keys canonicalized like Add and Set,
+// such as to overlay the fields of a message on a set of default fields.
+// With overwrite, each field in other replaces the same field in this header. Without it,
+// the values of fields that may appear more than once, such as Received, are appended, and
+// the fields that may only appear once, such as the From and Subject, are only copied if this
+// header does not have them. The values are copied, so other is not changed by changes to this header.
+func (h Header) Merge(other Header, overwrite bool) {
+	for key, values := range other {
+		key = textproto.CanonicalMIMEHeaderKey(key)
+		switch existing := h[key]; {
+		case len(existing) == 0 || overwrite:
+			h[key] = append([]string(nil), values...)
+		case !isSingletonField(key):
+			h[key] = append(existing, values...)
+		}
+	}
+}
+
+// isSingletonField returns true if the field, by canonical key, may only appear once.
+func isSingletonField(key string) bool {
+	for _, field := range singletonFields {
+		if field == key {
+			return true
+		}
+	}
+	return false
+}
+
 // mail.Header Methods:
 
 // Date parses the Date header field.
@@ -91,213 +197,2175 @@ func (h Header) Date() (time.Time, error) {
 	return mail.Header(h).Date()
 }
 
-// AddressList parses the named header field as a list of addresses.
+// AddressList parses the named header field as a list of addresses,
+// including the addresses within any groups. Obsolete source routes are
+// removed from the addresses, such as "<@relay.com:user@host.com>".
 func (h Header) AddressList(key string) ([]*mail.Address, error) {
-	return mail.Header(h).AddressList(key)
+	val := h.Get(key)
+	if len(val) == 0 {
+		return nil, mail.ErrHeaderNotPresent
+	}
+	return parseAddressList(val)
+}
+
+// obsoleteRoutePattern matches the obsolete source route (RFC 5322 4.4) at the start of an
+// angle-addr, such as "<@relay.com,@gateway.com:" in "<@relay.com,@gateway.com:user@host.com>".
+var obsoleteRoutePattern = regexp.MustCompile(`<[ \t]*@[^<>":;]*:`)
+
+// stripRoutes removes any obsolete source routes from the addresses, leaving the final addr-spec,
+// which net/mail would otherwise reject.
+func stripRoutes(val string) string {
+	if !strings.Contains(val, "@") || !strings.Contains(val, ":") {
+		return val
+	}
+	return obsoleteRoutePattern.ReplaceAllString(val, "<")
+}
+
+// parseAddressList parses the address list like mail.ParseAddressList, without any obsolete source routes.
+func parseAddressList(val string) ([]*mail.Address, error) {
+	return mail.ParseAddressList(stripRoutes(val))
+}
+
+// parseAddress parses the address like mail.ParseAddress, without any obsolete source route.
+func parseAddress(val string) (*mail.Address, error) {
+	return mail.ParseAddress(stripRoutes(val))
+}
+
+// AddressGroup is a named group of addresses within an address list (RFC 5322 3.4),
+// such as "Team: first@host.com, second@host.com;", or the addresses that are not
+// within a group, which have an empty Name. A group may be empty, such as
+// "undisclosed-recipients:;" in place of a list of Bcc recipients.
+type AddressGroup struct {
+	Name      string
+	Addresses []*mail.Address
+}
+
+// AddressGroups parses the named header field as a list of addresses like AddressList,
+// but keeps them within their groups, in order.
+func (h Header) AddressGroups(key string) ([]AddressGroup, error) {
+	return parseAddressGroups(h.Get(key))
+}
+
+// FormatGroup returns the group syntax for a named group of the emails, such as
+// "Team: first@host.com, second@host.com;", for setting an address field,
+// such as SetTo(FormatGroup("Team", "first@host.com", "second@host.com")).
+func FormatGroup(name string, emails ...string) string {
+	if len(emails) == 0 {
+		return quotePhrase(name) + ":;"
+	}
+	return quotePhrase(name) + ": " + strings.Join(emails, ", ") + ";"
+}
+
+// formatAddressGroups returns the address list with its groups, such as after
+// parseAddressGroups, quoting the display names where needed.
+func formatAddressGroups(groups []AddressGroup) string {
+	formatted := make([]string, 0, len(groups))
+	for _, group := range groups {
+		emails := make([]string, 0, len(group.Addresses))
+		for _, address := range group.Addresses {
+			if len(address.Name) == 0 {
+				emails = append(emails, address.Address)
+			} else {
+				emails = append(emails, quotePhrase(address.Name)+" <"+address.Address+">")
+			}
+		}
+		if len(group.Name) > 0 {
+			formatted = append(formatted, FormatGroup(group.Name, emails...))
+		} else if len(emails) > 0 {
+			formatted = append(formatted, strings.Join(emails, ", "))
+		}
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// parseAddressGroups parses an address list into its groups, and the runs of
+// addresses between them, which are groups without a Name.
+func parseAddressGroups(val string) ([]AddressGroup, error) {
+	var groups []AddressGroup
+	add := func(name, list string) error {
+		group := AddressGroup{Name: unquotePhrase(strings.TrimSpace(name))}
+		if len(strings.TrimSpace(list)) > 0 {
+			addresses, err := parseAddressList(list)
+			if err != nil {
+				return err
+			}
+			group.Addresses = addresses
+		}
+		if len(group.Name) > 0 || len(group.Addresses) > 0 {
+			groups = append(groups, group)
+		}
+		return nil
+	}
+
+	// Groups are found by the colon after their name, and the semicolon at their end,
+	// which are not allowed anywhere else, except in quoted-strings, comments, and routes
+	quoted, depth, angled := false, 0, false
+	start, comma, groupStart := 0, -1, -1
+	for i := 0; i < len(val); i++ {
+		c := val[i]
+		switch {
+		case c == '\\' && (quoted || depth > 0):
+			i++
+		case c == '"' && depth == 0:
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case depth > 0:
+		case c == '<':
+			angled = true
+		case c == '>':
+			angled = false
+		case angled:
+		case c == ',' && groupStart < 0:
+			comma = i
+		case c == ':' && groupStart < 0:
+			if comma >= start {
+				if err := add("", val[start:comma]); err != nil {
+					return nil, err
+				}
+				start = comma + 1
+			}
+			if len(strings.TrimSpace(val[start:i])) == 0 {
+				return nil, errors.New("Group without a name")
+			}
+			groupStart = i
+		case c == ';' && groupStart >= 0:
+			if err := add(val[start:groupStart], val[groupStart+1:i]); err != nil {
+				return nil, err
+			}
+			// The next address or group follows a comma
+			i += len(val[i+1:]) - len(strings.TrimLeft(val[i+1:], " \t\r\n"))
+			if i+1 < len(val) && val[i+1] == ',' {
+				i++
+			}
+			start, groupStart = i+1, -1
+		}
+	}
+	if groupStart >= 0 {
+		return nil, errors.New("Group without a semicolon at its end")
+	}
+	if err := add("", val[start:]); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// unquotePhrase returns the display name without the quotes and escapes of a quoted-string.
+func unquotePhrase(name string) string {
+	if len(name) < 2 || name[0] != '"' || name[len(name)-1] != '"' {
+		return name
+	}
+	var unquoted strings.Builder
+	for i := 1; i < len(name)-1; i++ {
+		if name[i] == '\\' && i+1 < len(name)-1 {
+			i++
+		}
+		unquoted.WriteByte(name[i])
+	}
+	return unquoted.String()
 }
 
 // Methods required for sending a message:
 
+// XMailer, if set, is added by Save as the "X-Mailer" of any header without one.
+var XMailer string
+
 // Save adds headers for the "Message-Id", "Date", and "MIME-Version",
-// if missing.  An error is returned if the Message-Id can not be created.
+// and "X-Mailer" if XMailer is set, but only where they are missing,
+// so that values set by the caller are never replaced.
+// An error is returned if the Message-Id can not be created.
 func (h Header) Save() error {
 	if len(h.Get("Message-Id")) == 0 {
 		id, err := GenMessageID()
 		if err != nil {
 			return err
 		}
-		h.Set("Message-Id", "<"+id+">")
+		h.SetMessageID(id)
 	}
 	if len(h.Get("Date")) == 0 {
-		h.Set("Date", time.Now().Format(time.RFC822))
+		h.SetDate(Clock())
+	}
+	if len(h.Get("MIME-Version")) == 0 {
+		h.Set("MIME-Version", "1.0")
+	}
+	if len(XMailer) > 0 && len(h.Get("X-Mailer")) == 0 {
+		h.Set("X-Mailer", XMailer)
 	}
-	h.Set("MIME-Version", "1.0")
 	return nil
 }
 
-// Bytes returns the bytes representing this header.  It is a convenience
-// method that calls WriteTo on a buffer, returning its bytes.
-func (h Header) Bytes() ([]byte, error) {
-	buffer := &bytes.Buffer{}
-	_, err := h.WriteTo(buffer)
-	return buffer.Bytes(), err
+// formatDate formats the time for the Date header field, in its own time zone.
+func formatDate(t time.Time) string {
+	return t.Format(time.RFC1123Z)
 }
 
-// WriteTo writes this header out, including every field except for Bcc.
-func (h Header) WriteTo(w io.Writer) (int64, error) {
-	// TODO: Change how headerWriter decides where to wrap, then switch to MaxHeaderLineLength
-	writer := &headerWriter{w: w, maxLineLen: MaxHeaderTotalLength}
-	var total int64
+// SetDate sets the Date header field to the time, formatted per RFC 5322, in its own time zone.
+func (h Header) SetDate(date time.Time) {
+	h.Set("Date", formatDate(date))
+}
+
+// ValidateOptions controls which optional checks Validate performs.
+type ValidateOptions struct {
+	// AllowMissingSubject permits a header without a Subject.
+	AllowMissingSubject bool
+}
+
+// Validate checks that this header is ready to be sent: it must have a From,
+// and a Sender if the From has more than one address, at least one recipient
+// (To, Cc, or Bcc), and a Subject, and every address field must be a valid address list. The error returned is a *HeaderFieldError
+// naming the offending field.
+func (h Header) Validate() error {
+	return h.ValidateWith(ValidateOptions{})
+}
+
+// ValidateWith checks this header like Validate, using the ValidateOptions.
+func (h Header) ValidateWith(opts ValidateOptions) error {
+	if len(h.Get("From")) == 0 {
+		return &HeaderFieldError{Field: "From", Err: ErrHeadersMissingField}
+	}
+	if from, err := h.AddressList("From"); err == nil && len(from) > 1 && len(h.Get("Sender")) == 0 {
+		return &HeaderFieldError{Field: "Sender", Err: ErrMissingSender}
+	}
+	if len(h.Get("To")) == 0 && len(h.Get("Cc")) == 0 && len(h.Get("Bcc")) == 0 {
+		return &HeaderFieldError{Field: "To", Err: errors.New("Message must have a recipient (To, Cc, or Bcc)")}
+	}
 	for _, field := range sortedHeaderFields(h) {
-		if field == "Bcc" {
-			continue // skip writing out Bcc
+		if classifyHeader(field) != addressListHeader {
+			continue
 		}
 		for _, val := range h[field] {
-			writer.curLineLen = 0 // Reset for next header
-			// write field name
-			written, err := io.WriteString(writer, field + ": ")
-			if err != nil {
-				return total, err
-			}
-			total += int64(written)
-			// write field value
-			emails, err := mail.ParseAddressList(val)
-			if err != nil || len(emails) == 0 {
-				// header is not an address list
-				encodedBytes, err := encode(writer, val)
-				if err != nil {
-					return total, err
-				}
-				total += encodedBytes
-			} else {
-				// header is an address list
-				encodedBytes, err := encodeAddress(writer, emails[0])
-				if err != nil {
-					return total, err
-				}
-				total += encodedBytes
-				for i := 1; i < len(emails); i++ {
-					written, err := io.WriteString(writer, ", ")
-					if err != nil {
-						return total, err
-					}
-					total += int64(written)
-					encodedBytes, err := encodeAddress(writer, emails[i])
-					if err != nil {
-						return total, err
-					}
-					total += encodedBytes
-				}
-			}
-			// write field ending
-			written, err = io.WriteString(writer, "\n")
-			if err != nil {
-				return total, err
+			if _, err := parseAddressList(val); err != nil {
+				return &HeaderFieldError{Field: field, Err: err}
 			}
-			total += int64(written)
 		}
 	}
-	return total, nil
+	if !opts.AllowMissingSubject && len(h.Get("Subject")) == 0 {
+		return &HeaderFieldError{Field: "Subject", Err: ErrHeadersMissingField}
+	}
+	return nil
 }
 
-// encodeAddress writes an email address with a specified writer using MIME B UTF-8 encoding
-func encodeAddress(writer *headerWriter, val *mail.Address) (int64, error) {
-	var total int64
-	encodedBytes, err := encode(writer, val.Name)
-	if err != nil {
-		return total, err
-	}
-	total += encodedBytes
-	if encodedBytes != 0 {
-		val.Address = " <" + val.Address + ">"
-	}
-	encodedBytes, err = encode(writer, val.Address)
-	if err != nil {
-		return total, err
-	}
-	total += encodedBytes
-	return total, nil
+// HeaderFieldError describes a problem with a specific header field.
+type HeaderFieldError struct {
+	Field string
+	Err   error
 }
 
-// encode writes a string with a specified writer using MIME B UTF-8 encoding
-func encode(writer *headerWriter, val string) (int64, error) {
-	var total int64
-	// Using B encoding here
-	written, err := io.WriteString(writer, mime.BEncoding.Encode("UTF-8", val))
-	if err != nil {
-		return total, err
-	}
-	total += int64(written)
-	return total, nil
+// Error ...
+func (e *HeaderFieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
 }
 
-// Convenience Methods:
+// Unwrap returns the underlying error, such as ErrHeadersMissingField.
+func (e *HeaderFieldError) Unwrap() error {
+	return e.Err
+}
 
-// ContentType parses and returns the content media type, any parameters on it,
-// and an error if there is no content type header field.
-func (h Header) ContentType() (string, map[string]string, error) {
-	return h.parseMediaType("Content-Type")
+// singletonFields may appear at most once in a header (RFC 5322 3.6, and RFC 2045).
+var singletonFields = []string{
+	"Date", "From", "Sender", "Reply-To", "To", "Cc", "Bcc", "Message-Id", "In-Reply-To", "References", "Subject",
+	"Mime-Version", "Content-Type", "Content-Transfer-Encoding", "Content-Id", "Content-Disposition",
 }
 
-// ContentDisposition parses and returns the media disposition, any parameters on it,
-// and an error if there is no content disposition header field.
-func (h Header) ContentDisposition() (string, map[string]string, error) {
-	return h.parseMediaType("Content-Disposition")
+// DuplicateFieldsError is returned by Normalize, listing the singleton fields that had
+// more than one value.
+type DuplicateFieldsError struct {
+	Fields []string
 }
 
-// parseMediaType ...
-func (h Header) parseMediaType(typeField string) (string, map[string]string, error) {
-	if content := h.Get(typeField); len(content) > 0 {
-		mediaType, mediaTypeParams, err := mime.ParseMediaType(content)
-		if err != nil {
-			return "", map[string]string{}, err
+// Error ...
+func (e *DuplicateFieldsError) Error() string {
+	return "Header had more than one of: " + strings.Join(e.Fields, ", ")
+}
+
+// Normalize collapses each field that may only appear once, such as the Date, From, Subject,
+// and Message-Id, to its last value if it has more than one, such as from calling Add where
+// Set was meant, since writing them all out would make an invalid message.
+// A *DuplicateFieldsError listing the collapsed fields is returned if there were any.
+func (h Header) Normalize() error {
+	var collapsed []string
+	for _, field := range singletonFields {
+		if values := h[field]; len(values) > 1 {
+			h[field] = values[len(values)-1:]
+			collapsed = append(collapsed, field)
 		}
-		return mediaType, mediaTypeParams, nil
 	}
-	return "", map[string]string{}, ErrHeadersMissingField
+	if len(collapsed) > 0 {
+		return &DuplicateFieldsError{Fields: collapsed}
+	}
+	return nil
 }
 
-// ErrHeadersMissingField ...
-var ErrHeadersMissingField = errors.New("Message missing header field")
+// Bytes returns the bytes representing this header.  It is a convenience
+// method that calls WriteTo on a buffer, returning its bytes.
+func (h Header) Bytes() ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	_, err := h.WriteTo(buffer)
+	return buffer.Bytes(), err
+}
 
-// From ...
-func (h Header) From() string {
-	return h.Get("From")
+// WriteOptions controls how headers and messages are written out.
+// The zero value is what WriteTo uses.
+type WriteOptions struct {
+	// IncludeBcc writes out the Bcc field like any other address field,
+	// such as when saving a sent message to a local folder.
+	// It must not be used when transmitting a message, as it reveals the Bcc recipients.
+	IncludeBcc bool
+
+	// OmitReturnPath leaves out the Return-Path field, which is added on final delivery
+	// (RFC 5321 4.4) from the envelope-from, such as when sending a message with Send,
+	// which uses it for the MAIL FROM.
+	OmitReturnPath bool
+
+	// FieldLess orders the header fields, reporting whether field a is written before field b,
+	// such as to match the output of a specific mailer. The default is alphabetical order,
+	// or the Message.FieldOrder of a parsed message.
+	FieldLess func(a, b string) bool
+
+	// UseCRLF terminates lines with CRLF, as required by MIME (RFC 2045), instead of LF,
+	// including the lines of base64 encoded bodies. Line endings in text bodies, including
+	// those without a Content-Type, are normalized to match, before they are encoded, other
+	// than base64 encoded text, which always has the CRLF line endings of its canonical form.
+	// LF is the default, for the header and body alike, so that a message's line endings are
+	// consistent, as net/smtp (which converts LF to CRLF itself), mbox files, and most local
+	// tools expect.
+	UseCRLF bool
+
+	// Charset is the charset that header values are converted to, and labeled with, when
+	// they are encoded, such as "ISO-8859-1" for legacy recipients. It must be "UTF-8"
+	// (the default if it is empty), or one of the CharsetEncoders.
+	Charset string
+
+	// MaxFields is the maximum number of header fields that can be written out, counting
+	// each value of a field with several values, to catch runaway Adds. Writing more fails
+	// with ErrTooManyHeaderFields. The default if it is 0 is DefaultMaxHeaderFields.
+	MaxFields int
+
+	// MaxLineLength is the length that every header field is folded to where it can be,
+	// such as 72 for legacy gateways, up to the hard limit of MaxHeaderTotalLength.
+	// If it is 0, fields are folded to MaxHeaderLineLength (78), other than the Content-Type,
+	// Content-Disposition, and DKIM-Signature, which are kept as they are written unless
+	// they would be longer than MaxHeaderTotalLength.
+	MaxLineLength int
+
+	// FoldWidths overrides the MaxLineLength for specific header fields, by canonical key,
+	// such as {"Subject": 64} to match the output of a specific mailer in golden file tests.
+	// They are also limited to MaxHeaderTotalLength.
+	FoldWidths map[string]int
+
+	// AssertNoBcc checks the written header for any Bcc or Resent-Bcc field, such as one
+	// injected in another field's value, and fails with ErrBccWritten instead of writing it,
+	// unless IncludeBcc is set. The header is buffered to check it before it is written.
+	AssertNoBcc bool
+
+	// OmitEmpty leaves out the header field values that are empty or only whitespace.
+	// Otherwise they are written as a field without a value, such as "Subject:", which is
+	// how a field that is deliberately empty can be set, with Set(key, ""), as opposed to
+	// one that is not set at all, which IsSet reports.
+	OmitEmpty bool
+
+	// SMTPUTF8 writes non-ASCII header values and display names as raw UTF-8 (RFC 6532),
+	// instead of as encoded-words, which is more readable and compact, for sending to servers
+	// that advertise the SMTPUTF8 extension (RFC 6531). The Charset is then ignored.
+	SMTPUTF8 bool
+
+	// WordEncoding chooses between B and Q encoding for the encoded-words (RFC 2047) of
+	// non-ASCII header values and display names. The default is WordEncodingB.
+	WordEncoding WordEncoding
+
+	// WordEncodings overrides the WordEncoding for specific header fields, by canonical key,
+	// such as {"Subject": WordEncodingQ} to keep a mostly ASCII subject readable.
+	WordEncodings map[string]WordEncoding
+
+	// Strictness checks or repairs what is written against RFC 5322 and RFC 2045,
+	// as described for each Strictness. The default writes it as it is.
+	Strictness Strictness
+
+	fieldOrder []string // the Message.FieldOrder of the header being written
 }
 
-// SetFrom ...
-func (h Header) SetFrom(email string) {
-	h.Set("From", email)
+// ErrBccWritten is returned when writing with WriteOptions.AssertNoBcc would write a Bcc.
+var ErrBccWritten = errors.New("Header would reveal the Bcc recipients")
+
+// bccFieldPattern matches a Bcc or Resent-Bcc field at the start of a written header line.
+var bccFieldPattern = regexp.MustCompile(`(?im)^(resent-)?bcc[ \t]*:`)
+
+// omits returns true if the field is not written with these WriteOptions, which are the
+// Bcc and Resent-Bcc (however their keys are capitalized) unless IncludeBcc is set,
+// and the Return-Path if OmitReturnPath is set.
+// Every write path goes through this, so that none can reveal the Bcc recipients.
+func (opts WriteOptions) omits(field string) bool {
+	switch textproto.CanonicalMIMEHeaderKey(field) {
+	case "Bcc", "Resent-Bcc":
+		return !opts.IncludeBcc
+	case "Return-Path":
+		return opts.OmitReturnPath
+	}
+	return false
 }
 
-// To ...
-func (h Header) To() []string {
-	to := h.Get("To")
-	if to == "" {
-		return []string{}
+// fieldPrefix returns the field name as it is written before the value, which is followed
+// by a space unless the value is empty, so that an empty field has no trailing whitespace.
+func fieldPrefix(field, val string) string {
+	if len(val) == 0 {
+		return field + ":"
 	}
-	return strings.Split(to, ", ")
+	return field + ": "
 }
 
-// SetTo ...
-func (h Header) SetTo(emails ...string) {
-	h.Set("To", strings.Join(emails, ", "))
+// foldWidth returns the width to fold the field to, from the FoldWidths or the MaxLineLength,
+// or 0 for the default.
+func (opts WriteOptions) foldWidth(field string) int {
+	if width, ok := opts.FoldWidths[textproto.CanonicalMIMEHeaderKey(field)]; ok && width > 0 {
+		return width
+	}
+	return opts.MaxLineLength
 }
 
-// Cc ...
-func (h Header) Cc() []string {
-	cc := h.Get("Cc")
-	if cc == "" {
-		return []string{}
+// wordEncoding returns the WordEncoding for the field, from the WordEncodings or the WordEncoding.
+func (opts WriteOptions) wordEncoding(field string) WordEncoding {
+	if encoding, ok := opts.WordEncodings[textproto.CanonicalMIMEHeaderKey(field)]; ok {
+		return encoding
 	}
-	return strings.Split(cc, ", ")
+	return opts.WordEncoding
 }
 
-// SetCc ...
-func (h Header) SetCc(emails ...string) {
-	h.Set("Cc", strings.Join(emails, ", "))
+// WordEncoding is how non-ASCII header values are encoded as encoded-words (RFC 2047).
+// Either way, a value is encoded the other way instead if that is much shorter,
+// such as a value made mostly of emoji, which Q encoding would triple in length.
+type WordEncoding int
+
+const (
+	// WordEncodingB base64 encodes the values, which is the most compact for non-Latin scripts.
+	WordEncodingB WordEncoding = iota
+	// WordEncodingQ Q encodes the values, which keeps their ASCII characters readable,
+	// such as "=?UTF-8?q?Caf=C3=A9?=".
+	WordEncodingQ
+	// WordEncodingShortest uses whichever of B and Q encoding is shorter for each value.
+	WordEncodingShortest
+)
+
+// DefaultMaxHeaderFields is the default WriteOptions.MaxFields.
+const DefaultMaxHeaderFields = 1000
+
+// ErrTooManyHeaderFields is returned when writing a header with more than WriteOptions.MaxFields fields.
+var ErrTooManyHeaderFields = errors.New("Header has too many fields")
+
+// WriteTo writes this header out, including every field except for Bcc.
+func (h Header) WriteTo(w io.Writer) (int64, error) {
+	return h.WriteToWith(w, WriteOptions{})
 }
 
-// Bcc ...
-func (h Header) Bcc() []string {
-	bcc := h.Get("Bcc")
-	if bcc == "" {
-		return []string{}
+// WriteToWith writes this header out, using the WriteOptions.
+func (h Header) WriteToWith(w io.Writer, opts WriteOptions) (int64, error) {
+	switch opts.Strictness {
+	case StrictnessStrict:
+		if !opts.UseCRLF {
+			return 0, ErrBareLF
+		}
+	case StrictnessLenient:
+		opts.UseCRLF = true
 	}
-	return strings.Split(bcc, ", ")
+	return h.writeTo(w, opts, nil)
 }
 
-// SetBcc ...
-func (h Header) SetBcc(emails ...string) {
-	h.Set("Bcc", strings.Join(emails, ", "))
+// HeaderEncoding is how a header field value is written out.
+type HeaderEncoding string
+
+const (
+	// HeaderRaw values are written as they are.
+	HeaderRaw HeaderEncoding = "raw"
+	// HeaderQuoted values have display names written as quoted-strings.
+	HeaderQuoted HeaderEncoding = "quoted"
+	// HeaderBEncoded values have B encoded-words (RFC 2047).
+	HeaderBEncoded HeaderEncoding = "B"
+	// HeaderQEncoded values have Q encoded-words (RFC 2047).
+	HeaderQEncoded HeaderEncoding = "Q"
+)
+
+// headerEncodingRanks order the HeaderEncodings, so that a field is reported with the
+// most significant encoding of any of its parts, such as one of the names in an address list.
+var headerEncodingRanks = map[HeaderEncoding]int{HeaderRaw: 0, HeaderQuoted: 1, HeaderQEncoded: 2, HeaderBEncoded: 2}
+
+// FieldWritePlan describes how a single header field value will be written out.
+type FieldWritePlan struct {
+	Field    string
+	Value    string
+	Encoding HeaderEncoding
+	// Folds are the columns at which the field's lines will be folded, as the number of
+	// octets on each line before the fold, including the field name on the first line.
+	Folds []int
+	// Written is the number of octets written for the field, including its name and line endings.
+	Written int
 }
 
-// Subject ...
-func (h Header) Subject() string {
-	return h.Get("Subject")
+// DryRun returns how each header field value would be written out by WriteToWith with the
+// WriteOptions, in the same order, but without writing anything, such as for debugging why a
+// header looks wrong in a mail client.
+func (h Header) DryRun(opts WriteOptions) ([]FieldWritePlan, error) {
+	var plans []FieldWritePlan
+	_, err := h.writeTo(ioutil.Discard, opts, func(plan FieldWritePlan) {
+		plans = append(plans, plan)
+	})
+	return plans, err
 }
 
-// SetSubject ...
-func (h Header) SetSubject(subject string) {
-	h.Set("Subject", subject)
+// FieldOverhead is how much a single header field value grew when it was encoded.
+type FieldOverhead struct {
+	Field   string
+	Raw     int // the octets of the value as it is in the Header
+	Encoded int // the octets of the value as it is written, without line endings
+}
+
+// Growth returns how much the value grew when it was encoded, as a fraction of its raw
+// length, such as 0.4 for a Subject that is 40% longer when base64 encoded.
+func (o FieldOverhead) Growth() float64 {
+	if o.Raw == 0 {
+		return 0
+	}
+	return float64(o.Encoded-o.Raw) / float64(o.Raw)
+}
+
+// EncodingOverhead returns the raw and encoded lengths of each header field value, as it
+// would be written by WriteToWith with the WriteOptions, in the same order as DryRun,
+// such as to find the fields that grow the most from encoded-words.
+func (h Header) EncodingOverhead(opts WriteOptions) ([]FieldOverhead, error) {
+	plans, err := h.DryRun(opts)
+	if err != nil {
+		return nil, err
+	}
+	overheads := make([]FieldOverhead, 0, len(plans))
+	for _, plan := range plans {
+		lineEndings := (len(plan.Folds) + 1) * len(newline(opts.UseCRLF))
+		overheads = append(overheads, FieldOverhead{
+			Field:   plan.Field,
+			Raw:     len(plan.Value),
+			Encoded: plan.Written - len(fieldPrefix(plan.Field, plan.Value)) - lineEndings,
+		})
+	}
+	return overheads, nil
+}
+
+// writeTo writes this header out, using the WriteOptions, reporting how each field value
+// was written, if report is not nil.
+func (h Header) writeTo(w io.Writer, opts WriteOptions, report func(FieldWritePlan)) (int64, error) {
+	if opts.AssertNoBcc && !opts.IncludeBcc {
+		opts.AssertNoBcc = false
+		buffer := &bytes.Buffer{}
+		if _, err := h.writeTo(buffer, opts, report); err != nil {
+			return 0, err
+		}
+		if bccFieldPattern.Match(buffer.Bytes()) {
+			return 0, ErrBccWritten
+		}
+		return buffer.WriteTo(w)
+	}
+	writer := &headerWriter{w: w, crlf: opts.UseCRLF}
+	maxFields := opts.MaxFields
+	if maxFields <= 0 {
+		maxFields = DefaultMaxHeaderFields
+	}
+	count := 0
+	for _, values := range h {
+		count += len(values)
+	}
+	if count > maxFields {
+		return 0, ErrTooManyHeaderFields
+	}
+
+	fields := sortedHeaderFields(h)
+	if opts.FieldLess != nil {
+		sort.SliceStable(fields, func(i, j int) bool { return opts.FieldLess(fields[i], fields[j]) })
+		opts.fieldOrder = nil
+	}
+	var total int64
+	for _, entry := range orderedFieldValues(h, fields, opts.fieldOrder) {
+		field, val := entry.field, entry.val
+		if opts.omits(field) {
+			continue // skip writing out Bcc
+		}
+		fieldOpts := opts
+		fieldOpts.WordEncoding = opts.wordEncoding(field)
+		if opts.OmitEmpty && len(strings.TrimSpace(val)) == 0 {
+			continue
+		}
+		class := classifyHeader(field)
+		writer.fold = headerFolders[class]
+		// Every field folds at the usual length where it can, never inside an address or encoded-word,
+		// other than those written verbatim, which are only folded where they must be
+		// or between their RFC 2231 continuations, which are split so that they can be
+		writer.maxLineLen = MaxHeaderLineLength
+		if verbatimFields[textproto.CanonicalMIMEHeaderKey(field)] && !rfc2231ContinuationPattern.MatchString(val) {
+			writer.maxLineLen = MaxHeaderTotalLength
+		}
+		if width := opts.foldWidth(field); width > 0 {
+			writer.maxLineLen = width
+			if writer.maxLineLen > MaxHeaderTotalLength {
+				writer.maxLineLen = MaxHeaderTotalLength
+			}
+		}
+		writer.encoding = HeaderRaw
+		// write field name
+		_, err := io.WriteString(writer, fieldPrefix(field, val))
+		if err != nil {
+			return total, err
+		}
+		// write field value
+		var groups []AddressGroup
+		if class == addressListHeader {
+			groups, err = parseAddressGroups(val)
+		}
+		if err != nil || len(groups) == 0 {
+			// header is not an address list
+			_, err = encode(writer, val, fieldOpts)
+		} else {
+			// header is an address list
+			_, err = encodeAddressGroups(writer, groups, fieldOpts)
+		}
+		if err != nil {
+			return total, err
+		}
+		// write field, folded and terminated
+		written, err := writer.Flush()
+		total += int64(written)
+		if err == ErrHeaderLineTooLong {
+			return total, &HeaderFieldError{Field: field, Err: err}
+		}
+		if err != nil {
+			return total, err
+		}
+		if report != nil {
+			report(FieldWritePlan{Field: field, Value: val, Encoding: writer.encoding, Folds: writer.folds, Written: written})
+		}
+	}
+	return total, nil
+}
+
+// fieldValue is one value of a header field, to be written out on its own line.
+type fieldValue struct {
+	field string
+	val   string
+}
+
+// orderedFieldValues returns the values of the header fields in the order they are written out:
+// in the order of their keys, which has a key for each value, such as from Message.FieldOrder,
+// with any extra values of a field written along with its last one in the order, followed by
+// the values of the fields that are not in it at all, in the order of the fields.
+func orderedFieldValues(h Header, fields []string, order []string) []fieldValue {
+	last := make(map[string]int, len(order))
+	for i, key := range order {
+		last[key] = i
+	}
+	values := make([]fieldValue, 0, len(order))
+	written := make(map[string]int, len(last))
+	for i, key := range order {
+		remaining := h[key][written[key]:]
+		if len(remaining) == 0 {
+			continue // a value that has since been removed
+		}
+		if last[key] != i {
+			remaining = remaining[:1]
+		}
+		for _, val := range remaining {
+			values = append(values, fieldValue{field: key, val: val})
+		}
+		written[key] += len(remaining)
+	}
+	for _, field := range fields {
+		if _, ok := last[field]; ok {
+			continue
+		}
+		for _, val := range h[field] {
+			values = append(values, fieldValue{field: field, val: val})
+		}
+	}
+	return values
+}
+
+// headerClass is the syntactic class of a header field,
+// which determines how its value is encoded and where it may be folded.
+type headerClass int
+
+const (
+	unstructuredHeader headerClass = iota
+	structuredHeader
+	addressListHeader
+)
+
+// headerClasses classifies the header fields with a known syntax, by canonical key.
+// Any field not listed is treated as unstructured.
+var headerClasses = map[string]headerClass{
+	"From":                        addressListHeader,
+	"Sender":                      addressListHeader,
+	"Reply-To":                    addressListHeader,
+	"To":                          addressListHeader,
+	"Cc":                          addressListHeader,
+	"Bcc":                         addressListHeader,
+	"Resent-From":                 addressListHeader,
+	"Resent-Sender":               addressListHeader,
+	"Resent-To":                   addressListHeader,
+	"Resent-Cc":                   addressListHeader,
+	"Resent-Bcc":                  addressListHeader,
+	"Disposition-Notification-To": addressListHeader,
+	"Date":                        structuredHeader,
+	"Resent-Date":                 structuredHeader,
+	"Message-Id":                  structuredHeader,
+	"Resent-Message-Id":           structuredHeader,
+	"In-Reply-To":                 structuredHeader,
+	"References":                  structuredHeader,
+	"Return-Path":                 structuredHeader,
+	"Received":                    structuredHeader,
+	"Mime-Version":                structuredHeader,
+	"Content-Type":                structuredHeader,
+	"Content-Disposition":         structuredHeader,
+	"Content-Transfer-Encoding":   structuredHeader,
+	"Content-Id":                  structuredHeader,
+	"Dkim-Signature":              structuredHeader,
+}
+
+// verbatimFields are the header fields, by canonical key, that are kept as they were parsed,
+// or that a signature may cover as they were written (with "simple" canonicalization),
+// so they are not folded unless they are longer than MaxHeaderTotalLength.
+var verbatimFields = map[string]bool{
+	"Content-Type":        true,
+	"Content-Disposition": true,
+	"Dkim-Signature":      true,
+}
+
+// rfc2231ContinuationPattern matches the first section of a parameter that is split into
+// continuations (RFC 2231 3), such as `; filename*0*=`.
+var rfc2231ContinuationPattern = regexp.MustCompile(`;[ \t]*[^ \t;=*]+\*0\*?[ \t]*=`)
+
+// headerFolders are the folding strategies for each class of header field:
+// address lists fold between addresses, structured fields between parameters,
+// and unstructured fields at any whitespace.
+var headerFolders = map[headerClass]foldFunc{
+	unstructuredHeader: foldUnstructured,
+	structuredHeader:   foldAfter(';'),
+	addressListHeader:  foldBetweenAddresses,
+}
+
+// classifyHeader returns the headerClass of the header field.
+func classifyHeader(field string) headerClass {
+	return headerClasses[textproto.CanonicalMIMEHeaderKey(field)]
+}
+
+// encodeAddressGroups writes an address list with a specified writer, separating the
+// addresses with commas, and writing each group as its name, a colon, its addresses, and a semicolon.
+func encodeAddressGroups(writer *headerWriter, groups []AddressGroup, opts WriteOptions) (int64, error) {
+	var total int64
+	write := func(s string) error {
+		written, err := io.WriteString(writer, s)
+		total += int64(written)
+		return err
+	}
+	for i, group := range groups {
+		if i > 0 {
+			if err := write(", "); err != nil {
+				return total, err
+			}
+		}
+		if len(group.Name) > 0 {
+			encodedBytes, err := encodePhrase(writer, group.Name, opts)
+			total += encodedBytes
+			if err != nil {
+				return total, err
+			}
+			if err = write(":"); err != nil {
+				return total, err
+			}
+		}
+		for j, address := range group.Addresses {
+			separator := ", "
+			if j == 0 {
+				separator = ""
+				if len(group.Name) > 0 {
+					separator = " "
+				}
+			}
+			if err := write(separator); err != nil {
+				return total, err
+			}
+			encodedBytes, err := encodeAddress(writer, address, opts)
+			total += encodedBytes
+			if err != nil {
+				return total, err
+			}
+		}
+		if len(group.Name) > 0 {
+			if err := write(";"); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// encodeAddress writes an email address with a specified writer using MIME B or Q encoding,
+// or as raw UTF-8 with WriteOptions.SMTPUTF8
+func encodeAddress(writer *headerWriter, val *mail.Address, opts WriteOptions) (int64, error) {
+	if len(val.Name) == 0 {
+		return encode(writer, val.Address, opts)
+	}
+	var total int64
+	encodedBytes, err := encodePhrase(writer, val.Name, opts)
+	if err != nil {
+		return total, err
+	}
+	total += encodedBytes
+	encodedBytes, err = encode(writer, " <"+val.Address+">", opts)
+	if err != nil {
+		return total, err
+	}
+	total += encodedBytes
+	return total, nil
+}
+
+// encodePhrase writes a display name with a specified writer, quoted if needed,
+// or using MIME B or Q encoding if it is not ASCII, unless with WriteOptions.SMTPUTF8
+func encodePhrase(writer *headerWriter, name string, opts WriteOptions) (int64, error) {
+	if !isASCII(name) && !opts.SMTPUTF8 {
+		return encode(writer, name, opts)
+	}
+	// ASCII names only need quoting, and encoded-words are reserved for the rest
+	phrase := quotePhrase(name)
+	if phrase != name {
+		writer.noteEncoding(HeaderQuoted)
+	}
+	written, err := io.WriteString(writer, phrase)
+	return int64(written), err
+}
+
+// atextSpecials are the characters other than letters and digits allowed in an atom (RFC 5322 3.2.3).
+const atextSpecials = "!#$%&'*+-/=?^_`{|}~"
+
+// quotePhrase returns the display name as it is if it is made of atoms separated by
+// single spaces, or otherwise as a quoted-string (RFC 5322 3.2.4), such as "Doe, John".
+// Non-ASCII characters are allowed in atoms (RFC 6532 3.2).
+func quotePhrase(name string) string {
+	for _, atom := range strings.Split(name, " ") {
+		if len(atom) == 0 {
+			return quoteString(name)
+		}
+		for i := 0; i < len(atom); i++ {
+			c := atom[i]
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c >= 0x80 || strings.IndexByte(atextSpecials, c) >= 0) {
+				return quoteString(name)
+			}
+		}
+	}
+	return name
+}
+
+// encode writes a string with a specified writer using MIME B or Q encoding, per the WordEncoding,
+// in the WriteOptions.Charset (or UTF-8 if it is empty), or as raw UTF-8 with WriteOptions.SMTPUTF8
+func encode(writer *headerWriter, val string, opts WriteOptions) (int64, error) {
+	var total int64
+	encoded := val
+	if !opts.SMTPUTF8 {
+		preferred := mime.BEncoding
+		if opts.WordEncoding == WordEncodingQ {
+			preferred = mime.QEncoding
+		}
+		var err error
+		encoded, err = encodeCharsetWords(val, opts.Charset, preferred)
+		if err != nil {
+			return total, err
+		}
+		if opts.WordEncoding == WordEncodingShortest && encoded != val {
+			if alternative, _ := encodeCharsetWords(val, opts.Charset, mime.QEncoding); len(alternative) < len(encoded) {
+				encoded = alternative
+			}
+		}
+		if encoded != val {
+			// Encoded-words are "=?charset?encoding?text?="
+			encoder := mime.BEncoding
+			if strings.EqualFold(strings.SplitN(encoded, "?", 4)[2], "q") {
+				encoder = mime.QEncoding
+				writer.noteEncoding(HeaderQEncoded)
+			} else {
+				writer.noteEncoding(HeaderBEncoded)
+			}
+			// Size the words to the fold width, as the field is only folded between them,
+			// with continuation lines indented by a space. Only the start of the value is known
+			// to be on the first line, as an address list may be folded before any address.
+			first := writer.maxLineLen - 1
+			if writer.atValueStart() {
+				first = writer.maxLineLen - len(writer.field)
+			}
+			encoded, err = splitEncodedWords(val, opts.Charset, encoder, first, writer.maxLineLen-1)
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+	written, err := io.WriteString(writer, encoded)
+	if err != nil {
+		return total, err
+	}
+	total += int64(written)
+	return total, nil
+}
+
+// maxEncodedExpansion is the largest multiple of its raw length that a header
+// value may grow to when encoded, before the other encoding is tried instead.
+const maxEncodedExpansion = 2
+
+// encodeCharsetWords returns the value as RFC 2047 encoded-words, if it needs encoding,
+// after converting it to the charset (or UTF-8 if it is empty), which labels the words,
+// using the preferred encoder, unless that grows the value by more than
+// maxEncodedExpansion and the other encoding (B or Q) is more compact,
+// such as when Q encoding a value made mostly of emoji.
+// Long values are split into several encoded-words, separated by spaces.
+func encodeCharsetWords(val string, charset string, preferred mime.WordEncoder) (string, error) {
+	charset, converted, err := convertCharset(val, charset)
+	if err != nil {
+		return "", err
+	}
+	encoded := preferred.Encode(charset, converted)
+	if len(encoded) > maxEncodedExpansion*len(converted) {
+		other := mime.QEncoding
+		if preferred == mime.QEncoding {
+			other = mime.BEncoding
+		}
+		if alternative := other.Encode(charset, converted); len(alternative) < len(encoded) {
+			return alternative, nil
+		}
+	}
+	return encoded, nil
+}
+
+// maxEncodedWordLength is the maximum length of an RFC 2047 encoded-word.
+const maxEncodedWordLength = 75
+
+// splitEncodedWords returns the value as RFC 2047 encoded-words separated by spaces, using the
+// encoder and the charset (or UTF-8 if it is empty), where the first word is at most first
+// characters long, so that it fits on the rest of the line, and the others at most limit
+// (and never more than maxEncodedWordLength). Words are only split between whole characters,
+// so that each can be decoded on its own. If not even one character fits in the first word,
+// it is sized like the others instead, as the field can be folded before it.
+func splitEncodedWords(val string, charset string, encoder mime.WordEncoder, first, limit int) (string, error) {
+	charset, _, err := convertCharset(val, charset)
+	if err != nil {
+		return "", err
+	}
+	if limit > maxEncodedWordLength {
+		limit = maxEncodedWordLength
+	}
+	if first > limit {
+		first = limit
+	}
+	prefix := "=?" + charset + "?" + string([]byte{byte(encoder)}) + "?"
+	overhead := len(prefix) + len("?=")
+
+	var words []string
+	var raw []byte
+	wordLimit, textLen := first, 0
+	for _, r := range val {
+		_, char, err := convertCharset(string(r), charset)
+		if err != nil {
+			return "", err
+		}
+		grown := textLen + qEncodedLength(char)
+		if encoder == mime.BEncoding {
+			grown = base64.StdEncoding.EncodedLen(len(raw) + len(char))
+		}
+		if overhead+grown > wordLimit && len(raw) > 0 {
+			words = append(words, prefix+encodeWordText(encoder, raw)+"?=")
+			raw, wordLimit = raw[:0], limit
+			grown -= textLen
+			if encoder == mime.BEncoding {
+				grown = base64.StdEncoding.EncodedLen(len(char))
+			}
+		} else if overhead+grown > wordLimit && len(words) == 0 {
+			wordLimit = limit
+		}
+		raw = append(raw, char...)
+		textLen = grown
+	}
+	words = append(words, prefix+encodeWordText(encoder, raw)+"?=")
+	return strings.Join(words, " "), nil
+}
+
+// qEncodedLength returns the length of the raw bytes when Q encoded.
+func qEncodedLength(raw string) int {
+	n := 0
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == ' ' || isQLiteral(raw[i]) {
+			n++
+		} else {
+			n += 3
+		}
+	}
+	return n
+}
+
+// isQLiteral returns whether the byte is written as it is in Q encoded text, as by mime.QEncoding.
+func isQLiteral(b byte) bool {
+	return b >= '!' && b <= '~' && b != '=' && b != '?' && b != '_'
+}
+
+// encodeWordText returns the raw bytes B or Q encoded as the text of an encoded-word.
+func encodeWordText(encoder mime.WordEncoder, raw []byte) string {
+	if encoder == mime.BEncoding {
+		return base64.StdEncoding.EncodeToString(raw)
+	}
+	var text strings.Builder
+	for _, b := range raw {
+		switch {
+		case b == ' ':
+			text.WriteByte('_')
+		case isQLiteral(b):
+			text.WriteByte(b)
+		default:
+			fmt.Fprintf(&text, "=%02X", b)
+		}
+	}
+	return text.String()
+}
+
+// CharsetEncoders convert UTF-8 strings to other charsets, by upper-case charset name,
+// for encoding header values with WriteOptions.Charset. More can be added, such as
+// "SHIFT_JIS" with a converter from golang.org/x/text/encoding/japanese.
+var CharsetEncoders = map[string]func(val string) ([]byte, error){
+	"ISO-8859-1": encodeLatin1,
+}
+
+// convertCharset returns the canonical charset name (UTF-8 if it is empty), and the value
+// converted to it, or an error if the charset is unknown or can not represent the value.
+func convertCharset(val string, charset string) (string, string, error) {
+	charset = strings.ToUpper(strings.TrimSpace(charset))
+	if len(charset) == 0 || charset == "UTF-8" || isASCII(val) {
+		if len(charset) == 0 {
+			charset = "UTF-8"
+		}
+		return charset, val, nil
+	}
+	encoder, ok := CharsetEncoders[charset]
+	if !ok {
+		return "", "", fmt.Errorf("Unknown charset: %q", charset)
+	}
+	converted, err := encoder(val)
+	if err != nil {
+		return "", "", err
+	}
+	return charset, string(converted), nil
+}
+
+// encodeLatin1 converts the value to ISO-8859-1, which has the first 256 code points of Unicode.
+func encodeLatin1(val string) ([]byte, error) {
+	converted := make([]byte, 0, len(val))
+	for _, r := range val {
+		if r > 0xff {
+			return nil, fmt.Errorf("Can not convert %q to ISO-8859-1", r)
+		}
+		converted = append(converted, byte(r))
+	}
+	return converted, nil
+}
+
+// CharsetDecoders convert other charsets to UTF-8 strings, by upper-case charset name,
+// for decoding RFC 2231 parameter values, such as a filename, and text bodies with PlainText
+// and HTML. More can be added, such as "SHIFT_JIS" with a converter from
+// golang.org/x/text/encoding/japanese.
+var CharsetDecoders = map[string]func(raw []byte) (string, error){
+	"ISO-8859-1": decodeLatin1,
+}
+
+// decodeCharset returns the raw bytes in the charset converted to UTF-8, or an error if the
+// charset is unknown. An empty charset is treated as UTF-8, as is US-ASCII.
+func decodeCharset(raw []byte, charset string) (string, error) {
+	charset = strings.ToUpper(strings.TrimSpace(charset))
+	if len(charset) == 0 || charset == "UTF-8" || charset == "US-ASCII" {
+		return string(raw), nil
+	}
+	decoder, ok := CharsetDecoders[charset]
+	if !ok {
+		return "", fmt.Errorf("Unknown charset: %q", charset)
+	}
+	return decoder(raw)
+}
+
+// decodeLatin1 converts ISO-8859-1 to UTF-8, as each of its bytes is that Unicode code point.
+func decodeLatin1(raw []byte) (string, error) {
+	runes := make([]rune, len(raw))
+	for i, b := range raw {
+		runes[i] = rune(b)
+	}
+	return string(runes), nil
+}
+
+// Convenience Methods:
+
+// ContentType parses and returns the content media type, any parameters on it,
+// and an error if there is no content type header field.
+func (h Header) ContentType() (string, map[string]string, error) {
+	return h.parseMediaType("Content-Type")
+}
+
+// SetContentType sets the content media type with any parameters on it, quoting the
+// parameter values as needed and encoding any that are not ASCII according to RFC 2231,
+// with any that are too long for a line split into continuations,
+// or returns an error if they are invalid.
+func (h Header) SetContentType(mediaType string, params map[string]string) error {
+	return h.setMediaType("Content-Type", mediaType, params)
+}
+
+// ContentDisposition parses and returns the media disposition, any parameters on it,
+// and an error if there is no content disposition header field.
+func (h Header) ContentDisposition() (string, map[string]string, error) {
+	return h.parseMediaType("Content-Disposition")
+}
+
+// SetContentDisposition sets the media disposition with any parameters on it, such
+// as a filename, quoting the parameter values as needed and encoding any that are
+// not ASCII according to RFC 2231, with any that are too long for a line split into
+// continuations, or returns an error if they are invalid.
+func (h Header) SetContentDisposition(disposition string, params map[string]string) error {
+	return h.setMediaType("Content-Disposition", disposition, params)
+}
+
+// transferEncodings are the known values of the Content-Transfer-Encoding (RFC 2045).
+var transferEncodings = []string{"7bit", "8bit", "binary", "base64", "quoted-printable"}
+
+// ContentTransferEncoding returns the Content-Transfer-Encoding in lower-case,
+// which defaults to "7bit" if it is missing, or an empty string if it is unknown.
+func (h Header) ContentTransferEncoding() string {
+	if !h.IsSet("Content-Transfer-Encoding") {
+		return "7bit"
+	}
+	return knownValue(h.Get("Content-Transfer-Encoding"), transferEncodings)
+}
+
+// SetContentTransferEncoding sets the Content-Transfer-Encoding, which must be one
+// of "7bit", "8bit", "binary", "base64", or "quoted-printable".
+func (h Header) SetContentTransferEncoding(encoding string) error {
+	value := knownValue(encoding, transferEncodings)
+	if len(value) == 0 {
+		return fmt.Errorf("Invalid Content-Transfer-Encoding: %q", encoding)
+	}
+	h.Set("Content-Transfer-Encoding", value)
+	return nil
+}
+
+// parseMediaType ...
+func (h Header) parseMediaType(typeField string) (string, map[string]string, error) {
+	if content := h.Get(typeField); len(content) > 0 {
+		mediaType, mediaTypeParams, err := mime.ParseMediaType(content)
+		if err != nil {
+			return "", map[string]string{}, err
+		}
+		for key, val := range mediaTypeParams {
+			// Encoded-words are not allowed in parameters, but are common in filenames
+			mediaTypeParams[key] = decodeRFC2047(val)
+		}
+		for key, val := range decodeRFC2231Params(content) {
+			mediaTypeParams[key] = val
+		}
+		return mediaType, mediaTypeParams, nil
+	}
+	return "", map[string]string{}, ErrHeadersMissingField
+}
+
+// rfc2231Section is one section of a parameter value that is split into continuations (RFC 2231 3),
+// such as `filename*1*=%E9.txt`, where extended sections are percent-encoded.
+type rfc2231Section struct {
+	value    string
+	extended bool
+}
+
+// decodeRFC2231Params returns the parameters of a Content-Type or Content-Disposition that have
+// continuations or a charset (RFC 2231), reassembled and decoded to UTF-8 using CharsetDecoders,
+// which mime.ParseMediaType only does for UTF-8 and US-ASCII. Any that can not be decoded,
+// such as with an unknown charset or a missing section, are left as mime.ParseMediaType has them.
+func decodeRFC2231Params(content string) map[string]string {
+	sections := make(map[string]map[int]rfc2231Section)
+	for _, param := range splitQuoted(content, ';')[1:] {
+		equals := strings.IndexByte(param, '=')
+		star := strings.IndexByte(param, '*')
+		if equals < 0 || star < 0 || star > equals {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(param[:star]))
+		suffix := strings.TrimSpace(param[star+1 : equals])
+		section := rfc2231Section{value: strings.TrimSpace(param[equals+1:])}
+		if section.extended = strings.HasSuffix(suffix, "*") || len(suffix) == 0; section.extended {
+			suffix = strings.TrimSuffix(suffix, "*")
+		}
+		index := 0
+		if len(suffix) > 0 {
+			var err error
+			if index, err = strconv.Atoi(suffix); err != nil || index < 0 {
+				continue
+			}
+		}
+		if !section.extended {
+			section.value = unquotePhrase(section.value)
+		}
+		if sections[name] == nil {
+			sections[name] = make(map[int]rfc2231Section)
+		}
+		sections[name][index] = section
+	}
+
+	params := make(map[string]string, len(sections))
+	for name, parts := range sections {
+		charset := ""
+		if first := parts[0]; first.extended {
+			// The first section starts with the charset and language, such as "iso-8859-1'fr'"
+			if fields := strings.SplitN(first.value, "'", 3); len(fields) == 3 {
+				charset, parts[0] = fields[0], rfc2231Section{value: fields[2], extended: true}
+			}
+		}
+		var raw []byte
+		complete := true
+		for i := 0; i < len(parts); i++ {
+			part, ok := parts[i]
+			if !ok {
+				complete = false // a section is missing
+				break
+			}
+			if !part.extended {
+				raw = append(raw, part.value...)
+				continue
+			}
+			unescaped, err := url.PathUnescape(part.value)
+			if err != nil {
+				complete = false
+				break
+			}
+			raw = append(raw, unescaped...)
+		}
+		if !complete {
+			continue
+		}
+		if value, err := decodeCharset(raw, charset); err == nil {
+			params[name] = value
+		}
+	}
+	return params
+}
+
+// splitQuoted splits the value at each separator that is not in a quoted-string,
+// such as a media type from its parameters at each semicolon.
+func splitQuoted(val string, separator byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(val); i++ {
+		switch c := val[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == separator && !quoted:
+			parts = append(parts, val[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, val[start:])
+}
+
+// setMediaType sets the media type with any parameters on it, encoding the
+// parameter values that are not ASCII according to RFC 2231.
+func (h Header) setMediaType(typeField string, mediaType string, params map[string]string) error {
+	asciiParams := make(map[string]string, len(params))
+	var extendedParams []string
+	for attribute, value := range params {
+		// Values that are too long are split into continuations, unless FormatMediaType would reject them
+		long := len(attribute)+len(quoteString(value))+1 > maxParamLength && isPrintableASCII(value)
+		if isASCII(value) && !long {
+			asciiParams[attribute] = value
+		} else {
+			extendedParams = append(extendedParams, attribute)
+		}
+	}
+	content := mime.FormatMediaType(mediaType, asciiParams)
+	if len(content) == 0 {
+		return fmt.Errorf("Invalid %s: %q", typeField, mediaType)
+	}
+	sort.Strings(extendedParams)
+	for _, attribute := range extendedParams {
+		if !isToken(attribute) {
+			return fmt.Errorf("Invalid %s parameter: %q", typeField, attribute)
+		}
+		content += "; " + formatParam(strings.ToLower(attribute), params[attribute])
+	}
+	h.Set(typeField, content)
+	return nil
+}
+
+// ErrHeadersMissingField ...
+var ErrHeadersMissingField = errors.New("Message missing header field")
+
+// ErrMissingSender is returned for a message with more than one From address and no Sender,
+// which RFC 5322 3.6.2 requires to identify the one who sent it.
+var ErrMissingSender = errors.New("Message with more than one From address must have a Sender")
+
+// From ...
+func (h Header) From() string {
+	return h.Get("From")
+}
+
+// SetFrom ...
+func (h Header) SetFrom(email string) {
+	h.Set("From", email)
+}
+
+// Sender ...
+func (h Header) Sender() string {
+	return h.Get("Sender")
+}
+
+// SetSender sets the single mailbox responsible for sending the message,
+// which is required when the From has more than one address.
+func (h Header) SetSender(email string) {
+	h.Set("Sender", email)
+}
+
+// ReplyTo returns the addresses that replies should be sent to, instead of the From.
+func (h Header) ReplyTo() []string {
+	return splitHeaderList(h.Get("Reply-To"))
+}
+
+// SetReplyTo sets the addresses that replies should be sent to, instead of the From,
+// such as a support address. No addresses removes the field.
+func (h Header) SetReplyTo(emails ...string) {
+	if len(emails) == 0 {
+		h.Del("Reply-To")
+		return
+	}
+	h.Set("Reply-To", strings.Join(emails, ", "))
+}
+
+// ReturnPath returns the bare envelope-from address in the Return-Path, without surrounding
+// angle brackets. It is empty for the null reverse-path "<>", or if there is no Return-Path.
+func (h Header) ReturnPath() string {
+	path := strings.TrimSpace(h.Get("Return-Path"))
+	if address, err := parseAddress(path); err == nil {
+		return address.Address
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(path, "<"), ">"))
+}
+
+// SetReturnPath sets the Return-Path, which Send uses as the envelope-from, such as a
+// VERP address for bounce handling. It is written with angle brackets, per RFC 5321,
+// so an empty email sets the null reverse-path "<>".
+func (h Header) SetReturnPath(email string) {
+	if address, err := parseAddress(email); err == nil {
+		email = address.Address
+	}
+	h.Set("Return-Path", "<"+strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(email), "<"), ">"))+">")
+}
+
+// To ...
+func (h Header) To() []string {
+	return splitHeaderList(h.Get("To"))
+}
+
+// SetTo ...
+func (h Header) SetTo(emails ...string) {
+	h.Set("To", strings.Join(emails, ", "))
+}
+
+// Cc ...
+func (h Header) Cc() []string {
+	return splitHeaderList(h.Get("Cc"))
+}
+
+// SetCc ...
+func (h Header) SetCc(emails ...string) {
+	h.Set("Cc", strings.Join(emails, ", "))
+}
+
+// Bcc ...
+func (h Header) Bcc() []string {
+	return splitHeaderList(h.Get("Bcc"))
+}
+
+// SetBcc ...
+func (h Header) SetBcc(emails ...string) {
+	h.Set("Bcc", strings.Join(emails, ", "))
+}
+
+// ToAddresses parses the To like AddressList, including the addresses within any groups,
+// so that a quoted display name may have a comma. There are none if it is missing.
+func (h Header) ToAddresses() ([]*mail.Address, error) {
+	return h.fieldAddresses("To")
+}
+
+// AddTo adds the addresses to the end of the To, other than any it already has,
+// and writes it out again with the display names quoted where needed.
+// The header is left unchanged if the To is not a valid address list.
+func (h Header) AddTo(addresses ...*mail.Address) error {
+	return h.addAddresses("To", addresses)
+}
+
+// RemoveTo removes each of the emails from the To, wherever it is, comparing the addresses
+// in lower-case, and removes the field if it is left empty. Groups are kept, even if they
+// are left empty. The header is left unchanged if the To is not a valid address list.
+func (h Header) RemoveTo(emails ...string) error {
+	return h.removeAddresses("To", emails)
+}
+
+// CcAddresses parses the Cc like ToAddresses.
+func (h Header) CcAddresses() ([]*mail.Address, error) {
+	return h.fieldAddresses("Cc")
+}
+
+// AddCc adds the addresses to the end of the Cc like AddTo.
+func (h Header) AddCc(addresses ...*mail.Address) error {
+	return h.addAddresses("Cc", addresses)
+}
+
+// RemoveCc removes each of the emails from the Cc like RemoveTo.
+func (h Header) RemoveCc(emails ...string) error {
+	return h.removeAddresses("Cc", emails)
+}
+
+// BccAddresses parses the Bcc like ToAddresses.
+func (h Header) BccAddresses() ([]*mail.Address, error) {
+	return h.fieldAddresses("Bcc")
+}
+
+// AddBcc adds the addresses to the end of the Bcc like AddTo.
+func (h Header) AddBcc(addresses ...*mail.Address) error {
+	return h.addAddresses("Bcc", addresses)
+}
+
+// RemoveBcc removes each of the emails from the Bcc like RemoveTo.
+func (h Header) RemoveBcc(emails ...string) error {
+	return h.removeAddresses("Bcc", emails)
+}
+
+// fieldAddresses parses the address list field, which has no addresses if it is missing or empty.
+func (h Header) fieldAddresses(field string) ([]*mail.Address, error) {
+	if len(strings.TrimSpace(h.Get(field))) == 0 {
+		return nil, nil
+	}
+	addresses, err := h.AddressList(field)
+	if err != nil {
+		return nil, &HeaderFieldError{Field: field, Err: err}
+	}
+	return addresses, nil
+}
+
+// addAddresses adds the addresses to the end of the address list field, after any groups,
+// other than those that it already has, comparing them in lower-case.
+func (h Header) addAddresses(field string, addresses []*mail.Address) error {
+	groups, err := parseAddressGroups(h.Get(field))
+	if err != nil {
+		return &HeaderFieldError{Field: field, Err: err}
+	}
+	seen := make(map[string]bool)
+	for _, group := range groups {
+		for _, address := range group.Addresses {
+			seen[strings.ToLower(address.Address)] = true
+		}
+	}
+	added := AddressGroup{}
+	for _, address := range addresses {
+		if key := strings.ToLower(address.Address); !seen[key] {
+			seen[key] = true
+			added.Addresses = append(added.Addresses, address)
+		}
+	}
+	if len(added.Addresses) == 0 {
+		return nil
+	}
+	h.Set(field, formatAddressGroups(append(groups, added)))
+	return nil
+}
+
+// removeAddresses removes each of the emails from the address list field, including from within
+// groups, comparing them in lower-case, and removes the field if it is left empty.
+func (h Header) removeAddresses(field string, emails []string) error {
+	groups, err := parseAddressGroups(h.Get(field))
+	if err != nil {
+		return &HeaderFieldError{Field: field, Err: err}
+	}
+	removing := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		if address, err := parseAddress(email); err == nil {
+			email = address.Address
+		}
+		removing[strings.ToLower(strings.TrimSpace(email))] = true
+	}
+	changed := false
+	for i, group := range groups {
+		kept := make([]*mail.Address, 0, len(group.Addresses))
+		for _, address := range group.Addresses {
+			if removing[strings.ToLower(address.Address)] {
+				changed = true
+				continue
+			}
+			kept = append(kept, address)
+		}
+		groups[i].Addresses = kept
+	}
+	if !changed {
+		return nil
+	}
+	if val := formatAddressGroups(groups); len(val) > 0 {
+		h.Set(field, val)
+	} else {
+		h.Del(field)
+	}
+	return nil
+}
+
+// Recipients returns every address in the To, Cc, and Bcc, including those in groups,
+// in order and without duplicates, which are addresses that are the same in lower-case.
+func (h Header) Recipients() ([]*mail.Address, error) {
+	recipients := make([]*mail.Address, 0, 1)
+	seen := make(map[string]bool)
+	for _, field := range []string{"To", "Cc", "Bcc"} {
+		if len(strings.TrimSpace(h.Get(field))) == 0 {
+			continue
+		}
+		addresses, err := h.AddressList(field)
+		if err != nil {
+			return nil, &HeaderFieldError{Field: field, Err: err}
+		}
+		for _, address := range addresses {
+			if key := strings.ToLower(address.Address); !seen[key] {
+				seen[key] = true
+				recipients = append(recipients, address)
+			}
+		}
+	}
+	return recipients, nil
+}
+
+// DedupeRecipients removes each address from the Bcc that is also in the To or Cc, and from
+// the Cc that is also in the To, comparing them in lower-case, so that a Bcc recipient is not
+// revealed by also being a visible one. Groups are kept, even if they are left empty,
+// and any field that is left empty is removed. It returns the
+// addresses that were removed, and leaves the header unchanged if any of the recipient fields
+// is not a valid address list. It is never done by Save or Send, so it must be called explicitly.
+func (h Header) DedupeRecipients() ([]*mail.Address, error) {
+	fields := []string{"To", "Cc", "Bcc"}
+	parsed := make(map[string][]AddressGroup, len(fields))
+	for _, field := range fields {
+		if len(strings.TrimSpace(h.Get(field))) == 0 {
+			continue
+		}
+		groups, err := h.AddressGroups(field)
+		if err != nil {
+			return nil, &HeaderFieldError{Field: field, Err: err}
+		}
+		parsed[field] = groups
+	}
+
+	var removed []*mail.Address
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		groups, ok := parsed[field]
+		if !ok {
+			continue
+		}
+		changed := false
+		fieldSeen := make(map[string]bool)
+		for i, group := range groups {
+			kept := make([]*mail.Address, 0, len(group.Addresses))
+			for _, address := range group.Addresses {
+				key := strings.ToLower(address.Address)
+				if seen[key] {
+					removed = append(removed, address)
+					changed = true
+					continue
+				}
+				fieldSeen[key] = true
+				kept = append(kept, address)
+			}
+			groups[i].Addresses = kept
+		}
+		for key := range fieldSeen {
+			seen[key] = true
+		}
+		if !changed {
+			continue
+		}
+		if val := formatAddressGroups(groups); len(val) > 0 {
+			h.Set(field, val)
+		} else {
+			h.Del(field)
+		}
+	}
+	return removed, nil
+}
+
+// RedirectScheme rewrites each recipient address for RedirectRecipientsWith onto the test domain,
+// such as RedirectKeepDomain, which RedirectRecipients uses, or RedirectReplaceDomain.
+type RedirectScheme func(address string, testDomain string) string
+
+// RedirectKeepDomain rewrites the address onto the test domain, keeping the original domain
+// in the local part, such as alice@prod.com to alice_at_prod.com@testDomain.
+func RedirectKeepDomain(address string, testDomain string) string {
+	return strings.Replace(address, "@", "_at_", -1) + "@" + testDomain
+}
+
+// RedirectReplaceDomain rewrites the address onto the test domain, replacing the original
+// domain, such as alice@prod.com to alice@testDomain.
+func RedirectReplaceDomain(address string, testDomain string) string {
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		address = address[:at]
+	}
+	return address + "@" + testDomain
+}
+
+// RedirectRecipients rewrites every To, Cc, and Bcc address onto the testDomain with
+// RedirectKeepDomain, like RedirectRecipientsWith.
+func (h Header) RedirectRecipients(testDomain string) error {
+	return h.RedirectRecipientsWith(testDomain, RedirectKeepDomain)
+}
+
+// RedirectRecipientsWith rewrites every To, Cc, and Bcc address onto the testDomain using the
+// scheme, keeping their display names, such as to keep a staging environment from
+// mailing real recipients. The From and all other fields are untouched. The header is left
+// unchanged if any of the recipient fields is not a valid address list.
+func (h Header) RedirectRecipientsWith(testDomain string, scheme RedirectScheme) error {
+	redirected := make(map[string]string)
+	for _, field := range []string{"To", "Cc", "Bcc"} {
+		if len(strings.TrimSpace(h.Get(field))) == 0 {
+			continue
+		}
+		addresses, err := h.AddressList(field)
+		if err != nil {
+			return &HeaderFieldError{Field: field, Err: err}
+		}
+		emails := make([]string, 0, len(addresses))
+		for _, address := range addresses {
+			email := scheme(address.Address, testDomain)
+			if len(address.Name) > 0 {
+				email = quotePhrase(address.Name) + " <" + email + ">"
+			}
+			emails = append(emails, email)
+		}
+		redirected[field] = strings.Join(emails, ", ")
+	}
+	for field, val := range redirected {
+		h.Set(field, val)
+	}
+	return nil
+}
+
+// MessageID returns the Message-Id, without surrounding angle brackets.
+func (h Header) MessageID() string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(h.Get("Message-Id")), "<"), ">")
+}
+
+// SetMessageID sets the Message-Id, such as one from GenMessageID, adding
+// surrounding angle brackets if they are absent. An empty id removes the field.
+func (h Header) SetMessageID(id string) {
+	id = strings.TrimSpace(id)
+	if len(id) == 0 {
+		h.Del("Message-Id")
+		return
+	}
+	if !strings.HasPrefix(id, "<") {
+		id = "<" + id
+	}
+	if !strings.HasSuffix(id, ">") {
+		id += ">"
+	}
+	h.Set("Message-Id", id)
+}
+
+// msgIDPattern matches a msg-id in angle brackets, such as "<1234.5678@host.com>".
+var msgIDPattern = regexp.MustCompile(`<([^<>\s]+)>`)
+
+// InReplyTo returns the Message-Ids of the messages that this one replies to, from the
+// In-Reply-To, without surrounding angle brackets.
+func (h Header) InReplyTo() []string {
+	return parseMsgIDs(h.Get("In-Reply-To"))
+}
+
+// parseMsgIDs returns the msg-ids in the header field value, without surrounding angle brackets.
+func parseMsgIDs(val string) []string {
+	val = stripComments(val)
+	ids := []string{}
+	for _, match := range msgIDPattern.FindAllStringSubmatch(val, -1) {
+		ids = append(ids, match[1])
+	}
+	if len(ids) == 0 {
+		return strings.Fields(val) // written without angle brackets by some clients
+	}
+	return ids
+}
+
+// SetInReplyTo sets the In-Reply-To to the Message-Ids of the messages that this one replies to,
+// usually just the one, adding surrounding angle brackets if they are absent.
+// No ids removes the field.
+func (h Header) SetInReplyTo(ids ...string) {
+	bracketed := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(id), "<"), ">"); len(id) > 0 {
+			bracketed = append(bracketed, "<"+id+">")
+		}
+	}
+	if len(bracketed) == 0 {
+		h.Del("In-Reply-To")
+		return
+	}
+	h.Set("In-Reply-To", strings.Join(bracketed, " "))
+}
+
+// References returns the Message-Ids in the References, of the messages in the conversation
+// that this one is part of, from the first, without surrounding angle brackets.
+func (h Header) References() []string {
+	return parseMsgIDs(h.Get("References"))
+}
+
+// AddReference appends the Message-Id to the References, adding surrounding angle brackets
+// if they are absent, unless it is already there. To thread a reply, add the ThreadIDs of
+// the message being replied to, followed by its Message-Id, which the In-Reply-To is set to.
+func (h Header) AddReference(id string) {
+	id = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(id), "<"), ">")
+	if len(id) == 0 {
+		return
+	}
+	references := h.References()
+	for _, reference := range references {
+		if reference == id {
+			return
+		}
+	}
+	references = append(references, id)
+	h.Set("References", "<"+strings.Join(references, "> <")+">")
+}
+
+// ThreadIDs returns the Message-Ids of the messages in the conversation that this one is
+// part of, from the References and then the In-Reply-To, without any duplicates or surrounding
+// angle brackets, so that the last is usually the message that this one replies to.
+func (h Header) ThreadIDs() []string {
+	ids := []string{}
+	seen := make(map[string]bool)
+	for _, id := range append(h.References(), h.InReplyTo()...) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// MIMEVersion returns the MIME-Version without any comments, such as "1.0",
+// and whether it is present, as a message without it is not a MIME message.
+func (h Header) MIMEVersion() (string, bool) {
+	if !h.IsSet("MIME-Version") {
+		return "", false
+	}
+	return strings.Join(strings.Fields(stripComments(h.Get("MIME-Version"))), ""), true
+}
+
+// stripComments removes any comments (RFC 5322 3.2.2), which are in potentially
+// nested parentheses, from the header field value.
+func stripComments(val string) string {
+	var stripped strings.Builder
+	depth := 0
+	for i := 0; i < len(val); i++ {
+		switch c := val[i]; {
+		case c == '\\' && depth > 0:
+			i++ // skip the escaped character
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			stripped.WriteByte(c)
+		}
+	}
+	return stripped.String()
+}
+
+// splitHeaderList splits a comma-separated header field value into its trimmed, non-empty
+// entries, such as addresses, without splitting on commas in quoted-strings or comments.
+func splitHeaderList(val string) []string {
+	entries := []string{}
+	add := func(entry string) {
+		if entry = strings.TrimSpace(entry); len(entry) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+	quoted := false
+	depth := 0 // of nested comments
+	start := 0
+	for i := 0; i < len(val); i++ {
+		switch c := val[i]; {
+		case c == '\\' && (quoted || depth > 0):
+			i++ // skip the escaped character
+		case c == '"' && depth == 0:
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+		case c == ')' && !quoted && depth > 0:
+			depth--
+		case c == ',' && !quoted && depth == 0:
+			add(val[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(val) {
+		add(val[start:])
+	}
+	return entries
+}
+
+// Subject ...
+func (h Header) Subject() string {
+	return h.Get("Subject")
+}
+
+// SetSubject ...
+func (h Header) SetSubject(subject string) {
+	h.Set("Subject", subject)
+}
+
+// ListUnsubscribe returns the URLs in the List-Unsubscribe header field (RFC 2369),
+// such as mailto and https URLs, without surrounding angle brackets.
+func (h Header) ListUnsubscribe() []string {
+	return h.listURLs("List-Unsubscribe")
+}
+
+// listURLs returns the URLs in a List-* header field (RFC 2369), without angle brackets.
+func (h Header) listURLs(field string) []string {
+	var urls []string
+	val := h.Get(field)
+	for {
+		start := strings.IndexByte(val, '<')
+		end := strings.IndexByte(val, '>')
+		if start < 0 || end < start {
+			return urls
+		}
+		urls = append(urls, strings.TrimSpace(val[start+1:end]))
+		val = val[end+1:]
+	}
+}
+
+// SetListUnsubscribe sets the List-Unsubscribe header field (RFC 2369) to the URLs,
+// which are surrounded by angle brackets and separated by commas.
+func (h Header) SetListUnsubscribe(urls ...string) {
+	h.Set("List-Unsubscribe", "<"+strings.Join(urls, ">, <")+">")
+}
+
+// ListUnsubscribePost returns whether the List-Unsubscribe-Post header field requests
+// one-click unsubscribe (RFC 8058), using a POST to the https List-Unsubscribe URL.
+func (h Header) ListUnsubscribePost() bool {
+	return strings.TrimSpace(h.Get("List-Unsubscribe-Post")) == "List-Unsubscribe=One-Click"
+}
+
+// SetListUnsubscribePost sets the List-Unsubscribe-Post header field to request
+// one-click unsubscribe (RFC 8058), which also requires an https List-Unsubscribe URL.
+func (h Header) SetListUnsubscribePost() {
+	h.Set("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+}
+
+// listURL returns the first URL in a List-* header field, or an empty string if there is none.
+func (h Header) listURL(field string) string {
+	if urls := h.listURLs(field); len(urls) > 0 {
+		return urls[0]
+	}
+	return ""
+}
+
+// listIDPattern matches a list id (RFC 2919), such as "team.lists.host.com".
+var listIDPattern = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+/=?^_` + "`" + `{|}~-]+(\.[A-Za-z0-9!#$%&'*+/=?^_` + "`" + `{|}~-]+)+$`)
+
+// ListID returns the list id in the List-Id header field (RFC 2919), without
+// its description or angle brackets, such as "team.lists.host.com".
+func (h Header) ListID() string {
+	val := h.Get("List-Id")
+	start := strings.LastIndexByte(val, '<')
+	end := strings.LastIndexByte(val, '>')
+	if start < 0 || end < start {
+		return strings.TrimSpace(val)
+	}
+	return strings.TrimSpace(val[start+1 : end])
+}
+
+// ListPost returns the URL in the List-Post header field (RFC 2369), such as a mailto URL,
+// without angle brackets, or an empty string if it is missing or is "NO" (posting is not allowed).
+func (h Header) ListPost() string {
+	return h.listURL("List-Post")
+}
+
+// ListHelp returns the URL in the List-Help header field (RFC 2369), without angle brackets.
+func (h Header) ListHelp() string {
+	return h.listURL("List-Help")
+}
+
+// ListArchive returns the URL in the List-Archive header field (RFC 2369), without angle brackets.
+func (h Header) ListArchive() string {
+	return h.listURL("List-Archive")
+}
+
+// SetListHeaders sets the List-Id (RFC 2919), List-Post, List-Help, and List-Archive (RFC 2369)
+// header fields for a mailing list. The listID is the list id, such as "team.lists.host.com",
+// optionally after an ASCII description, such as "Team List <team.lists.host.com>".
+// The others are URLs, such as "mailto:team@lists.host.com" or "https://lists.host.com/team",
+// which are surrounded by angle brackets. The post may be "NO" if posting is not allowed,
+// and any that are empty are removed. Nothing is set if any of them is invalid.
+func (h Header) SetListHeaders(listID, post, help, archive string) error {
+	description, id := "", strings.TrimSpace(listID)
+	if start := strings.IndexByte(id, '<'); start >= 0 && strings.HasSuffix(id, ">") {
+		description, id = strings.TrimSpace(id[:start]), id[start+1:len(id)-1]
+	}
+	if !listIDPattern.MatchString(id) || !isPrintableASCII(description) {
+		return fmt.Errorf("Invalid List-Id: %q", listID)
+	}
+	urls := []struct{ field, url string }{{"List-Post", post}, {"List-Help", help}, {"List-Archive", archive}}
+	for _, u := range urls {
+		if len(u.url) > 0 && !(u.field == "List-Post" && u.url == "NO") && !validListURL(u.url) {
+			return fmt.Errorf("Invalid %s: %q", u.field, u.url)
+		}
+	}
+
+	if len(description) > 0 {
+		h.Set("List-Id", quotePhrase(description)+" <"+id+">")
+	} else {
+		h.Set("List-Id", "<"+id+">")
+	}
+	for _, u := range urls {
+		switch {
+		case len(u.url) == 0:
+			h.Del(u.field)
+		case u.url == "NO":
+			h.Set(u.field, u.url)
+		default:
+			h.Set(u.field, "<"+u.url+">")
+		}
+	}
+	return nil
+}
+
+// validListURL returns true if the URL is absolute, such as a mailto or https URL,
+// and can be surrounded by angle brackets in a List-* header field.
+func validListURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && len(u.Scheme) > 0 && (len(u.Opaque) > 0 || len(u.Host) > 0) &&
+		isPrintableASCII(rawURL) && !strings.ContainsAny(rawURL, "<> ")
+}
+
+// Priority is the urgency of a message, as set in several header fields read by mail clients.
+type Priority int
+
+const (
+	// PriorityNormal is the default, for a message without any priority header fields
+	PriorityNormal Priority = iota
+	// PriorityHigh ...
+	PriorityHigh
+	// PriorityLow ...
+	PriorityLow
+)
+
+// Priority returns the priority from the X-Priority header field, or if it is missing or
+// unknown, then from the Importance, or then from the X-MSMail-Priority header field.
+func (h Header) Priority() Priority {
+	if xPriority := strings.TrimSpace(stripComments(h.Get("X-Priority"))); len(xPriority) > 0 {
+		switch xPriority[0] {
+		case '1', '2':
+			return PriorityHigh
+		case '3':
+			return PriorityNormal
+		case '4', '5':
+			return PriorityLow
+		}
+	}
+	for _, field := range []string{"Importance", "X-MSMail-Priority"} {
+		switch strings.ToLower(strings.TrimSpace(h.Get(field))) {
+		case "high":
+			return PriorityHigh
+		case "normal":
+			return PriorityNormal
+		case "low":
+			return PriorityLow
+		}
+	}
+	return PriorityNormal
+}
+
+// SetPriority sets the X-Priority, Importance, and X-MSMail-Priority header fields
+// to the priority, so that it is consistent for all mail clients.
+func (h Header) SetPriority(priority Priority) {
+	switch priority {
+	case PriorityHigh:
+		h.Set("X-Priority", "1 (Highest)")
+		h.Set("Importance", "high")
+		h.Set("X-MSMail-Priority", "High")
+	case PriorityLow:
+		h.Set("X-Priority", "5 (Lowest)")
+		h.Set("Importance", "low")
+		h.Set("X-MSMail-Priority", "Low")
+	default:
+		h.Set("X-Priority", "3 (Normal)")
+		h.Set("Importance", "normal")
+		h.Set("X-MSMail-Priority", "Normal")
+	}
+}
+
+// sensitivities are the known values of the Sensitivity header field (RFC 2156).
+var sensitivities = []string{"Personal", "Private", "Company-Confidential"}
+
+// Sensitivity returns the Sensitivity header field, or an empty string
+// if it is missing or is not one of the known values.
+func (h Header) Sensitivity() string {
+	return knownValue(h.Get("Sensitivity"), sensitivities)
+}
+
+// SetSensitivity sets the Sensitivity header field, which must be one of
+// "Personal", "Private", or "Company-Confidential".
+func (h Header) SetSensitivity(sensitivity string) error {
+	value := knownValue(sensitivity, sensitivities)
+	if len(value) == 0 {
+		return fmt.Errorf("Invalid Sensitivity: %q", sensitivity)
+	}
+	h.Set("Sensitivity", value)
+	return nil
+}
+
+// autoSubmittedValues are the known values of the Auto-Submitted header field (RFC 3834).
+var autoSubmittedValues = []string{"no", "auto-generated", "auto-replied"}
+
+// AutoSubmitted returns the Auto-Submitted header field without any parameters or comments,
+// which defaults to "no" if it is missing, or an empty string if it is unknown.
+func (h Header) AutoSubmitted() string {
+	if !h.IsSet("Auto-Submitted") {
+		return "no"
+	}
+	value := strings.SplitN(stripComments(h.Get("Auto-Submitted")), ";", 2)[0]
+	return knownValue(value, autoSubmittedValues)
+}
+
+// SetAutoSubmitted sets the Auto-Submitted header field, which must be one of
+// "no", "auto-generated" (such as for notifications), or "auto-replied" (such as
+// for vacation responses), so that responders do not reply to automated messages.
+func (h Header) SetAutoSubmitted(value string) error {
+	known := knownValue(value, autoSubmittedValues)
+	if len(known) == 0 {
+		return fmt.Errorf("Invalid Auto-Submitted: %q", value)
+	}
+	h.Set("Auto-Submitted", known)
+	return nil
+}
+
+// ReceivedInfo is a Received trace header field (RFC 5321 4.4), such as one added by each
+// server that relayed a message. Any part that is missing or can not be parsed is left empty.
+type ReceivedInfo struct {
+	From string    // the host that sent the message, without any comment of its address
+	By   string    // the host that received the message
+	With string    // the protocol, such as "ESMTPS"
+	ID   string    // the id given to the message by the receiving host
+	For  string    // the recipient address, without angle brackets
+	Date time.Time // the time it was received
+}
+
+// Received returns the Received header fields in order, from the most recent hop,
+// which is at the top of the header, to the first.
+func (h Header) Received() []ReceivedInfo {
+	received := make([]ReceivedInfo, 0, len(h["Received"]))
+	for _, val := range h["Received"] {
+		var info ReceivedInfo
+		clauses := val
+		if semicolon := strings.LastIndex(val, ";"); semicolon >= 0 {
+			clauses = val[:semicolon]
+			if date, err := mail.ParseDate(strings.TrimSpace(stripComments(val[semicolon+1:]))); err == nil {
+				info.Date = date
+			}
+		}
+		words := strings.Fields(stripComments(clauses))
+		for i := 0; i+1 < len(words); i++ {
+			target := (*string)(nil)
+			switch strings.ToLower(words[i]) {
+			case "from":
+				target = &info.From
+			case "by":
+				target = &info.By
+			case "with":
+				target = &info.With
+			case "id":
+				target = &info.ID
+			case "for":
+				target = &info.For
+			}
+			if target != nil && len(*target) == 0 {
+				i++
+				*target = words[i]
+			}
+		}
+		info.For = strings.TrimSuffix(strings.TrimPrefix(info.For, "<"), ">")
+		received = append(received, info)
+	}
+	return received
+}
+
+// ResentBlock is the set of Resent-* header fields (RFC 5322 3.6.6) added each time a message
+// was resent, such as when it was forwarded on unchanged. Address fields are as they are in the
+// header, and the Message-Id is without angle brackets. Any field missing from the block is empty.
+type ResentBlock struct {
+	Date      time.Time
+	From      string
+	Sender    string
+	To        string
+	Cc        string
+	Bcc       string
+	MessageID string
+}
+
+// resentFields are the fields of a ResentBlock, which must each have a Resent-Date and Resent-From.
+var resentFields = []string{"Resent-Date", "Resent-From", "Resent-Sender", "Resent-To", "Resent-Cc", "Resent-Bcc", "Resent-Message-Id"}
+
+// ResentBlocks returns the Resent-* blocks in order, from the most recent, which is at the top of
+// the header, to the first. As a Header does not keep the order of different fields, the values
+// of each field are matched up by their order, so each field must be in every block or in none
+// of them. An error is returned otherwise, or if a Resent-Date can not be parsed.
+// Message.ResentBlocks groups the fields of a parsed message by their order instead.
+func (h Header) ResentBlocks() ([]ResentBlock, error) {
+	count := len(h["Resent-Date"])
+	if len(h["Resent-From"]) != count {
+		return nil, &HeaderFieldError{Field: "Resent-From", Err: errors.New("Every Resent block must have a Resent-Date and Resent-From")}
+	}
+	for _, field := range resentFields {
+		if n := len(h[field]); n != 0 && n != count {
+			return nil, &HeaderFieldError{Field: field, Err: fmt.Errorf("Can not tell which of the %d Resent blocks have this field", count)}
+		}
+	}
+
+	blocks := make([]ResentBlock, count)
+	for i := range blocks {
+		block, err := newResentBlock(func(field string) string {
+			if values := h[field]; len(values) > 0 {
+				return values[i]
+			}
+			return ""
+		})
+		if err != nil {
+			return nil, err
+		}
+		blocks[i] = block
+	}
+	return blocks, nil
+}
+
+// newResentBlock returns the ResentBlock with the value of each of its fields,
+// or an error if the Resent-Date can not be parsed.
+func newResentBlock(value func(field string) string) (ResentBlock, error) {
+	date, err := mail.ParseDate(strings.TrimSpace(stripComments(value("Resent-Date"))))
+	if err != nil {
+		return ResentBlock{}, &HeaderFieldError{Field: "Resent-Date", Err: err}
+	}
+	trimmed := func(field string) string {
+		return strings.TrimSpace(value(field))
+	}
+	return ResentBlock{
+		Date:      date,
+		From:      trimmed("Resent-From"),
+		Sender:    trimmed("Resent-Sender"),
+		To:        trimmed("Resent-To"),
+		Cc:        trimmed("Resent-Cc"),
+		Bcc:       trimmed("Resent-Bcc"),
+		MessageID: strings.TrimSuffix(strings.TrimPrefix(trimmed("Resent-Message-Id"), "<"), ">"),
+	}, nil
 }
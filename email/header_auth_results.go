@@ -0,0 +1,127 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AuthResult is the result of one authentication method in an Authentication-Results
+// header field (RFC 8601), such as an SPF, DKIM, or DMARC check.
+type AuthResult struct {
+	Method string // such as "spf", "dkim", or "dmarc"
+	Result string // such as "pass", "fail", "softfail", "neutral", "none", "temperror", or "permerror"
+	Reason string // optional, such as "signature verified"
+	// Properties are what the result applies to, by "ptype.property", such as
+	// "smtp.mailfrom" for SPF, "header.d" for DKIM, or "header.from" for DMARC.
+	Properties map[string]string
+}
+
+// AuthenticationResults is a parsed Authentication-Results header field.
+type AuthenticationResults struct {
+	AuthServID string // the host that evaluated the results, such as "mx.host.com"
+	Results    []AuthResult
+}
+
+// AddAuthenticationResult adds an Authentication-Results header field (RFC 8601) before any
+// others, recording the results of checks that were evaluated elsewhere by the authservID,
+// such as "mx.host.com". With no results, it records that none were done. The properties of
+// each result are written in order of their names. An error is returned if the authservID,
+// or a method, result, or property name, is not a valid token.
+func (h Header) AddAuthenticationResult(authservID string, results []AuthResult) error {
+	if !isToken(authservID) {
+		return fmt.Errorf("Invalid Authentication-Results authserv-id: %q", authservID)
+	}
+	resinfos := []string{authservID}
+	if len(results) == 0 {
+		resinfos = append(resinfos, "none")
+	}
+	for _, result := range results {
+		if !isToken(result.Method) || !isToken(result.Result) {
+			return fmt.Errorf("Invalid Authentication-Results method and result: %q=%q", result.Method, result.Result)
+		}
+		resinfo := strings.ToLower(result.Method) + "=" + strings.ToLower(result.Result)
+		if len(result.Reason) > 0 {
+			resinfo += " reason=" + authResultValue(result.Reason)
+		}
+		names := make([]string, 0, len(result.Properties))
+		for name := range result.Properties {
+			if dot := strings.IndexByte(name, '.'); dot <= 0 || dot == len(name)-1 || !isToken(name) {
+				return fmt.Errorf("Invalid Authentication-Results property: %q", name)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			resinfo += " " + name + "=" + authResultValue(result.Properties[name])
+		}
+		resinfos = append(resinfos, resinfo)
+	}
+	h["Authentication-Results"] = append([]string{strings.Join(resinfos, "; ")}, h["Authentication-Results"]...)
+	return nil
+}
+
+// authResultValue returns the value as it is, if it is a token or an address, or as a quoted-string.
+func authResultValue(val string) string {
+	if isToken(strings.Replace(val, "@", "a", 1)) {
+		return val
+	}
+	return quoteString(val)
+}
+
+// AuthenticationResults parses each Authentication-Results header field, from the most recent,
+// which is at the top of the header. Comments are removed, and quoted values are unquoted.
+// An error is returned if any of them is malformed.
+func (h Header) AuthenticationResults() ([]AuthenticationResults, error) {
+	parsed := make([]AuthenticationResults, 0, len(h["Authentication-Results"]))
+	for _, val := range h["Authentication-Results"] {
+		invalid := func(what string) error {
+			return &HeaderFieldError{Field: "Authentication-Results", Err: fmt.Errorf("Invalid %s: %q", what, val)}
+		}
+		val = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(val)
+		segments := splitQuoted(stripComments(val), ';')
+		id := strings.Fields(segments[0])
+		if len(id) == 0 {
+			return nil, invalid("authserv-id")
+		}
+		results := AuthenticationResults{AuthServID: id[0]}
+		for _, segment := range segments[1:] {
+			words := splitQuoted(strings.TrimSpace(segment), ' ')
+			if len(words) == 1 && strings.EqualFold(words[0], "none") {
+				continue
+			}
+			var result AuthResult
+			for i, word := range words {
+				if len(word) == 0 {
+					continue // more than one space
+				}
+				equals := strings.IndexByte(word, '=')
+				if equals <= 0 {
+					return nil, invalid("result")
+				}
+				name, value := strings.TrimSpace(word[:equals]), unquotePhrase(strings.TrimSpace(word[equals+1:]))
+				switch {
+				case i == 0:
+					result.Method, result.Result = strings.ToLower(name), strings.ToLower(value)
+				case strings.EqualFold(name, "reason"):
+					result.Reason = value
+				case strings.Contains(name, "."):
+					if result.Properties == nil {
+						result.Properties = make(map[string]string)
+					}
+					result.Properties[strings.ToLower(name)] = value
+				}
+			}
+			if len(result.Method) == 0 {
+				return nil, invalid("result")
+			}
+			results.Results = append(results.Results, result)
+		}
+		parsed = append(parsed, results)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,77 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestAddAuthenticationResult ...
+func TestAddAuthenticationResult(t *testing.T) {
+	t.Parallel()
+
+	results := []AuthResult{
+		{Method: "spf", Result: "pass", Properties: map[string]string{"smtp.mailfrom": "test.from@host.com"}},
+		{Method: "DKIM", Result: "Pass", Reason: "signature verified", Properties: map[string]string{"header.s": "selector", "header.d": "host.com"}},
+		{Method: "dmarc", Result: "fail", Properties: map[string]string{"header.from": "host.com"}},
+	}
+
+	h := Header{"Authentication-Results": []string{"relay.host.com; none"}}
+	if err := h.AddAuthenticationResult("mx.host.com", results); err != nil {
+		t.Fatal("Unable to add Authentication-Results:", err)
+	}
+
+	expected := "mx.host.com; spf=pass smtp.mailfrom=test.from@host.com; " +
+		"dkim=pass reason=\"signature verified\" header.d=host.com header.s=selector; " +
+		"dmarc=fail header.from=host.com"
+	if values := h["Authentication-Results"]; len(values) != 2 || values[0] != expected || values[1] != "relay.host.com; none" {
+		t.Fatalf("Incorrect Authentication-Results: %q", values)
+	}
+
+	raw, err := h.Bytes()
+	if err != nil {
+		t.Fatal("Unable to write header:", err)
+	}
+	reparsed, err := ReadHeader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unable to read header:", err)
+	}
+	parsed, err := reparsed.AuthenticationResults()
+	if err != nil {
+		t.Fatal("Unable to parse Authentication-Results:", err)
+	}
+
+	results[1].Method, results[1].Result = "dkim", "pass"
+	want := []AuthenticationResults{
+		{AuthServID: "mx.host.com", Results: results},
+		{AuthServID: "relay.host.com"},
+	}
+	if !reflect.DeepEqual(parsed, want) {
+		t.Fatalf("Incorrect Authentication-Results parsed: %+v", parsed)
+	}
+
+	commented := Header{"Authentication-Results": []string{"mx.host.com (version 1); spf=pass (sender permitted) smtp.mailfrom=host.com"}}
+	if parsed, err = commented.AuthenticationResults(); err != nil || len(parsed) != 1 ||
+		!reflect.DeepEqual(parsed[0].Results, []AuthResult{{Method: "spf", Result: "pass", Properties: map[string]string{"smtp.mailfrom": "host.com"}}}) {
+		t.Fatalf("Incorrect Authentication-Results parsed with comments: %+v %v", parsed, err)
+	}
+
+	if err = h.AddAuthenticationResult("mx host", nil); err == nil {
+		t.Fatal("Expected an error for an invalid authserv-id")
+	}
+	if err = h.AddAuthenticationResult("mx.host.com", []AuthResult{{Method: "spf", Result: "pass", Properties: map[string]string{"mailfrom": "host.com"}}}); err == nil {
+		t.Fatal("Expected an error for an invalid property")
+	}
+	if len(h["Authentication-Results"]) != 2 {
+		t.Fatal("Invalid results should not be added")
+	}
+
+	malformed := Header{"Authentication-Results": []string{"mx.host.com; spf"}}
+	if _, err = malformed.AuthenticationResults(); err == nil {
+		t.Fatal("Expected an error for a malformed result")
+	}
+}
@@ -0,0 +1,75 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"net/textproto"
+	"reflect"
+	"sort"
+)
+
+// HeaderChange is the kind of difference in a header field between two headers.
+type HeaderChange int
+
+const (
+	// HeaderAdded means the field is only present in the other header.
+	HeaderAdded HeaderChange = iota
+	// HeaderRemoved means the field is only present in the original header.
+	HeaderRemoved
+	// HeaderChanged means the field is present in both, with different values.
+	HeaderChanged
+)
+
+// HeaderDiff describes a header field that differs between two headers.
+type HeaderDiff struct {
+	Field     string
+	Change    HeaderChange
+	OldValues []string
+	NewValues []string
+}
+
+// Diff returns the fields that were added, removed, or changed in the other
+// header compared to this one, sorted by their canonical field name.
+// Fields are compared by canonical key, and the order of a field's values
+// does not matter, but the number of times each value appears does.
+func (h Header) Diff(other Header) []HeaderDiff {
+	oldFields := canonicalFields(h)
+	newFields := canonicalFields(other)
+
+	diffs := make([]HeaderDiff, 0)
+	for field, oldValues := range oldFields {
+		newValues, ok := newFields[field]
+		if !ok {
+			diffs = append(diffs, HeaderDiff{Field: field, Change: HeaderRemoved, OldValues: oldValues})
+		} else if !reflect.DeepEqual(sortedValues(oldValues), sortedValues(newValues)) {
+			diffs = append(diffs, HeaderDiff{Field: field, Change: HeaderChanged, OldValues: oldValues, NewValues: newValues})
+		}
+	}
+	for field, newValues := range newFields {
+		if _, ok := oldFields[field]; !ok {
+			diffs = append(diffs, HeaderDiff{Field: field, Change: HeaderAdded, NewValues: newValues})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Field < diffs[j].Field })
+	return diffs
+}
+
+// canonicalFields returns the header's values keyed by canonical field name,
+// merging any keys that only differ by case.
+func canonicalFields(h Header) map[string][]string {
+	fields := make(map[string][]string, len(h))
+	for _, field := range sortedHeaderFields(h) {
+		key := textproto.CanonicalMIMEHeaderKey(field)
+		fields[key] = append(fields[key], h[field]...)
+	}
+	return fields
+}
+
+// sortedValues returns a sorted copy of the values.
+func sortedValues(values []string) []string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,48 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestHeaderDiff ...
+func TestHeaderDiff(t *testing.T) {
+	t.Parallel()
+
+	original := Header{
+		"From":     []string{"test.from@host.com"},
+		"Subject":  []string{"Test Subject"},
+		"Received": []string{"from a.host.com", "from b.host.com"},
+		"X-Relay":  []string{"removed by the relay"},
+		"to":       []string{"test.to@host.com"},
+	}
+	relayed := Header{
+		"From":        []string{"test.from@host.com"},
+		"Subject":     []string{"[External] Test Subject"},
+		"Received":    []string{"from b.host.com", "from a.host.com"},
+		"To":          []string{"test.to@host.com"},
+		"X-Spam-Flag": []string{"NO"},
+	}
+
+	expected := []HeaderDiff{
+		{Field: "Subject", Change: HeaderChanged, OldValues: []string{"Test Subject"}, NewValues: []string{"[External] Test Subject"}},
+		{Field: "X-Relay", Change: HeaderRemoved, OldValues: []string{"removed by the relay"}},
+		{Field: "X-Spam-Flag", Change: HeaderAdded, NewValues: []string{"NO"}},
+	}
+	if diffs := original.Diff(relayed); !reflect.DeepEqual(diffs, expected) {
+		t.Fatalf("Incorrect diff: %+v", diffs)
+	}
+
+	if diffs := relayed.Diff(relayed); len(diffs) != 0 {
+		t.Fatalf("Expected no differences: %+v", diffs)
+	}
+
+	relayed.Add("Received", "from b.host.com")
+	if diffs := original.Diff(relayed); len(diffs) != 4 || diffs[0].Field != "Received" || diffs[0].Change != HeaderChanged {
+		t.Fatalf("Expected a repeated value to be a change: %+v", diffs)
+	}
+}
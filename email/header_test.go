@@ -0,0 +1,2142 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHeaderFoldingByClass ...
+func TestHeaderFoldingByClass(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		field     string
+		value     string
+		separator string
+	}{
+		{"To", "First Recipient <first@host.com>, second@host.com, Third Recipient <third@host.com>", ","},
+		{"Content-Type", "multipart/alternative; boundary=\"a long boundary value\"; charset=\"UTF-8\"", ";"},
+		{"Subject", "An unstructured subject that does not contain any separators at all", ""},
+	}
+	for _, test := range tests {
+		buffer := &bytes.Buffer{}
+		writer := &headerWriter{w: buffer, maxLineLen: 40, fold: headerFolders[classifyHeader(test.field)]}
+		writer.Write([]byte(test.field + ": " + test.value))
+		if _, err := writer.Flush(); err != nil {
+			t.Fatal("Could not write header:", err)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+		if len(lines) < 2 {
+			t.Fatal("Expected header to be folded:", buffer.String())
+		}
+		for i, line := range lines {
+			if len(line) > 40 {
+				t.Fatal("Folded line is too long:", line)
+			}
+			if i > 0 && line[0] != ' ' {
+				t.Fatal("Continuation line is not indented:", line)
+			}
+			if i < len(lines)-1 && !strings.HasSuffix(line, test.separator) {
+				t.Fatalf("Expected %s to fold after %q: %q", test.field, test.separator, line)
+			}
+		}
+		if strings.Replace(buffer.String(), "\n", "", -1) != test.field+": "+test.value {
+			t.Fatal("Unfolded header does not match original:", buffer.String())
+		}
+	}
+}
+
+// TestWriteToMessageID ...
+func TestWriteToMessageID(t *testing.T) {
+	t.Parallel()
+
+	header := Header{}
+	header.Set("Message-Id", "<1234.5678@host.com>")
+	headerBytes, err := header.Bytes()
+	if err != nil || string(headerBytes) != "Message-Id: <1234.5678@host.com>\n" {
+		t.Fatalf("Message-Id not written as a structured field: %q %v", headerBytes, err)
+	}
+}
+
+// TestSensitivity ...
+func TestSensitivity(t *testing.T) {
+	t.Parallel()
+
+	for _, sensitivity := range []string{"Personal", "Private", "Company-Confidential", "company-confidential"} {
+		header := Header{}
+		if err := header.SetSensitivity(sensitivity); err != nil {
+			t.Fatal("Could not set Sensitivity:", sensitivity, err)
+		}
+		if !strings.EqualFold(header.Sensitivity(), sensitivity) || header.Sensitivity() != header.Get("Sensitivity") {
+			t.Fatal("Incorrect Sensitivity:", sensitivity, header.Sensitivity())
+		}
+	}
+
+	header := Header{}
+	if err := header.SetSensitivity("Secret"); err == nil || header.IsSet("Sensitivity") {
+		t.Fatal("Expected invalid Sensitivity to be rejected")
+	}
+	header.Set("Sensitivity", "Secret")
+	if header.Sensitivity() != "" {
+		t.Fatal("Expected unknown Sensitivity to be ignored:", header.Sensitivity())
+	}
+}
+
+// TestHeaderWriterMinLineLength ...
+func TestHeaderWriterMinLineLength(t *testing.T) {
+	t.Parallel()
+
+	for _, maxLineLen := range []int{-1, 0, 1} {
+		writer := &headerWriter{w: &bytes.Buffer{}, maxLineLen: maxLineLen, fold: foldUnstructured}
+		writer.Write([]byte("Subject: a b c"))
+		if _, err := writer.Flush(); err != errHeaderLineLength {
+			t.Fatal("Expected an error for a line length of", maxLineLen, err)
+		}
+	}
+
+	// The shortest line length possible folds at every space after the first token of the value
+	buffer := &bytes.Buffer{}
+	writer := &headerWriter{w: buffer, maxLineLen: minHeaderLineLength, fold: foldUnstructured}
+	writer.Write([]byte("Subject: a b  c"))
+	if _, err := writer.Flush(); err != nil || buffer.String() != "Subject: a\n b\n  c\n" {
+		t.Fatalf("Incorrect folding at the shortest line length: %q %v", buffer.String(), err)
+	}
+
+	// A fold function that would not make progress must not loop forever
+	buffer = &bytes.Buffer{}
+	writer = &headerWriter{w: buffer, maxLineLen: 4, fold: func(line []byte, limit int) int { return 0 }}
+	writer.Write([]byte("Subject: a b c"))
+	if _, err := writer.Flush(); err != nil || buffer.String() != "Subject: a b c\n" {
+		t.Fatalf("Incorrect output when unable to fold: %q %v", buffer.String(), err)
+	}
+}
+
+// TestValidate ...
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	valid := NewHeader("Test Name <test.from@host.com>", "Test Subject", "test.to@host.com", "Another To <another.to@host.com>")
+	if err := valid.Validate(); err != nil {
+		t.Fatal("Expected a valid header:", err)
+	}
+
+	bccOnly := NewHeader("test.from@host.com", "Test Subject")
+	bccOnly.SetBcc("test.bcc@host.com")
+	if err := bccOnly.Validate(); err != nil {
+		t.Fatal("Expected a Bcc recipient to be enough:", err)
+	}
+
+	tests := []struct {
+		header Header
+		field  string
+	}{
+		{NewHeader("", "Test Subject", "test.to@host.com"), "From"},
+		{NewHeader("test.from@host.com", "Test Subject"), "To"},
+		{NewHeader("test.from@host.com", "Test Subject", "not an address"), "To"},
+		{NewHeader("test.from@host.com", "", "test.to@host.com"), "Subject"},
+	}
+	for _, test := range tests {
+		err := test.header.Validate()
+		fieldErr, ok := err.(*HeaderFieldError)
+		if !ok || fieldErr.Field != test.field || !strings.Contains(err.Error(), test.field) {
+			t.Fatalf("Expected an error for %s: %v", test.field, err)
+		}
+		if (len(test.header.Get(test.field)) == 0 && test.field != "To") != errors.Is(err, ErrHeadersMissingField) {
+			t.Fatal("Expected a missing field to be ErrHeadersMissingField:", err)
+		}
+	}
+
+	if err := NewHeader("test.from@host.com", "", "test.to@host.com").ValidateWith(ValidateOptions{AllowMissingSubject: true}); err != nil {
+		t.Fatal("Expected a missing Subject to be allowed:", err)
+	}
+}
+
+// TestSaveSetsOnlyMissingFields ...
+func TestSaveSetsOnlyMissingFields(t *testing.T) {
+	defer func(original string) { XMailer = original }(XMailer)
+	XMailer = "go-email"
+
+	header := NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	if err := header.Save(); err != nil || header.Get("X-Mailer") != "go-email" || header.Get("MIME-Version") != "1.0" {
+		t.Fatalf("Expected default fields to be added: %q %v", header, err)
+	}
+
+	header = NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	header.Set("X-Mailer", "Caller Mailer 2.0")
+	header.Set("MIME-Version", "1.0 (produced by Caller Mailer 2.0)")
+	header.Set("Message-Id", "<1234.5678@host.com>")
+	header.Set("Date", "Mon, 02 Jan 2006 15:04:05 -0700")
+	expected := Header{}
+	for field, values := range header {
+		expected[field] = append([]string{}, values...)
+	}
+	if err := header.Save(); err != nil || !reflect.DeepEqual(header, expected) {
+		t.Fatalf("Caller provided fields were replaced: %q %v", header, err)
+	}
+}
+
+// TestSetDate ...
+func TestSetDate(t *testing.T) {
+	t.Parallel()
+
+	date := time.Date(2021, time.March, 4, 5, 6, 7, 0, time.FixedZone("", -7*60*60))
+	header := Header{}
+	header.SetDate(date)
+	if header.Get("Date") != "Thu, 04 Mar 2021 05:06:07 -0700" {
+		t.Fatal("Incorrect Date:", header.Get("Date"))
+	}
+	if parsed, err := header.Date(); err != nil || !parsed.Equal(date) {
+		t.Fatal("Date does not parse back:", parsed, err)
+	}
+
+	header = NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	if err := header.Save(); err != nil {
+		t.Fatal("Unable to save header:", err)
+	}
+	if parsed, err := time.Parse(time.RFC1123Z, header.Get("Date")); err != nil || time.Since(parsed) > time.Minute {
+		t.Fatal("Save did not set an RFC 5322 Date:", header.Get("Date"), err)
+	}
+}
+
+// TestReplyToInReplyTo ...
+func TestReplyToInReplyTo(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "Re: Test Subject", "test.to@host.com")
+	if len(header.ReplyTo()) != 0 || len(header.InReplyTo()) != 0 {
+		t.Fatal("Expected no Reply-To nor In-Reply-To")
+	}
+
+	header.SetReplyTo("Support Désk <support@host.com>", "\"Doe, John\" <john@host.com>")
+	header.SetInReplyTo("1234.5678@host.com", " <abcd@host.com> ")
+	if header.Get("In-Reply-To") != "<1234.5678@host.com> <abcd@host.com>" {
+		t.Fatal("Incorrect In-Reply-To:", header.Get("In-Reply-To"))
+	}
+
+	raw, err := header.Bytes()
+	if err != nil || !strings.Contains(string(raw), "\nReply-To: =?UTF-8?q?Support_D=C3=A9sk?= <support@host.com>,\n \"Doe, John\" <john@host.com>\n") {
+		t.Fatalf("Incorrect Reply-To written: %q %v", raw, err)
+	}
+	parsed, err := ReadHeader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unable to read header:", err)
+	}
+	if replyTo := parsed.ReplyTo(); !reflect.DeepEqual(replyTo, []string{"Support Désk <support@host.com>", "\"Doe, John\" <john@host.com>"}) {
+		t.Fatalf("Incorrect Reply-To: %q", replyTo)
+	}
+	if ids := parsed.InReplyTo(); !reflect.DeepEqual(ids, []string{"1234.5678@host.com", "abcd@host.com"}) {
+		t.Fatalf("Incorrect In-Reply-To: %q", ids)
+	}
+
+	header.Set("In-Reply-To", "1234.5678@host.com (sent from a phone)")
+	if ids := header.InReplyTo(); !reflect.DeepEqual(ids, []string{"1234.5678@host.com"}) {
+		t.Fatalf("Incorrect In-Reply-To without angle brackets: %q", ids)
+	}
+
+	header.SetReplyTo()
+	header.SetInReplyTo()
+	if header.IsSet("Reply-To") || header.IsSet("In-Reply-To") {
+		t.Fatal("Expected no Reply-To nor In-Reply-To to remove them")
+	}
+}
+
+// TestThreadIDs ...
+func TestThreadIDs(t *testing.T) {
+	t.Parallel()
+
+	original := NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	original.SetMessageID("first@host.com")
+	if ids := original.ThreadIDs(); len(ids) != 0 {
+		t.Fatalf("Expected no thread ids: %q", ids)
+	}
+
+	// Some clients only set the In-Reply-To
+	reply := NewHeader("test.to@host.com", "Re: Test Subject", "test.from@host.com")
+	reply.SetMessageID("second@host.com")
+	reply.SetInReplyTo(original.MessageID())
+	if ids := reply.ThreadIDs(); !reflect.DeepEqual(ids, []string{"first@host.com"}) {
+		t.Fatalf("Incorrect thread ids: %q", ids)
+	}
+
+	next := NewHeader("test.from@host.com", "Re: Test Subject", "test.to@host.com")
+	for _, id := range append(reply.ThreadIDs(), reply.MessageID()) {
+		next.AddReference(id)
+	}
+	next.AddReference("<second@host.com>")
+	next.SetInReplyTo(reply.MessageID())
+	if next.Get("References") != "<first@host.com> <second@host.com>" || next.Get("In-Reply-To") != "<second@host.com>" {
+		t.Fatalf("Incorrect threading fields: %q %q", next.Get("References"), next.Get("In-Reply-To"))
+	}
+	if ids := next.ThreadIDs(); !reflect.DeepEqual(ids, []string{"first@host.com", "second@host.com"}) {
+		t.Fatalf("Incorrect thread ids: %q", ids)
+	}
+
+	raw, err := next.Bytes()
+	if err != nil {
+		t.Fatal("Unable to write header:", err)
+	}
+	parsed, err := ReadHeader(bytes.NewReader(raw))
+	if err != nil || !reflect.DeepEqual(parsed.References(), []string{"first@host.com", "second@host.com"}) {
+		t.Fatalf("Incorrect References parsed: %q %v", parsed.References(), err)
+	}
+}
+
+// TestMessageID ...
+func TestMessageID(t *testing.T) {
+	t.Parallel()
+
+	for _, id := range []string{"1234.5678@host.com", "<1234.5678@host.com>", " <1234.5678@host.com> "} {
+		header := Header{}
+		header.SetMessageID(id)
+		if header.Get("Message-Id") != "<1234.5678@host.com>" || header.MessageID() != "1234.5678@host.com" {
+			t.Fatalf("Incorrect Message-Id for %q: %q %q", id, header.Get("Message-Id"), header.MessageID())
+		}
+	}
+
+	header := Header{}
+	if header.MessageID() != "" {
+		t.Fatal("Expected an empty Message-Id:", header.MessageID())
+	}
+	header.SetMessageID("1234.5678@host.com")
+	header.SetMessageID("")
+	if header.IsSet("Message-Id") {
+		t.Fatal("Expected an empty id to remove the Message-Id")
+	}
+
+	generated, err := GenMessageID()
+	if err != nil {
+		t.Fatal("Could not generate Message-Id:", err)
+	}
+	header.SetMessageID(generated)
+	if header.MessageID() != generated {
+		t.Fatal("Generated Message-Id does not round trip:", generated, header.MessageID())
+	}
+}
+
+// TestValidateSender ...
+func TestValidateSender(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("First Author <first@host.com>, second@host.com", "Test Subject", "test.to@host.com")
+	if fieldErr, ok := header.Validate().(*HeaderFieldError); !ok || fieldErr.Field != "Sender" || fieldErr.Err != ErrMissingSender {
+		t.Fatal("Expected a multiple From header without a Sender to be invalid:", header.Validate())
+	}
+
+	header.SetSender("Secretary <secretary@host.com>")
+	if err := header.Validate(); err != nil || header.Sender() != "Secretary <secretary@host.com>" {
+		t.Fatal("Expected a multiple From header with a Sender to be valid:", err)
+	}
+	headerBytes, err := header.Bytes()
+	if err != nil || !strings.Contains(string(headerBytes), "\nSender: Secretary <secretary@host.com>\n") {
+		t.Fatalf("Sender not written as an address: %q %v", headerBytes, err)
+	}
+
+	header.SetSender("not an address")
+	if fieldErr, ok := header.Validate().(*HeaderFieldError); !ok || fieldErr.Field != "Sender" {
+		t.Fatal("Expected a malformed Sender to be invalid:", header.Validate())
+	}
+}
+
+// TestHeaderFoldingQuotedStrings ...
+func TestHeaderFoldingQuotedStrings(t *testing.T) {
+	t.Parallel()
+
+	value := "\"Jonathan Alexander Maximilian Doe, the Third\" <jonathan@host.com>, " +
+		"\"The \\\"Quoted\\\" Sender Name\" <sender@host.com>, short@host.com"
+	buffer := &bytes.Buffer{}
+	writer := &headerWriter{w: buffer, maxLineLen: 30, fold: headerFolders[classifyHeader("To")]}
+	writer.Write([]byte("To: " + value))
+	if _, err := writer.Flush(); err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	if len(lines) < 3 {
+		t.Fatal("Expected header to be folded:", buffer.String())
+	}
+	for _, line := range lines {
+		if strings.Count(strings.Replace(line, "\\\"", "", -1), "\"")%2 != 0 {
+			t.Fatalf("Folded inside a quoted-string: %q", buffer.String())
+		}
+	}
+	if strings.Replace(buffer.String(), "\n", "", -1) != "To: "+value {
+		t.Fatal("Unfolded header does not match original:", buffer.String())
+	}
+
+	// Quotes have no special meaning in unstructured fields
+	buffer = &bytes.Buffer{}
+	writer = &headerWriter{w: buffer, maxLineLen: 30, fold: headerFolders[classifyHeader("Subject")]}
+	writer.Write([]byte("Subject: He said \"this is a quote that is longer than a line\""))
+	writer.Flush()
+	if strings.Count(buffer.String(), "\n") < 2 {
+		t.Fatal("Expected unstructured header to fold at any whitespace:", buffer.String())
+	}
+}
+
+// TestWriteToWithBcc ...
+func TestWriteToWithBcc(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	header.SetBcc("Hidden Name <test.bcc@host.com>", "another.bcc@host.com")
+
+	transmitted, err := header.Bytes()
+	if err != nil || strings.Contains(string(transmitted), "Bcc") {
+		t.Fatalf("Bcc must not be written by default: %q %v", transmitted, err)
+	}
+
+	stored := &bytes.Buffer{}
+	if _, err = header.WriteToWith(stored, WriteOptions{IncludeBcc: true}); err != nil ||
+		!strings.Contains("\n"+stored.String(), "\nBcc: Hidden Name <test.bcc@host.com>, another.bcc@host.com\n") {
+		t.Fatalf("Bcc must be written when included: %q %v", stored.String(), err)
+	}
+
+	msg := NewMessage(header, "text", "<p>html</p>")
+	stored.Reset()
+	if _, err = msg.WriteToWith(stored, WriteOptions{IncludeBcc: true}); err != nil {
+		t.Fatal("Could not write message:", err)
+	}
+	parsed, err := ParseMessage(stored)
+	if err != nil || parsed.Header.Get("Bcc") != header.Get("Bcc") {
+		t.Fatalf("Stored message does not keep the Bcc: %q %v", parsed.Header, err)
+	}
+}
+
+// assertNoBcc fails the test if the raw output of the write method reveals a Bcc recipient.
+func assertNoBcc(t *testing.T, method string, raw []byte) {
+	t.Helper()
+	if bccFieldPattern.Match(raw) || bytes.Contains(raw, []byte("hidden@host.com")) {
+		t.Fatalf("%s must not write the Bcc: %q", method, raw)
+	}
+}
+
+// TestWriteOmitsBcc ...
+func TestWriteOmitsBcc(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	header.SetBcc("Hidden Name <hidden@host.com>")
+	header["bcc"] = []string{"hidden@host.com"} // set directly, without a canonical key
+	header.Set("Resent-Bcc", "hidden@host.com")
+	msg := NewMessage(header, "text", "<p>html</p>", NewPartAttachmentFromBytes([]byte("attachment"), "file.txt"))
+
+	raw, err := header.Bytes()
+	if err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	assertNoBcc(t, "Header.Bytes", raw)
+
+	writes := map[string]func(w io.Writer) error{
+		"Header.WriteTo": func(w io.Writer) error {
+			_, err := header.WriteTo(w)
+			return err
+		},
+		"Header.WriteToWith": func(w io.Writer) error {
+			_, err := header.WriteToWith(w, WriteOptions{UseCRLF: true, AssertNoBcc: true})
+			return err
+		},
+		"Message.WriteTo": func(w io.Writer) error {
+			_, err := msg.WriteTo(w)
+			return err
+		},
+		"Message.WriteToWith": func(w io.Writer) error {
+			_, err := msg.WriteToWith(w, WriteOptions{AssertNoBcc: true})
+			return err
+		},
+		"Message.WriteToContext": func(w io.Writer) error {
+			_, err := msg.WriteToContext(context.Background(), w)
+			return err
+		},
+		"Message.WriteCanonical": func(w io.Writer) error {
+			return msg.WriteCanonical(w, "<id@host.com>", "boundary", time.Unix(0, 0))
+		},
+	}
+	for method, write := range writes {
+		buffer := &bytes.Buffer{}
+		if err = write(buffer); err != nil {
+			t.Fatalf("%s could not write: %v", method, err)
+		}
+		assertNoBcc(t, method, buffer.Bytes())
+	}
+
+	plans, err := header.DryRun(WriteOptions{})
+	if err != nil {
+		t.Fatal("Could not dry run:", err)
+	}
+	for _, plan := range plans {
+		if !header.IsSet(plan.Field) || (WriteOptions{}).omits(plan.Field) {
+			t.Fatal("DryRun must not plan the Bcc:", plan)
+		}
+	}
+
+	// Every Bcc field is still written when included
+	stored := &bytes.Buffer{}
+	if _, err = header.WriteToWith(stored, WriteOptions{IncludeBcc: true, AssertNoBcc: true}); err != nil ||
+		len(bccFieldPattern.FindAll(stored.Bytes(), -1)) != 3 {
+		t.Fatalf("Expected every Bcc to be written when included: %q %v", stored.String(), err)
+	}
+}
+
+// TestWriteAssertNoBcc ...
+func TestWriteAssertNoBcc(t *testing.T) {
+	t.Parallel()
+
+	// A Bcc injected into another field's value, which is written raw for SMTPUTF8
+	header := NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	header.Set("X-Note", "note\r\nBcc: hidden@host.com")
+
+	buffer := &bytes.Buffer{}
+	if _, err := header.WriteToWith(buffer, WriteOptions{SMTPUTF8: true}); err != nil || !bccFieldPattern.Match(buffer.Bytes()) {
+		t.Fatalf("Expected the injected Bcc to be written without the assertion: %q %v", buffer.String(), err)
+	}
+	buffer.Reset()
+	if n, err := header.WriteToWith(buffer, WriteOptions{SMTPUTF8: true, AssertNoBcc: true}); err != ErrBccWritten || n != 0 || buffer.Len() != 0 {
+		t.Fatalf("Expected ErrBccWritten without writing anything: %q %d %v", buffer.String(), n, err)
+	}
+}
+
+// TestEncodedWordExpansion ...
+func TestEncodedWordExpansion(t *testing.T) {
+	t.Parallel()
+
+	emoji := strings.Repeat("😀🎉🚀", 300)
+
+	// Q encoding would triple the size, so B encoding is used instead
+	buffer := &bytes.Buffer{}
+	if _, err := (Header{"Subject": {emoji}}).WriteToWith(buffer, WriteOptions{WordEncoding: WordEncodingQ}); err != nil ||
+		!strings.HasPrefix(buffer.String(), "Subject: =?UTF-8?b?") || buffer.Len() > maxEncodedExpansion*len(emoji) {
+		t.Fatal("Expected the more compact encoding to be used:", len(emoji), buffer.Len(), err)
+	}
+	// Mostly ASCII values are more compact Q encoded
+	buffer.Reset()
+	if _, err := (Header{"Subject": {"Meeting at the Café tomorrow"}}).WriteToWith(buffer, WriteOptions{WordEncoding: WordEncodingQ}); err != nil ||
+		buffer.String() != "Subject: =?UTF-8?q?Meeting_at_the_Caf=C3=A9_tomorrow?=\n" {
+		t.Fatal("Expected the preferred encoding to be used:", buffer.String(), err)
+	}
+
+	// A pathologically large subject must still be written within the line limits, and decode
+	header := NewHeader("test.from@host.com", emoji, "test.to@host.com")
+	headerBytes, err := header.Bytes()
+	if err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	if len(headerBytes) > maxEncodedExpansion*len(emoji)+MaxHeaderTotalLength {
+		t.Fatal("Encoded header is bloated:", len(headerBytes))
+	}
+	for _, line := range strings.Split(string(headerBytes), "\n") {
+		if len(line) > MaxHeaderTotalLength {
+			t.Fatal("Header line too long:", len(line))
+		}
+	}
+	parsed, err := ReadHeader(bytes.NewReader(headerBytes))
+	if err != nil || parsed.Subject() != emoji {
+		t.Fatal("Large subject does not decode:", err)
+	}
+}
+
+// TestContentTransferEncoding ...
+func TestContentTransferEncoding(t *testing.T) {
+	t.Parallel()
+
+	if encoding := (Header{}).ContentTransferEncoding(); encoding != "7bit" {
+		t.Fatal("Expected the default Content-Transfer-Encoding:", encoding)
+	}
+
+	for _, encoding := range []string{"7bit", "8bit", "binary", "base64", "quoted-printable"} {
+		header := Header{}
+		if err := header.SetContentTransferEncoding(strings.ToUpper(encoding)); err != nil {
+			t.Fatal("Could not set Content-Transfer-Encoding:", encoding, err)
+		}
+		if header.ContentTransferEncoding() != encoding || header.Get("Content-Transfer-Encoding") != encoding {
+			t.Fatal("Incorrect Content-Transfer-Encoding:", encoding, header.ContentTransferEncoding())
+		}
+	}
+
+	header := Header{}
+	if err := header.SetContentTransferEncoding("uuencode"); err == nil || header.IsSet("Content-Transfer-Encoding") {
+		t.Fatal("Expected unknown Content-Transfer-Encoding to be rejected")
+	}
+	header.Set("Content-Transfer-Encoding", "uuencode")
+	if header.ContentTransferEncoding() != "" {
+		t.Fatal("Expected unknown Content-Transfer-Encoding to be empty:", header.ContentTransferEncoding())
+	}
+}
+
+// TestSetContentType ...
+func TestSetContentType(t *testing.T) {
+	t.Parallel()
+
+	boundary := "----=_Part_1_0123456789.1700000000000"
+	header := Header{}
+	if err := header.SetContentType("multipart/mixed", map[string]string{"boundary": boundary}); err != nil {
+		t.Fatal("Could not set Content-Type:", err)
+	}
+	if header.Get("Content-Type") != "multipart/mixed; boundary=\""+boundary+"\"" {
+		t.Fatal("Boundary was not quoted:", header.Get("Content-Type"))
+	}
+	mediaType, params, err := header.ContentType()
+	if err != nil || mediaType != "multipart/mixed" || params["boundary"] != boundary {
+		t.Fatal("Content-Type did not round-trip:", mediaType, params, err)
+	}
+
+	// Tokens are left unquoted
+	if err = header.SetContentType("text/plain", map[string]string{"charset": "UTF-8"}); err != nil ||
+		header.Get("Content-Type") != "text/plain; charset=UTF-8" {
+		t.Fatal("Incorrect Content-Type:", header.Get("Content-Type"), err)
+	}
+
+	if err = header.SetContentType("not a media type", nil); err == nil || header.Get("Content-Type") != "text/plain; charset=UTF-8" {
+		t.Fatal("Expected an invalid media type to be rejected:", header.Get("Content-Type"))
+	}
+}
+
+// TestWriteToWithFieldLess ...
+func TestWriteToWithFieldLess(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	header.Set("X-Custom", "value")
+	header.Set("Date", "Mon, 02 Jan 2006 15:04:05 -0700")
+
+	buffer := &bytes.Buffer{}
+	reverse := func(a, b string) bool { return a > b }
+	if _, err := header.WriteToWith(buffer, WriteOptions{FieldLess: reverse}); err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	expected := "X-Custom: value\nTo: test.to@host.com\nSubject: Test Subject\nFrom: test.from@host.com\n" +
+		"Date: Mon, 02 Jan 2006 15:04:05 -0700\n"
+	if buffer.String() != expected {
+		t.Fatalf("Header not written in reverse order: %q", buffer.String())
+	}
+}
+
+// TestMIMEVersion ...
+func TestMIMEVersion(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"1.0":                       "1.0",
+		" 1.0 (produced by Mailer)": "1.0",
+		"1.(nested (comment\\)))0":  "1.0",
+		"2.0":                       "2.0",
+	}
+	for raw, expected := range tests {
+		header := Header{"Mime-Version": []string{raw}}
+		if version, ok := header.MIMEVersion(); !ok || version != expected {
+			t.Fatalf("Expected MIME-Version %q from %q, got %q", expected, raw, version)
+		}
+	}
+
+	if version, ok := (Header{}).MIMEVersion(); ok || version != "" {
+		t.Fatal("Expected MIME-Version to be absent:", version)
+	}
+}
+
+// TestSetContentDispositionFilename ...
+func TestSetContentDispositionFilename(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"report 2024.pdf":    "attachment; filename=\"report 2024.pdf\"",
+		"résumé 简历.pdf":      "attachment; filename*=UTF-8''r%C3%A9sum%C3%A9%20%E7%AE%80%E5%8E%86.pdf",
+		"café's 50% off.txt": "attachment; filename*=UTF-8''caf%C3%A9%27s%2050%25%20off.txt",
+	}
+	for filename, expected := range tests {
+		header := Header{}
+		if err := header.SetContentDisposition("attachment", map[string]string{"filename": filename}); err != nil ||
+			header.Get("Content-Disposition") != expected {
+			t.Fatalf("Expected %q, got %q %v", expected, header.Get("Content-Disposition"), err)
+		}
+		for _, part := range []*Message{{Header: header, Body: []byte("raw")}, NewPartAttachmentFromBytes([]byte("raw"), filename)} {
+			raw, err := part.Bytes()
+			if err != nil || !strings.Contains(string(raw), "Content-Disposition: "+expected+"\n") {
+				t.Fatalf("Disposition not written as %q: %q %v", expected, raw, err)
+			}
+			parsed, err := ParseMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal("Could not parse part:", err)
+			}
+			if disposition, params, err := parsed.Header.ContentDisposition(); err != nil ||
+				disposition != "attachment" || params["filename"] != filename {
+				t.Fatal("Filename did not round-trip:", disposition, params, err)
+			}
+		}
+	}
+
+	header := Header{}
+	if err := header.SetContentType("text/plain", map[string]string{"name": "naïve.txt", "charset": "utf-8"}); err != nil ||
+		header.Get("Content-Type") != "text/plain; charset=utf-8; name*=UTF-8''na%C3%AFve.txt" {
+		t.Fatal("Incorrect Content-Type:", header.Get("Content-Type"), err)
+	}
+	if err := header.SetContentType("text/plain", map[string]string{"bad name": "naïve.txt"}); err == nil {
+		t.Fatal("Expected an invalid parameter to be rejected")
+	}
+}
+
+// TestContentDispositionRFC2231 ...
+func TestContentDispositionRFC2231(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		// Continuations, mixing extended and plain sections
+		"attachment;\n filename*0*=UTF-8''r%C3%A9sum%C3%A9%20;\n filename*1=\"of a very long \";\n filename*2*=name.pdf": "résumé of a very long name.pdf",
+		// A charset that mime.ParseMediaType does not decode
+		"attachment; filename*=iso-8859-1'fr'na%EFve%20caf%E9.txt": "naïve café.txt",
+		// Continuations with a charset, out of order
+		"attachment; filename*1*=%E9.txt; filename*0*=ISO-8859-1''caf": "café.txt",
+		// A plain filename is unchanged
+		`attachment; filename="plain name.txt"`: "plain name.txt",
+	}
+	for disposition, filename := range tests {
+		msg, err := ParseMessage(strings.NewReader("Content-Disposition: " + disposition + "\n\nbody"))
+		if err != nil {
+			t.Fatal("Could not parse message:", err)
+		}
+		if _, params, err := msg.Header.ContentDisposition(); err != nil || params["filename"] != filename {
+			t.Fatalf("Expected filename %q from %q, got %q %v", filename, disposition, params, err)
+		}
+	}
+
+	header := Header{"Content-Type": []string{"text/plain; name*0*=ISO-8859-1''caf; name*1*=%E9.txt; charset=us-ascii"}}
+	if _, params, err := header.ContentType(); err != nil || params["name"] != "café.txt" || params["charset"] != "us-ascii" {
+		t.Fatal("Incorrect Content-Type params:", params, err)
+	}
+
+	// Unknown charsets are left out
+	header = Header{"Content-Disposition": []string{"attachment; filename*=x-unknown''abc"}}
+	if _, params, err := header.ContentDisposition(); err != nil || len(params["filename"]) > 0 {
+		t.Fatal("Expected no filename:", params, err)
+	}
+}
+
+// TestContentDispositionContinuations ...
+func TestContentDispositionContinuations(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"Квартальный отчёт отдела продаж за третий квартал 2024 года.pdf":                      "filename*0*=UTF-8''",
+		"Quarterly report of the sales department for the third quarter of 2024 \"final\".pdf": "filename*0=\"",
+		"申請書類一式_提出用_最終版_2024年度_東京本社_営業部_山田太郎.txt":                                              "filename*0*=UTF-8''",
+	}
+	for filename, prefix := range tests {
+		part := NewPartAttachmentFromBytes([]byte("raw"), filename)
+		disposition := part.Header.Get("Content-Disposition")
+		if !strings.HasPrefix(disposition, "attachment; "+prefix) || !strings.Contains(disposition, "; filename*1") {
+			t.Fatalf("Expected continuations: %q", disposition)
+		}
+		if _, params, err := mime.ParseMediaType(disposition); err != nil || params["filename"] != filename {
+			t.Fatalf("Continuations not decoded by mime.ParseMediaType: %q %v", params, err)
+		}
+
+		raw, err := part.Bytes()
+		if err != nil {
+			t.Fatal("Could not write part:", err)
+		}
+		for _, line := range strings.Split(string(raw), "\n") {
+			if len(line) > MaxHeaderLineLength {
+				t.Fatalf("Line is longer than %d: %q", MaxHeaderLineLength, line)
+			}
+		}
+		parsed, err := ParseMessage(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal("Could not parse part:", err)
+		}
+		if _, params, err := parsed.Header.ContentDisposition(); err != nil || params["filename"] != filename {
+			t.Fatalf("Filename did not round-trip: %q %v", params, err)
+		}
+	}
+
+	// Each section is split between characters
+	header := Header{}
+	if err := header.SetContentType("application/pdf", map[string]string{"name": strings.Repeat("é", 40)}); err != nil {
+		t.Fatal("Could not set Content-Type:", err)
+	}
+	sections := strings.Split(header.Get("Content-Type"), "; ")[1:]
+	if len(sections) != 4 {
+		t.Fatalf("Expected four sections: %q", sections)
+	}
+	for _, section := range sections {
+		if len(section) > maxParamLength || strings.Count(section, "%C3") != strings.Count(section, "%A9") {
+			t.Fatalf("Incorrect section: %q", section)
+		}
+	}
+}
+
+// TestListUnsubscribe ...
+func TestListUnsubscribe(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("news@host.com", "Newsletter", "test.to@host.com")
+	if urls := header.ListUnsubscribe(); len(urls) != 0 || header.ListUnsubscribePost() {
+		t.Fatal("Expected no List-Unsubscribe:", urls)
+	}
+
+	urls := []string{"mailto:unsubscribe@host.com?subject=unsubscribe", "https://host.com/unsubscribe?id=123"}
+	header.SetListUnsubscribe(urls...)
+	header.SetListUnsubscribePost()
+	raw, err := header.Bytes()
+	if err != nil || !strings.Contains(string(raw), "\nList-Unsubscribe: <"+urls[0]+">,\n <"+urls[1]+">\n") ||
+		!strings.Contains(string(raw), "\nList-Unsubscribe-Post: List-Unsubscribe=One-Click\n") {
+		t.Fatalf("Incorrect List-Unsubscribe fields: %q %v", raw, err)
+	}
+
+	parsed, err := ReadHeader(bytes.NewReader(raw))
+	if err != nil || !reflect.DeepEqual(parsed.ListUnsubscribe(), urls) || !parsed.ListUnsubscribePost() {
+		t.Fatal("List-Unsubscribe did not round-trip:", parsed.ListUnsubscribe(), err)
+	}
+}
+
+// TestSetListHeaders ...
+func TestSetListHeaders(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("team@lists.host.com", "Team Update", "team@lists.host.com")
+	if err := header.SetListHeaders("Team List <team.lists.host.com>", "mailto:team@lists.host.com",
+		"mailto:team-request@lists.host.com?subject=help", "https://lists.host.com/archive/team/"); err != nil {
+		t.Fatal("Could not set list headers:", err)
+	}
+	header.SetListUnsubscribe("mailto:team-request@lists.host.com?subject=unsubscribe")
+	raw, err := header.Bytes()
+	if err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	for _, field := range []string{
+		"List-Id: Team List <team.lists.host.com>",
+		"List-Post: <mailto:team@lists.host.com>",
+		"List-Help: <mailto:team-request@lists.host.com?subject=help>",
+		"List-Archive: <https://lists.host.com/archive/team/>",
+		"List-Unsubscribe: <mailto:team-request@lists.host.com?subject=unsubscribe>",
+	} {
+		if !strings.Contains(string(raw), "\n"+field+"\n") {
+			t.Fatalf("Expected %q in: %q", field, raw)
+		}
+	}
+
+	parsed, err := ReadHeader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse header:", err)
+	}
+	if parsed.ListID() != "team.lists.host.com" || parsed.ListPost() != "mailto:team@lists.host.com" ||
+		parsed.ListHelp() != "mailto:team-request@lists.host.com?subject=help" ||
+		parsed.ListArchive() != "https://lists.host.com/archive/team/" {
+		t.Fatal("List headers did not round-trip:", parsed.ListID(), parsed.ListPost(), parsed.ListHelp(), parsed.ListArchive())
+	}
+
+	// An announcement list, without posting, help, or archive
+	if err = header.SetListHeaders("news.host.com", "NO", "", ""); err != nil {
+		t.Fatal("Could not set list headers:", err)
+	}
+	if header.Get("List-Id") != "<news.host.com>" || header.Get("List-Post") != "NO" || header.ListPost() != "" ||
+		header.HasAny("List-Help", "List-Archive") {
+		t.Fatal("Incorrect announcement list headers:", header)
+	}
+
+	invalid := [][4]string{
+		{"", "", "", ""},
+		{"nodot", "", "", ""},
+		{"Liste Café <team.host.com>", "", "", ""},
+		{"team.host.com", "team@host.com", "", ""},
+		{"team.host.com", "", "https://", ""},
+		{"team.host.com", "", "", "https://host.com/a b"},
+	}
+	for _, list := range invalid {
+		if err = header.SetListHeaders(list[0], list[1], list[2], list[3]); err == nil {
+			t.Fatal("Expected an error for invalid list headers:", list)
+		}
+	}
+	if header.ListID() != "news.host.com" {
+		t.Fatal("Expected invalid list headers to not be set:", header)
+	}
+}
+
+// TestPriority ...
+func TestPriority(t *testing.T) {
+	t.Parallel()
+
+	tests := map[Priority][]string{
+		PriorityHigh:   {"1 (Highest)", "high", "High"},
+		PriorityNormal: {"3 (Normal)", "normal", "Normal"},
+		PriorityLow:    {"5 (Lowest)", "low", "Low"},
+	}
+	for priority, expected := range tests {
+		header := Header{}
+		header.SetPriority(priority)
+		if header.Get("X-Priority") != expected[0] || header.Get("Importance") != expected[1] ||
+			header.Get("X-MSMail-Priority") != expected[2] || header.Priority() != priority {
+			t.Fatal("Incorrect priority header fields:", priority, header)
+		}
+	}
+
+	if priority := (Header{}).Priority(); priority != PriorityNormal {
+		t.Fatal("Expected the default priority:", priority)
+	}
+	header := Header{"X-Priority": []string{"2"}, "Importance": []string{"low"}}
+	if priority := header.Priority(); priority != PriorityHigh {
+		t.Fatal("Expected X-Priority to take precedence:", priority)
+	}
+	header = Header{"X-Priority": []string{"unknown"}, "Importance": []string{"Low"}, "X-Msmail-Priority": []string{"High"}}
+	if priority := header.Priority(); priority != PriorityLow {
+		t.Fatal("Expected Importance to take precedence:", priority)
+	}
+}
+
+// TestAutoSubmitted ...
+func TestAutoSubmitted(t *testing.T) {
+	t.Parallel()
+
+	if value := (Header{}).AutoSubmitted(); value != "no" {
+		t.Fatal("Expected the default Auto-Submitted:", value)
+	}
+
+	for _, value := range []string{"no", "auto-generated", "auto-replied", "Auto-Replied"} {
+		header := Header{}
+		if err := header.SetAutoSubmitted(value); err != nil {
+			t.Fatal("Could not set Auto-Submitted:", value, err)
+		}
+		if header.AutoSubmitted() != strings.ToLower(value) || header.Get("Auto-Submitted") != strings.ToLower(value) {
+			t.Fatal("Incorrect Auto-Submitted:", value, header.AutoSubmitted())
+		}
+	}
+
+	header := Header{}
+	if err := header.SetAutoSubmitted("auto-notified"); err == nil || header.IsSet("Auto-Submitted") {
+		t.Fatal("Expected invalid Auto-Submitted to be rejected")
+	}
+	header.Set("Auto-Submitted", "auto-replied (vacation); owner-email=\"me@host.com\"")
+	if header.AutoSubmitted() != "auto-replied" {
+		t.Fatal("Expected parameters and comments to be ignored:", header.AutoSubmitted())
+	}
+	header.Set("Auto-Submitted", "auto-notified")
+	if header.AutoSubmitted() != "" {
+		t.Fatal("Expected unknown Auto-Submitted to be empty:", header.AutoSubmitted())
+	}
+}
+
+// TestHeaderClone ...
+func TestHeaderClone(t *testing.T) {
+	t.Parallel()
+
+	base := NewHeader("Test Name <test.from@host.com>", "Test Subject")
+	base["List-Id"] = make([]string, 1, 4) // spare capacity, so a shallow copy would share appends
+	base["List-Id"][0] = "<newsletter.host.com>"
+	expected := map[string][]string{
+		"From":    {"Test Name <test.from@host.com>"},
+		"Subject": {"Test Subject"},
+		"List-Id": {"<newsletter.host.com>"},
+	}
+
+	clone := base.Clone()
+	clone.Add("List-Id", "<other.host.com>")
+	clone.Add("To", "test.to@host.com")
+	clone.Set("Subject", "Different Subject")
+	clone["From"][0] = "other.from@host.com"
+	if !reflect.DeepEqual(map[string][]string(base), expected) {
+		t.Fatal("Changing the clone changed the original:", base)
+	}
+	if !reflect.DeepEqual(clone["List-Id"], []string{"<newsletter.host.com>", "<other.host.com>"}) || clone.Get("To") != "test.to@host.com" {
+		t.Fatal("Incorrect clone:", clone)
+	}
+
+	other := base.Clone()
+	if !reflect.DeepEqual(other["List-Id"], []string{"<newsletter.host.com>"}) {
+		t.Fatal("Clones share values:", other)
+	}
+	if Header(nil).Clone() != nil {
+		t.Fatal("Expected a nil clone of a nil header")
+	}
+}
+
+// TestGetNumeric ...
+func TestGetNumeric(t *testing.T) {
+	t.Parallel()
+
+	header := Header{}
+	header.Set("X-Priority", "3")
+	header.Set("X-Spam-Score", " \t-2.5 ")
+	header.Set("X-Count", "  42")
+	header.Set("X-Spam-Status", "No, score=-2.5")
+
+	if i, err := header.GetInt("x-priority"); err != nil || i != 3 {
+		t.Fatal("Incorrect integer:", i, err)
+	}
+	if i, err := header.GetInt("X-Count"); err != nil || i != 42 {
+		t.Fatal("Incorrect padded integer:", i, err)
+	}
+	if f, err := header.GetFloat("X-Spam-Score"); err != nil || f != -2.5 {
+		t.Fatal("Incorrect padded float:", f, err)
+	}
+	if f, err := header.GetFloat("X-Priority"); err != nil || f != 3 {
+		t.Fatal("Incorrect float from an integer:", f, err)
+	}
+
+	var fieldErr *HeaderFieldError
+	if _, err := header.GetInt("X-Spam-Score"); err == nil || !errors.As(err, &fieldErr) || fieldErr.Field != "X-Spam-Score" {
+		t.Fatal("Expected a float to not be an integer:", err)
+	}
+	if _, err := header.GetFloat("X-Spam-Status"); err == nil || !strings.Contains(err.Error(), "X-Spam-Status") {
+		t.Fatal("Expected a non-numeric value to be rejected:", err)
+	}
+	if _, err := header.GetInt("X-Missing"); !errors.Is(err, ErrHeadersMissingField) {
+		t.Fatal("Expected a missing field:", err)
+	}
+}
+
+// TestHeaderLineTooLong ...
+func TestHeaderLineTooLong(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	header.Set("X-Token", strings.Repeat("a", 1500))
+	buffer := &bytes.Buffer{}
+	_, err := header.WriteTo(buffer)
+	var fieldErr *HeaderFieldError
+	if !errors.Is(err, ErrHeaderLineTooLong) || !errors.As(err, &fieldErr) || fieldErr.Field != "X-Token" {
+		t.Fatal("Expected an error for an unfoldable header line:", err)
+	}
+	if strings.Contains(buffer.String(), "X-Token") {
+		t.Fatal("Expected the invalid line to not be written:", buffer.String())
+	}
+
+	// Long values that can be folded are still written
+	header.Set("X-Token", strings.Repeat("a", 900)+" "+strings.Repeat("b", 900))
+	buffer.Reset()
+	if _, err = header.WriteTo(buffer); err != nil ||
+		!strings.Contains(buffer.String(), "X-Token: "+strings.Repeat("a", 900)+"\n "+strings.Repeat("b", 900)+"\n") {
+		t.Fatal("Expected a long header line to be folded:", err)
+	}
+}
+
+// TestWriteToWithCharset ...
+func TestWriteToWithCharset(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("Frédéric <test.from@host.com>", "Café crème", "test.to@host.com")
+	buffer := &bytes.Buffer{}
+	if _, err := header.WriteToWith(buffer, WriteOptions{Charset: "iso-8859-1"}); err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	if !strings.Contains(buffer.String(), "\nSubject: =?ISO-8859-1?q?Caf=E9_cr=E8me?=\n") ||
+		!strings.Contains(buffer.String(), "From: =?ISO-8859-1?b?RnLpZOlyaWM=?= <test.from@host.com>\n") {
+		t.Fatalf("Header not encoded as ISO-8859-1: %q", buffer.String())
+	}
+	parsed, err := ReadHeader(buffer)
+	if err != nil || parsed.Subject() != "Café crème" || parsed.From() != "Frédéric <test.from@host.com>" {
+		t.Fatal("ISO-8859-1 header did not round-trip:", parsed, err)
+	}
+
+	header.SetSubject("Café 非常感谢你")
+	if _, err = header.WriteToWith(&bytes.Buffer{}, WriteOptions{Charset: "ISO-8859-1"}); err == nil {
+		t.Fatal("Expected an error for a value that ISO-8859-1 can not represent")
+	}
+	if _, err = header.WriteToWith(&bytes.Buffer{}, WriteOptions{Charset: "KOI8-R"}); err == nil {
+		t.Fatal("Expected an error for an unknown charset")
+	}
+	buffer.Reset()
+	if _, err = header.WriteToWith(buffer, WriteOptions{}); err != nil || !strings.Contains(buffer.String(), "Subject: =?UTF-8?b?") {
+		t.Fatalf("Expected UTF-8 by default: %q %v", buffer.String(), err)
+	}
+}
+
+// TestWriteToWithWordEncoding ...
+func TestWriteToWithWordEncoding(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("Frédéric Dupont of Marketing <test.from@host.com>", "Café and crème at the hôtel with Chloé", "Иван <test.to@host.com>")
+	write := func(opts WriteOptions) string {
+		buffer := &bytes.Buffer{}
+		if _, err := header.WriteToWith(buffer, opts); err != nil {
+			t.Fatal("Could not write header:", err)
+		}
+		return buffer.String()
+	}
+	const (
+		fromB    = "From: =?UTF-8?b?RnLDqWTDqXJpYyBEdXBvbnQgb2YgTWFya2V0aW5n?= <test.from@host.com>\n"
+		fromQ    = "From: =?UTF-8?q?Fr=C3=A9d=C3=A9ric_Dupont_of_Marketing?= <test.from@host.com>\n"
+		subjectB = "Subject: =?UTF-8?b?Q2Fmw6kgYW5kIGNyw6htZSBhdCB0aGUgaMO0dGVsIHdpdGggQ2hsb8Op?=\n"
+		subjectQ = "Subject: =?UTF-8?q?Caf=C3=A9_and_cr=C3=A8me_at_the_h=C3=B4tel_with_Chlo?=\n =?UTF-8?q?=C3=A9?=\n"
+		// The Cyrillic name is always B encoded, as Q encoding would more than double its length
+		toB = "To: =?UTF-8?b?0JjQstCw0L0=?= <test.to@host.com>\n"
+	)
+
+	tests := []struct {
+		opts     WriteOptions
+		expected string
+	}{
+		{WriteOptions{}, fromB + subjectB + toB},
+		{WriteOptions{WordEncoding: WordEncodingB}, fromB + subjectB + toB},
+		{WriteOptions{WordEncoding: WordEncodingQ}, fromQ + subjectQ + toB},
+		// The name is shorter Q encoded, but the subject is shorter B encoded
+		{WriteOptions{WordEncoding: WordEncodingShortest}, fromQ + subjectB + toB},
+		{WriteOptions{WordEncodings: map[string]WordEncoding{"Subject": WordEncodingQ}}, fromB + subjectQ + toB},
+		{WriteOptions{WordEncoding: WordEncodingQ, WordEncodings: map[string]WordEncoding{"From": WordEncodingB}}, fromB + subjectQ + toB},
+	}
+	for _, test := range tests {
+		if written := write(test.opts); written != test.expected {
+			t.Fatalf("Expected:\n%s\ngot:\n%s", test.expected, written)
+		}
+	}
+}
+
+// TestWriteToWithMaxFields ...
+func TestWriteToWithMaxFields(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	for i := 0; i < 10; i++ {
+		header.Add("Received", "from relay.host.com")
+	}
+	buffer := &bytes.Buffer{}
+	if _, err := header.WriteToWith(buffer, WriteOptions{MaxFields: 12}); err != ErrTooManyHeaderFields || buffer.Len() != 0 {
+		t.Fatal("Expected an error for too many fields:", err)
+	}
+	if _, err := header.WriteToWith(buffer, WriteOptions{MaxFields: 13}); err != nil {
+		t.Fatal("Could not write header at the limit:", err)
+	}
+
+	// The default is generous, but not unlimited
+	if _, err := header.WriteTo(&bytes.Buffer{}); err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	for i := 0; i < DefaultMaxHeaderFields; i++ {
+		header.Add("X-Runaway", "value")
+	}
+	msg := NewMessage(header, "text", "<p>html</p>")
+	if _, err := msg.WriteTo(&bytes.Buffer{}); err != ErrTooManyHeaderFields {
+		t.Fatal("Expected an error for too many fields by default:", err)
+	}
+}
+
+// TestSplitHeaderList ...
+func TestSplitHeaderList(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string][]string{
+		"":                       {},
+		"a@host.com":             {"a@host.com"},
+		"a@host.com,b@host.com ": {"a@host.com", "b@host.com"},
+		"\"Doe, John\" <john@host.com>, jane@host.com":             {"\"Doe, John\" <john@host.com>", "jane@host.com"},
+		"john@host.com (Doe, John), , jane@host.com (Jane (a, b))": {"john@host.com (Doe, John)", "jane@host.com (Jane (a, b))"},
+		"\"Escaped \\\", quote\" <e@host.com>, f@host.com":         {"\"Escaped \\\", quote\" <e@host.com>", "f@host.com"},
+	}
+	for raw, expected := range tests {
+		if entries := splitHeaderList(raw); !reflect.DeepEqual(entries, expected) {
+			t.Fatalf("Expected %q from %q, got %q", expected, raw, entries)
+		}
+	}
+
+	header := Header{}
+	header.SetTo("\"Doe, John\" <john@host.com>", "jane@host.com")
+	header.Set("Cc", "cc@host.com (Copy, Carbon)")
+	if to := header.To(); !reflect.DeepEqual(to, []string{"\"Doe, John\" <john@host.com>", "jane@host.com"}) {
+		t.Fatalf("Incorrect To: %q", to)
+	}
+	if cc := header.Cc(); !reflect.DeepEqual(cc, []string{"cc@host.com (Copy, Carbon)"}) {
+		t.Fatalf("Incorrect Cc: %q", cc)
+	}
+}
+
+// TestWriteToQuotesDisplayNames ...
+func TestWriteToQuotesDisplayNames(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"\"Doe, John\" <john@host.com>":              "\"Doe, John\" <john@host.com>",
+		"John Doe <john@host.com>":                   "John Doe <john@host.com>",
+		"\"J. R. \\\"Bob\\\" Dobbs\" <bob@host.com>": "\"J. R. \\\"Bob\\\" Dobbs\" <bob@host.com>",
+		"\"john@host.com\" <john@host.com>":          "\"john@host.com\" <john@host.com>",
+		"Zoë Ünal <zoe@host.com>":                    "=?UTF-8?b?Wm/DqyDDnG5hbA==?= <zoe@host.com>",
+		"plain@host.com":                             "plain@host.com",
+	}
+	for from, expected := range tests {
+		header := Header{}
+		header.SetFrom(from)
+		raw, err := header.Bytes()
+		if err != nil || string(raw) != "From: "+expected+"\n" {
+			t.Fatalf("Expected %q, got %q %v", expected, raw, err)
+		}
+		parsed, err := ReadHeader(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal("Could not read header:", err)
+		}
+		original, _ := mail.ParseAddress(from)
+		if addresses, err := parsed.AddressList("From"); err != nil || len(addresses) != 1 || *addresses[0] != *original {
+			t.Fatal("Display name did not round-trip:", addresses, err)
+		}
+	}
+}
+
+// TestMerge ...
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	defaults := Header{}
+	defaults.SetFrom("news@host.com")
+	defaults.Set("Auto-Submitted", "auto-generated")
+	defaults.SetListUnsubscribe("mailto:unsubscribe@host.com")
+	defaults.Add("X-Tag", "newsletter")
+
+	overlay := Header{
+		"from":    []string{"editor@host.com"}, // set directly, without a canonical key
+		"Subject": []string{"Weekly News"},
+		"x-tag":   []string{"weekly", "digest"},
+	}
+
+	// Without overwrite, multi-valued fields are appended, and singletons are kept
+	merged := defaults.Clone()
+	merged.Merge(overlay, false)
+	expected := Header{
+		"From":             []string{"news@host.com"},
+		"Subject":          []string{"Weekly News"},
+		"Auto-Submitted":   []string{"auto-generated"},
+		"List-Unsubscribe": []string{"<mailto:unsubscribe@host.com>"},
+		"X-Tag":            []string{"newsletter", "weekly", "digest"},
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("Expected %q, got %q", expected, merged)
+	}
+
+	// With overwrite, every field from the other header replaces this one's
+	merged = defaults.Clone()
+	merged.Merge(overlay, true)
+	expected["From"] = []string{"editor@host.com"}
+	expected["X-Tag"] = []string{"weekly", "digest"}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("Expected %q, got %q", expected, merged)
+	}
+
+	// The values are copied
+	merged["X-Tag"][0] = "changed"
+	merged.Add("Subject", "Another")
+	if overlay["x-tag"][0] != "weekly" || len(overlay["Subject"]) != 1 {
+		t.Fatal("Expected the other header to be unchanged:", overlay)
+	}
+}
+
+// TestReturnPath ...
+func TestReturnPath(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"bounces@host.com":             "<bounces@host.com>",
+		"<bounces@host.com>":           "<bounces@host.com>",
+		"Bounces <bounces@host.com>":   "<bounces@host.com>",
+		" bounces+id=to.com@host.com ": "<bounces+id=to.com@host.com>",
+		"":                             "<>",
+	}
+	for email, expected := range tests {
+		header := Header{}
+		header.SetReturnPath(email)
+		if header.Get("Return-Path") != expected {
+			t.Fatalf("Expected %q, got %q", expected, header.Get("Return-Path"))
+		}
+		if header.ReturnPath() != strings.Trim(expected, "<>") {
+			t.Fatalf("Expected the bare address for %q, got %q", expected, header.ReturnPath())
+		}
+	}
+
+	if path := (Header{}).ReturnPath(); path != "" {
+		t.Fatal("Expected no Return-Path:", path)
+	}
+}
+
+// TestDedupeRecipients ...
+func TestDedupeRecipients(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "Test Subject", "First <first@host.com>", "second@host.com")
+	header.SetCc("FIRST@host.com", "Third <third@host.com>", FormatGroup("Team", "second@host.com", "fourth@host.com"))
+	header.SetBcc("Third@Host.com", "first@host.com", "hidden@host.com")
+
+	// Not done unless asked for
+	raw, err := header.Bytes()
+	if err != nil || !strings.Contains(string(raw), "Cc: FIRST@host.com,") {
+		t.Fatalf("Expected the duplicate to be written: %q %v", raw, err)
+	}
+
+	removed, err := header.DedupeRecipients()
+	if err != nil {
+		t.Fatal("Could not dedupe recipients:", err)
+	}
+	var removedAddresses []string
+	for _, address := range removed {
+		removedAddresses = append(removedAddresses, address.Address)
+	}
+	if !reflect.DeepEqual(removedAddresses, []string{"FIRST@host.com", "second@host.com", "Third@Host.com", "first@host.com"}) {
+		t.Fatal("Incorrect removed addresses:", removedAddresses)
+	}
+	if header.Get("To") != "First <first@host.com>, second@host.com" ||
+		header.Get("Cc") != "Third <third@host.com>, Team: fourth@host.com;" ||
+		header.Get("Bcc") != "hidden@host.com" {
+		t.Fatalf("Incorrect recipients: %q", header)
+	}
+
+	// Nothing left in a field removes it, and nothing to remove leaves the header as it is
+	header.SetBcc("second@host.com")
+	if removed, err = header.DedupeRecipients(); err != nil || len(removed) != 1 || header.IsSet("Bcc") {
+		t.Fatalf("Expected the Bcc to be removed: %q %v %v", header, removed, err)
+	}
+	header.SetTo(`"Doe,  John" <john@host.com>`)
+	if removed, err = header.DedupeRecipients(); err != nil || len(removed) != 0 || header.Get("To") != `"Doe,  John" <john@host.com>` {
+		t.Fatalf("Expected no changes: %q %v %v", header, removed, err)
+	}
+
+	header.SetBcc("invalid address")
+	header.SetCc("john@host.com")
+	if _, err = header.DedupeRecipients(); err == nil || header.Get("Cc") != "john@host.com" {
+		t.Fatal("Expected an error, without changes, for an invalid Bcc:", header, err)
+	}
+}
+
+// TestRedirectRecipients ...
+func TestRedirectRecipients(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("Test Name <test.from@host.com>", "Test Subject", "alice@prod.com", "\"Doe, John\" <john@prod.org>")
+	header.SetCc("Zoë <zoe@prod.com>")
+	header.SetBcc("bcc@prod.com")
+	if err := header.RedirectRecipients("staging.test"); err != nil {
+		t.Fatal("Could not redirect recipients:", err)
+	}
+	expected := map[string]string{
+		"To":   "alice_at_prod.com@staging.test, \"Doe, John\" <john_at_prod.org@staging.test>",
+		"Cc":   "Zoë <zoe_at_prod.com@staging.test>",
+		"Bcc":  "bcc_at_prod.com@staging.test",
+		"From": "Test Name <test.from@host.com>",
+	}
+	for field, val := range expected {
+		if header.Get(field) != val {
+			t.Fatalf("Expected %s %q, got %q", field, val, header.Get(field))
+		}
+	}
+	addresses, err := header.AddressList("Cc")
+	if err != nil || addresses[0].Name != "Zoë" {
+		t.Fatal("Expected the display name to be kept:", addresses, err)
+	}
+
+	header = NewHeader("test.from@host.com", "Test Subject", "Alice <alice@prod.com>", "bob@prod.org")
+	if err = header.RedirectRecipientsWith("staging.test", RedirectReplaceDomain); err != nil || header.Get("To") != "Alice <alice@staging.test>, bob@staging.test" {
+		t.Fatal("Expected the domains to be replaced:", header.Get("To"), err)
+	}
+
+	header = NewHeader("test.from@host.com", "Test Subject", "alice@prod.com")
+	header.SetCc("not an address")
+	if err = header.RedirectRecipients("staging.test"); err == nil || header.Get("To") != "alice@prod.com" {
+		t.Fatal("Expected an error and an unchanged header for an invalid address list:", header.Get("To"), err)
+	}
+}
+
+// TestWriteToFoldsBetweenAddresses ...
+func TestWriteToFoldsBetweenAddresses(t *testing.T) {
+	t.Parallel()
+
+	addresses := []string{
+		"Jonathan Alexander Maximilian Doe <jonathan.alexander.doe@example.com>",
+		"Elizabeth Catherine Montgomery <elizabeth.montgomery@example.org>",
+		"\"Wolfeschlegelsteinhausen, Hubert\" <hubert.wolfeschlegelsteinhausen@example.net>",
+		"Maria Fernanda de los Santos <maria.fernanda.santos@example.com>",
+		"Alexander Konstantinovich Rimsky <alexander.rimsky@example.co.uk>",
+	}
+	header := Header{}
+	header.SetTo(addresses...)
+	raw, err := header.Bytes()
+	if err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n")
+	if len(lines) != len(addresses) {
+		t.Fatalf("Expected one address per line: %q", raw)
+	}
+	for i, line := range lines {
+		line = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(line, "To:"), " "), ",")
+		if line != addresses[i] {
+			t.Fatalf("Expected line to be the whole address %q, got %q", addresses[i], line)
+		}
+	}
+	parsed, err := ParseMessage(bytes.NewReader(append(raw, '\n')))
+	if err != nil || !reflect.DeepEqual(parsed.Header.To(), addresses) {
+		t.Fatal("Folded addresses did not parse back:", err)
+	}
+}
+
+// TestWriteToWithMaxLineLength ...
+func TestWriteToWithMaxLineLength(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com",
+		"A long subject line that goes on and on about nothing in particular, so that it has to fold",
+		"First Recipient <first@host.com>", "Second Recipient <second@host.com>", "Third Recipient <third@host.com>")
+	header.Set("X-Token", strings.Repeat("x", 1200))
+	if _, err := header.Bytes(); err == nil {
+		t.Fatal("Expected a line longer than MaxHeaderTotalLength to fail")
+	}
+	header.Set("X-Token", strings.Repeat("x", 500))
+
+	for _, limit := range []int{72, 78} {
+		buffer := &bytes.Buffer{}
+		if _, err := header.WriteToWith(buffer, WriteOptions{MaxLineLength: limit}); err != nil {
+			t.Fatal("Could not write header:", err)
+		}
+		longest := 0
+		for _, line := range strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n") {
+			if strings.Contains(line, strings.Repeat("x", 500)) {
+				continue // nowhere to fold
+			}
+			if len(line) > limit {
+				t.Fatalf("Line longer than %d: %q", limit, line)
+			}
+			longest = max(longest, len(line))
+		}
+		if longest < limit-10 {
+			t.Fatalf("Expected lines to be filled up to %d, the longest was %d: %q", limit, longest, buffer.String())
+		}
+		parsed, err := ReadHeader(buffer)
+		if err != nil || parsed.Subject() != header.Subject() || parsed.Get("To") != header.Get("To") {
+			t.Fatalf("Folded header did not round-trip: %q %v", parsed, err)
+		}
+	}
+
+	// Larger than the hard limit is clamped to it
+	buffer := &bytes.Buffer{}
+	if _, err := header.WriteToWith(buffer, WriteOptions{MaxLineLength: 5000}); err != nil ||
+		!strings.Contains(buffer.String(), "\nSubject: "+header.Subject()+"\n") {
+		t.Fatalf("Expected the subject on one line: %q %v", buffer.String(), err)
+	}
+	header.Set("X-Token", strings.Repeat("x", 1200))
+	if _, err := header.WriteToWith(buffer, WriteOptions{MaxLineLength: 5000}); err == nil {
+		t.Fatal("Expected a line longer than MaxHeaderTotalLength to fail")
+	}
+}
+
+// TestWriteToEmptyField ...
+func TestWriteToEmptyField(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	header.SetSubject("")
+	header.Set("X-Blank", "  ")
+	if !header.IsSet("Subject") || header.IsSet("Keywords") {
+		t.Fatal("Expected a deliberately empty Subject to be distinguishable from an unset field")
+	}
+
+	raw, err := header.Bytes()
+	if err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	if !strings.Contains(string(raw), "\nSubject:\n") || !strings.Contains(string(raw), "\nX-Blank:   \n") {
+		t.Fatalf("Expected the empty fields to be written: %q", raw)
+	}
+	parsed, err := ReadHeader(bytes.NewReader(raw))
+	if err != nil || !parsed.IsSet("Subject") || parsed.Subject() != "" {
+		t.Fatalf("Expected the empty Subject to round-trip: %q %v", parsed, err)
+	}
+	if err = parsed.ValidateWith(ValidateOptions{AllowMissingSubject: true}); err != nil {
+		t.Fatal("Expected an empty Subject to be allowed:", err)
+	}
+
+	buffer := &bytes.Buffer{}
+	if _, err = header.WriteToWith(buffer, WriteOptions{OmitEmpty: true}); err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	if strings.Contains(buffer.String(), "Subject") || strings.Contains(buffer.String(), "X-Blank") ||
+		!strings.Contains(buffer.String(), "\nTo: test.to@host.com\n") {
+		t.Fatalf("Expected the empty fields to be left out: %q", buffer.String())
+	}
+}
+
+// TestWriteToWithFoldWidths ...
+func TestWriteToWithFoldWidths(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "The quarterly report is attached, with the summary that was discussed on Monday",
+		"First Recipient <first@host.com>", "Second Recipient <second@host.com>")
+	opts := WriteOptions{FoldWidths: map[string]int{"Subject": 40}}
+	buffer := &bytes.Buffer{}
+	if _, err := header.WriteToWith(buffer, opts); err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	expected := "From: test.from@host.com\n" +
+		"Subject: The quarterly report is\n" +
+		" attached, with the summary that was\n" +
+		" discussed on Monday\n" +
+		"To: First Recipient <first@host.com>, Second Recipient <second@host.com>\n"
+	if buffer.String() != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, buffer.String())
+	}
+
+	// Overrides the MaxLineLength for the field only
+	opts = WriteOptions{MaxLineLength: 50, FoldWidths: map[string]int{"Subject": 998}}
+	buffer.Reset()
+	if _, err := header.WriteToWith(buffer, opts); err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	if !strings.Contains(buffer.String(), "\nSubject: "+header.Subject()+"\n") ||
+		!strings.Contains(buffer.String(), "\nTo: First Recipient <first@host.com>,\n Second Recipient <second@host.com>\n") {
+		t.Fatalf("Expected only the Subject to be unfolded: %q", buffer.String())
+	}
+}
+
+// TestWriteToFoldsBetweenEncodedWords ...
+func TestWriteToFoldsBetweenEncodedWords(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		subject string
+		opts    WriteOptions
+		width   int
+	}{
+		{strings.Repeat("Отчёт за квартал приложен к письму. ", 6), WriteOptions{}, MaxHeaderLineLength},
+		{strings.Repeat("Отчёт за квартал приложен к письму. ", 6), WriteOptions{MaxLineLength: 72}, 72},
+		{strings.Repeat("四半期報告書を添付します。", 8), WriteOptions{FoldWidths: map[string]int{"Subject": 40}}, 40},
+		{strings.Repeat("Café crème brûlée for the whole team ", 6), WriteOptions{Charset: "ISO-8859-1", MaxLineLength: 60}, 60},
+	}
+	decoder := &mime.WordDecoder{CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		raw, err := ioutil.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := decodeCharset(raw, charset)
+		return strings.NewReader(decoded), err
+	}}
+	for _, test := range tests {
+		header := Header{"Subject": []string{test.subject}}
+		buffer := &bytes.Buffer{}
+		if _, err := header.WriteToWith(buffer, test.opts); err != nil {
+			t.Fatal("Could not write header:", err)
+		}
+		lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+		if len(lines) < 2 {
+			t.Fatalf("Expected the Subject to be folded: %q", buffer.String())
+		}
+		var decoded string
+		for i, line := range lines {
+			if len(line) > test.width {
+				t.Fatalf("Line is longer than %d: %q", test.width, line)
+			}
+			if i == 0 {
+				line = strings.TrimPrefix(line, "Subject:")
+			}
+			for _, word := range strings.Fields(line) {
+				if len(word) > maxEncodedWordLength {
+					t.Fatalf("Encoded-word is longer than %d: %q", maxEncodedWordLength, word)
+				}
+				text, err := decoder.Decode(word)
+				if err != nil {
+					t.Fatalf("Line does not have only whole encoded-words: %q: %v", line, err)
+				}
+				decoded += text
+			}
+		}
+		if decoded != test.subject {
+			t.Fatalf("Expected the words to decode to %q, got %q", test.subject, decoded)
+		}
+	}
+}
+
+// TestWriteToFoldsEveryField ...
+func TestWriteToFoldsEveryField(t *testing.T) {
+	t.Parallel()
+
+	to := []string{"Jürgen Müller <juergen@host.com>", "Zoë Saldaña <zoe@host.com>", "Søren Kierkegaard <soren@host.com>",
+		"Łukasz Żółć <lukasz@host.com>", "Ana María Núñez <ana@host.com>"}
+	header := NewHeader("test.from@host.com", "The quarterly report is attached, with the summary that was discussed on Monday", to...)
+	header.Set("References", "<first.message.1234567890@host.com> <second.message.1234567890@host.com> <third@host.com>")
+	header.Set("Content-Type", "multipart/mixed; boundary=\"a.boundary.that.is.long.enough.to.go.past.the.usual.length\"")
+	raw, err := header.Bytes()
+	if err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n") {
+		if len(line) > MaxHeaderLineLength && !strings.HasPrefix(line, "Content-Type: ") {
+			t.Fatalf("Line is longer than %d: %q", MaxHeaderLineLength, line)
+		}
+	}
+	if !strings.Contains(string(raw), "Content-Type: "+header.Get("Content-Type")+"\n") {
+		t.Fatal("Expected the Content-Type to not be folded:", string(raw))
+	}
+
+	parsed, err := ReadHeader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not read header:", err)
+	}
+	addresses, err := parsed.AddressList("To")
+	if err != nil || len(addresses) != len(to) {
+		t.Fatalf("Expected %d whole addresses, got: %v %v", len(to), addresses, err)
+	}
+	for i, address := range addresses {
+		if expected, _ := mail.ParseAddress(to[i]); address.Name != expected.Name || address.Address != expected.Address {
+			t.Fatalf("Expected %q, got %q", to[i], address.String())
+		}
+	}
+	if parsed.Subject() != header.Subject() || parsed.Get("References") != header.Get("References") {
+		t.Fatalf("Folded fields did not round-trip: %q %q", parsed.Subject(), parsed.Get("References"))
+	}
+}
+
+// TestHasAnyHasAll ...
+func TestHasAnyHasAll(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	header.Set("List-Id", "<list.host.com>")
+
+	tests := []struct {
+		keys   []string
+		hasAny bool
+		hasAll bool
+	}{
+		{[]string{"from", "SUBJECT", "list-id"}, true, true},
+		{[]string{"List-Unsubscribe", "list-ID"}, true, false},
+		{[]string{"Cc", "Bcc", "X-Mailer"}, false, false},
+		{[]string{}, false, true},
+	}
+	for _, test := range tests {
+		if header.HasAny(test.keys...) != test.hasAny || header.HasAll(test.keys...) != test.hasAll {
+			t.Fatalf("Incorrect result for %q: %v %v", test.keys, header.HasAny(test.keys...), header.HasAll(test.keys...))
+		}
+	}
+	if (Header(nil)).HasAny("From") || (Header(nil)).HasAll("From") {
+		t.Fatal("Expected a nil header to have no keys")
+	}
+}
+
+// TestWriteToWithSMTPUTF8 ...
+func TestWriteToWithSMTPUTF8(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("Zoë Ünal <zoe@host.com>", "Café crème für alle", "test.to@host.com")
+	encoded := &bytes.Buffer{}
+	if _, err := header.WriteTo(encoded); err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	raw := &bytes.Buffer{}
+	if _, err := header.WriteToWith(raw, WriteOptions{SMTPUTF8: true}); err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+
+	if !strings.Contains(encoded.String(), "Subject: =?UTF-8?") || !strings.Contains(encoded.String(), "From: =?UTF-8?") {
+		t.Fatalf("Expected encoded-words by default: %q", encoded.String())
+	}
+	if !strings.Contains(raw.String(), "Subject: Café crème für alle\n") || !strings.Contains(raw.String(), "From: Zoë Ünal <zoe@host.com>\n") {
+		t.Fatalf("Expected raw UTF-8: %q", raw.String())
+	}
+	if strings.Contains(raw.String(), "=?") || raw.Len() >= encoded.Len() {
+		t.Fatalf("Expected raw UTF-8 to be smaller than encoded-words: %q", raw.String())
+	}
+	parsed, err := ParseMessage(strings.NewReader(raw.String() + "\nbody"))
+	if err != nil || parsed.Header.Subject() != "Café crème für alle" || parsed.Header.From() != "Zoë Ünal <zoe@host.com>" {
+		t.Fatalf("Raw UTF-8 header did not parse back: %q %v", parsed.Header, err)
+	}
+
+	// Folding counts octets, rather than characters
+	header.SetSubject(strings.Repeat("非常感谢你 ", 100))
+	raw.Reset()
+	if _, err := header.WriteToWith(raw, WriteOptions{SMTPUTF8: true}); err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	for _, line := range strings.Split(raw.String(), "\n") {
+		if len(line) > MaxHeaderTotalLength {
+			t.Fatal("Header line is too many octets:", len(line))
+		}
+	}
+	if !strings.Contains(raw.String(), "你\n 非") {
+		t.Fatalf("Expected the long subject to be folded: %q", raw.String())
+	}
+}
+
+// TestNormalize ...
+func TestNormalize(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	if err := header.Normalize(); err != nil {
+		t.Fatal("Expected no duplicates:", err)
+	}
+
+	header.Add("Date", "Mon, 02 Jan 2006 15:04:05 -0700")
+	header.Add("Date", "Tue, 03 Jan 2006 15:04:05 -0700")
+	header.Add("Subject", "Another Subject")
+	header.Add("Received", "from a.host.com by b.host.com")
+	header.Add("Received", "from b.host.com by c.host.com")
+	err := header.Normalize()
+	if duplicates, ok := err.(*DuplicateFieldsError); !ok || !reflect.DeepEqual(duplicates.Fields, []string{"Date", "Subject"}) {
+		t.Fatal("Expected the collapsed fields to be listed:", err)
+	}
+	if !reflect.DeepEqual(header["Date"], []string{"Tue, 03 Jan 2006 15:04:05 -0700"}) || header.Subject() != "Another Subject" {
+		t.Fatalf("Expected only the last value to survive: %q", header)
+	}
+	if len(header["Received"]) != 2 {
+		t.Fatal("Expected fields that may repeat to be kept:", header["Received"])
+	}
+
+	raw, err := header.Bytes()
+	if err != nil || strings.Count(string(raw), "Date: ") != 1 {
+		t.Fatalf("Expected a single Date to be written: %q %v", raw, err)
+	}
+}
+
+// TestReceived ...
+func TestReceived(t *testing.T) {
+	t.Parallel()
+
+	raw := "Received: from mail-sor-f41.google.com (mail-sor-f41.google.com. [209.85.220.41])\n" +
+		"        by mx.google.com with SMTPS id a2sor1234567qkb.10.2019.01.02.03.04.05\n" +
+		"        for <test.to@host.com>\n" +
+		"        (Google Transport Security);\n" +
+		"        Wed, 02 Jan 2019 03:04:05 -0800 (PST)\n" +
+		"Received: by 2002:a17:90a:1234:: with SMTP id abc123;\n" +
+		"        Wed, 02 Jan 2019 03:04:03 -0800 (PST)\n" +
+		"Received: from relay.host.com ([10.0.0.1]) by internal.host.com (Postfix) with ESMTP; not a date\n" +
+		"Received: garbled trace field\n" +
+		"Subject: Test Subject\n\nbody"
+	msg, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+
+	expected := []ReceivedInfo{
+		{From: "mail-sor-f41.google.com", By: "mx.google.com", With: "SMTPS", ID: "a2sor1234567qkb.10.2019.01.02.03.04.05", For: "test.to@host.com",
+			Date: time.Date(2019, 1, 2, 3, 4, 5, 0, time.FixedZone("", -8*60*60))},
+		{By: "2002:a17:90a:1234::", With: "SMTP", ID: "abc123", Date: time.Date(2019, 1, 2, 3, 4, 3, 0, time.FixedZone("", -8*60*60))},
+		{From: "relay.host.com", By: "internal.host.com", With: "ESMTP"},
+		{},
+	}
+	received := msg.Header.Received()
+	if len(received) != len(expected) {
+		t.Fatal("Incorrect number of Received fields:", len(received))
+	}
+	for i, info := range received {
+		if !info.Date.Equal(expected[i].Date) {
+			t.Fatalf("Expected date %v for hop %d, got %v", expected[i].Date, i, info.Date)
+		}
+		info.Date = expected[i].Date
+		if info != expected[i] {
+			t.Fatalf("Expected %+v for hop %d, got %+v", expected[i], i, info)
+		}
+	}
+
+	if received = (Header{}).Received(); len(received) != 0 {
+		t.Fatal("Expected no Received fields:", received)
+	}
+}
+
+// TestAddressGroups ...
+func TestAddressGroups(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "Test Subject",
+		FormatGroup("Team", "first@host.com", `"Doe, John" <john@host.com>`), "other@host.com", FormatGroup("Équipe", "zoe@host.com"))
+	header.SetCc(FormatGroup("undisclosed-recipients"))
+	raw, err := header.Bytes()
+	if err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	expected := "\nTo: Team: first@host.com, \"Doe, John\" <john@host.com>;, other@host.com,\n =?UTF-8?q?=C3=89quipe?=: zoe@host.com;\n"
+	if !strings.Contains(string(raw), expected) || !strings.Contains("\n"+string(raw), "\nCc: undisclosed-recipients:;\n") {
+		t.Fatalf("Expected the groups to be written: %q", raw)
+	}
+
+	parsed, err := ReadHeader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse header:", err)
+	}
+	addresses, err := parsed.AddressList("To")
+	if err != nil || len(addresses) != 4 || addresses[1].Name != "Doe, John" || addresses[3].Address != "zoe@host.com" {
+		t.Fatal("Expected every address in the groups:", addresses, err)
+	}
+	groups, err := parsed.AddressGroups("To")
+	if err != nil || len(groups) != 3 || groups[0].Name != "Team" || len(groups[0].Addresses) != 2 ||
+		groups[1].Name != "" || groups[1].Addresses[0].Address != "other@host.com" ||
+		groups[2].Name != "Équipe" || len(groups[2].Addresses) != 1 {
+		t.Fatalf("Expected the groups to round-trip: %v %v", groups, err)
+	}
+	if groups, err = parsed.AddressGroups("Cc"); err != nil || len(groups) != 1 || groups[0].Name != "undisclosed-recipients" || len(groups[0].Addresses) != 0 {
+		t.Fatalf("Expected an empty group: %v %v", groups, err)
+	}
+	if recipients, err := parsed.Recipients(); err != nil || len(recipients) != 4 {
+		t.Fatal("Expected the recipients in the groups:", recipients, err)
+	}
+
+	// Written again as it was parsed
+	rewritten, err := parsed.Bytes()
+	if err != nil || string(rewritten) != string(raw) {
+		t.Fatalf("Expected the groups to survive another write: %q %v", rewritten, err)
+	}
+
+	for _, invalid := range []string{"Team: first@host.com", ": first@host.com;", "Team: not an address;"} {
+		if _, err = parseAddressGroups(invalid); err == nil {
+			t.Fatal("Expected an error for an invalid group:", invalid)
+		}
+	}
+}
+
+// TestAddressListSourceRoute ...
+func TestAddressListSourceRoute(t *testing.T) {
+	t.Parallel()
+
+	raw := "From: <@relay.host.com,@gateway.host.com:test.from@host.com>\n" +
+		"To: Test Name <@relay.host.com:test.to@host.com>, plain@host.com\n" +
+		"Return-Path: <@relay.host.com:bounce@host.com>\n" +
+		"Subject: Test Subject\n\nbody"
+	msg, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+
+	from, err := msg.Header.AddressList("From")
+	if err != nil || len(from) != 1 || from[0].Address != "test.from@host.com" {
+		t.Fatal("Expected the route to be removed from the From:", from, err)
+	}
+	to, err := msg.Header.AddressList("To")
+	if err != nil || len(to) != 2 || to[0].Name != "Test Name" || to[0].Address != "test.to@host.com" || to[1].Address != "plain@host.com" {
+		t.Fatal("Expected the route to be removed from the To:", to, err)
+	}
+	if msg.Header.ReturnPath() != "bounce@host.com" {
+		t.Fatal("Expected the route to be removed from the Return-Path:", msg.Header.ReturnPath())
+	}
+	if err = msg.Header.Validate(); err != nil {
+		t.Fatal("Expected a source-routed header to be valid:", err)
+	}
+	if mailFrom, rcptTo, err := msg.SMTPEnvelope(); err != nil || mailFrom != "bounce@host.com" ||
+		!reflect.DeepEqual(rcptTo, []string{"test.to@host.com", "plain@host.com"}) {
+		t.Fatal("Incorrect envelope:", mailFrom, rcptTo, err)
+	}
+
+	written, err := msg.Header.Bytes()
+	if err != nil || !strings.Contains(string(written), "From: test.from@host.com\n") ||
+		!strings.Contains(string(written), "To: Test Name <test.to@host.com>, plain@host.com\n") {
+		t.Fatalf("Expected the bare addresses to be written: %q %v", written, err)
+	}
+	if _, err = (Header{}).AddressList("To"); err != mail.ErrHeaderNotPresent {
+		t.Fatal("Expected ErrHeaderNotPresent:", err)
+	}
+}
+
+// TestRecipients ...
+func TestRecipients(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "Test Subject", "First <first@host.com>", "\"Doe, John\" <john@host.com>")
+	header.SetCc("FIRST@host.com, Team: second@host.com, Third <third@host.com>;")
+	header.SetBcc("hidden@host.com")
+	recipients, err := header.Recipients()
+	if err != nil {
+		t.Fatal("Could not get recipients:", err)
+	}
+	expected := []mail.Address{
+		{Name: "First", Address: "first@host.com"},
+		{Name: "Doe, John", Address: "john@host.com"},
+		{Address: "second@host.com"},
+		{Name: "Third", Address: "third@host.com"},
+		{Address: "hidden@host.com"},
+	}
+	if len(recipients) != len(expected) {
+		t.Fatal("Incorrect recipients:", recipients)
+	}
+	for i, recipient := range recipients {
+		if *recipient != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected[i], *recipient)
+		}
+	}
+
+	// Bcc only
+	header = Header{}
+	header.SetBcc("Hidden <hidden@host.com>")
+	if recipients, err = header.Recipients(); err != nil || len(recipients) != 1 || recipients[0].Address != "hidden@host.com" {
+		t.Fatal("Expected the Bcc recipient:", recipients, err)
+	}
+
+	header.SetCc("not an address")
+	if _, err = header.Recipients(); err == nil {
+		t.Fatal("Expected an error for an invalid address list")
+	}
+}
+
+// TestAddressAccessors ...
+func TestAddressAccessors(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "Test Subject", "\"Doe, John\" <john@host.com>", "Team: first@host.com, second@host.com;")
+	to, err := header.ToAddresses()
+	if err != nil || !reflect.DeepEqual(to, []*mail.Address{
+		{Name: "Doe, John", Address: "john@host.com"}, {Address: "first@host.com"}, {Address: "second@host.com"},
+	}) {
+		t.Fatalf("Incorrect To addresses: %v %v", to, err)
+	}
+	if cc, err := header.CcAddresses(); err != nil || cc != nil {
+		t.Fatal("Expected no Cc addresses:", cc, err)
+	}
+
+	if err = header.AddTo(&mail.Address{Name: "Smith, Jane", Address: "jane@host.com"}, &mail.Address{Address: "JOHN@host.com"}); err != nil {
+		t.Fatal("Could not add to the To:", err)
+	}
+	if expected := "\"Doe, John\" <john@host.com>, Team: first@host.com, second@host.com;, \"Smith, Jane\" <jane@host.com>"; header.Get("To") != expected {
+		t.Fatalf("Expected To %q, got %q", expected, header.Get("To"))
+	}
+	if err = header.RemoveTo("First@host.com", "John Doe <john@host.com>"); err != nil {
+		t.Fatal("Could not remove from the To:", err)
+	}
+	if expected := "Team: second@host.com;, \"Smith, Jane\" <jane@host.com>"; header.Get("To") != expected {
+		t.Fatalf("Expected To %q, got %q", expected, header.Get("To"))
+	}
+
+	if err = header.AddCc(&mail.Address{Name: "Zoë", Address: "zoe@host.com"}); err != nil || header.Get("Cc") != "Zoë <zoe@host.com>" {
+		t.Fatal("Incorrect Cc:", header.Get("Cc"), err)
+	}
+	if err = header.RemoveCc("zoe@host.com"); err != nil || header.IsSet("Cc") {
+		t.Fatal("Expected the Cc to be removed:", header.Get("Cc"), err)
+	}
+
+	header.SetBcc("hidden@host.com")
+	if err = header.AddBcc(&mail.Address{Address: "other@host.com"}); err != nil {
+		t.Fatal("Could not add to the Bcc:", err)
+	}
+	if bcc, err := header.BccAddresses(); err != nil || len(bcc) != 2 || bcc[1].Address != "other@host.com" {
+		t.Fatal("Incorrect Bcc addresses:", bcc, err)
+	}
+
+	header.SetCc("not an address")
+	if _, err = header.CcAddresses(); err == nil {
+		t.Fatal("Expected an error for an invalid address list")
+	}
+	if err = header.AddCc(&mail.Address{Address: "cc@host.com"}); err == nil || header.Get("Cc") != "not an address" {
+		t.Fatal("Expected an invalid Cc to be left unchanged:", err)
+	}
+}
+
+// TestDryRun ...
+func TestDryRun(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("\"Doe, John\" <john@host.com>", "非常感谢你", "Zoë <zoe@host.com>, plain@host.com")
+	header.SetCc(strings.Repeat("Recipient Name <recipient@host.com>, ", 3) + "last@host.com")
+	header.Set("X-Mailer", "Mailer 1.0")
+	header.SetBcc("hidden@host.com")
+
+	plans, err := header.DryRun(WriteOptions{})
+	if err != nil {
+		t.Fatal("Could not dry run:", err)
+	}
+	expected := map[string]HeaderEncoding{
+		"From":     HeaderQuoted,
+		"Subject":  HeaderBEncoded,
+		"To":       HeaderBEncoded,
+		"Cc":       HeaderRaw,
+		"X-Mailer": HeaderRaw,
+	}
+	if len(plans) != len(expected) {
+		t.Fatal("Expected a plan for each field written, without the Bcc:", plans)
+	}
+
+	raw, err := header.Bytes()
+	if err != nil {
+		t.Fatal("Could not write header:", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n")
+	for _, plan := range plans {
+		if plan.Encoding != expected[plan.Field] || plan.Value != header.Get(plan.Field) {
+			t.Fatalf("Expected %s to be %q, got %q", plan.Field, expected[plan.Field], plan.Encoding)
+		}
+
+		// The plan matches what is written
+		if !strings.HasPrefix(lines[0], plan.Field+": ") {
+			t.Fatalf("Expected %s to be written next: %q", plan.Field, lines[0])
+		}
+		written := lines[0]
+		for i, fold := range plan.Folds {
+			if len(lines[i]) != fold || !strings.HasPrefix(lines[i+1], " ") {
+				t.Fatalf("Expected %s to fold at column %d: %q", plan.Field, fold, lines[i])
+			}
+			written += lines[i+1]
+		}
+		lines = lines[len(plan.Folds)+1:]
+		switch value := strings.TrimPrefix(written, plan.Field+": "); plan.Encoding {
+		case HeaderRaw:
+			if value != plan.Value {
+				t.Fatalf("Expected %s to be written raw: %q", plan.Field, value)
+			}
+		case HeaderQuoted:
+			if strings.Contains(value, "=?") || !strings.Contains(value, "\"") {
+				t.Fatalf("Expected %s to be quoted: %q", plan.Field, value)
+			}
+		case HeaderBEncoded, HeaderQEncoded:
+			if !strings.Contains(value, "?"+strings.ToLower(string(plan.Encoding))+"?") {
+				t.Fatalf("Expected %s to be %s encoded: %q", plan.Field, plan.Encoding, value)
+			}
+		}
+	}
+	if len(lines) != 0 {
+		t.Fatal("Expected every written line to be planned:", lines)
+	}
+	for _, plan := range plans {
+		if plan.Field == "Cc" && len(plan.Folds) == 0 {
+			t.Fatal("Expected the long Cc to fold")
+		}
+	}
+
+	// Nothing is encoded with SMTPUTF8
+	if plans, err = header.DryRun(WriteOptions{SMTPUTF8: true}); err != nil || plans[2].Field != "Subject" || plans[2].Encoding != HeaderRaw {
+		t.Fatal("Expected the Subject to be raw:", plans, err)
+	}
+	header = Header{}
+	header.SetSubject("Café crème")
+	plans, err = header.DryRun(WriteOptions{Charset: "ISO-8859-1"})
+	if err != nil || plans[0].Encoding != HeaderQEncoded {
+		t.Fatal("Expected the Subject to be Q encoded:", plans, err)
+	}
+}
+
+// TestEncodingOverhead ...
+func TestEncodingOverhead(t *testing.T) {
+	t.Parallel()
+
+	subject := "Café crème brûlée, s'il vous plaît"
+	header := NewHeader("test.from@host.com", subject, "test.to@host.com")
+	overheads, err := header.EncodingOverhead(WriteOptions{UseCRLF: true})
+	if err != nil || len(overheads) != 3 {
+		t.Fatal("Could not measure the overhead:", overheads, err)
+	}
+	for _, overhead := range overheads {
+		switch overhead.Field {
+		case "Subject":
+			encoded := mime.BEncoding.Encode("UTF-8", subject)
+			if overhead.Raw != len(subject) || overhead.Encoded != len(encoded) {
+				t.Fatalf("Expected the subject to grow from %d to %d: %+v", len(subject), len(encoded), overhead)
+			}
+			if growth := overhead.Growth(); growth < 0.4 || growth != float64(len(encoded)-len(subject))/float64(len(subject)) {
+				t.Fatal("Incorrect growth:", growth)
+			}
+		default:
+			if overhead.Raw != overhead.Encoded || overhead.Growth() != 0 {
+				t.Fatal("Expected no overhead for an ASCII field:", overhead)
+			}
+		}
+	}
+
+	// Folding does not count as overhead
+	header.SetSubject(strings.Repeat("word ", 300))
+	overheads, err = header.EncodingOverhead(WriteOptions{MaxLineLength: 72})
+	if err != nil || overheads[1].Field != "Subject" || overheads[1].Encoded != overheads[1].Raw {
+		t.Fatal("Expected a folded ASCII subject to have no overhead:", overheads, err)
+	}
+}
+
+// TestResentBlocks ...
+func TestResentBlocks(t *testing.T) {
+	t.Parallel()
+
+	raw := "Resent-From: second.forwarder@host.com\n" +
+		"Resent-To: final@host.com\n" +
+		"Resent-Date: Wed, 03 Jan 2024 10:00:00 +0000\n" +
+		"Resent-Message-Id: <second@host.com>\n" +
+		"Resent-From: First Forwarder <first.forwarder@host.com>\n" +
+		"Resent-To: second.forwarder@host.com, other@host.com\n" +
+		"Resent-Date: Tue, 02 Jan 2024 09:00:00 +0000\n" +
+		"Resent-Message-Id: <first@host.com>\n" +
+		"From: original@host.com\n" +
+		"To: first.forwarder@host.com\n" +
+		"Date: Mon, 01 Jan 2024 08:00:00 +0000\n" +
+		"Subject: Original\n\nbody"
+	msg, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	blocks, err := msg.Header.ResentBlocks()
+	if err != nil || len(blocks) != 2 {
+		t.Fatal("Expected two resent blocks:", blocks, err)
+	}
+	expected := []ResentBlock{
+		{Date: time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC), From: "second.forwarder@host.com", To: "final@host.com", MessageID: "second@host.com"},
+		{Date: time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC), From: "First Forwarder <first.forwarder@host.com>",
+			To: "second.forwarder@host.com, other@host.com", MessageID: "first@host.com"},
+	}
+	for i := range expected {
+		if !blocks[i].Date.Equal(expected[i].Date) {
+			t.Fatal("Incorrect date:", i, blocks[i].Date)
+		}
+		blocks[i].Date = expected[i].Date
+		if !reflect.DeepEqual(blocks[i], expected[i]) {
+			t.Fatalf("Expected block %d to be %+v, got %+v", i, expected[i], blocks[i])
+		}
+	}
+
+	if blocks, err = (Header{}).ResentBlocks(); err != nil || len(blocks) != 0 {
+		t.Fatal("Expected no resent blocks:", blocks, err)
+	}
+	// A field that is only in some of the blocks can not be matched to them
+	msg.Header["Resent-Cc"] = []string{"cc@host.com"}
+	if _, err = msg.Header.ResentBlocks(); err == nil {
+		t.Fatal("Expected an error for a Resent-Cc in only one of the blocks")
+	}
+	delete(msg.Header, "Resent-Cc")
+	msg.Header["Resent-Date"] = msg.Header["Resent-Date"][:1]
+	if _, err = msg.Header.ResentBlocks(); err == nil {
+		t.Fatal("Expected an error for a block without a Resent-Date")
+	}
+}
+
+// TestMessageResentBlocks ...
+func TestMessageResentBlocks(t *testing.T) {
+	t.Parallel()
+
+	raw := "Resent-Date: Wed, 03 Jan 2024 10:00:00 +0000\n" +
+		"Resent-From: second.forwarder@host.com\n" +
+		"Resent-Sender: assistant@host.com\n" +
+		"Resent-To: final@host.com\n" +
+		"Resent-Cc: archive@host.com\n" +
+		"Received: from relay.host.com by mx.host.com; Wed, 03 Jan 2024 10:00:00 +0000\n" +
+		"Resent-Date: Tue, 02 Jan 2024 09:00:00 +0000\n" +
+		"Resent-From: first.forwarder@host.com\n" +
+		"Resent-To: second.forwarder@host.com\n" +
+		"Resent-Message-Id: <first@host.com>\n" +
+		"From: original@host.com\n" +
+		"Date: Mon, 01 Jan 2024 08:00:00 +0000\n" +
+		"Subject: Original\n\nbody"
+	msg, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	if _, err = msg.Header.ResentBlocks(); err == nil {
+		t.Fatal("Expected the Header to be unable to match up the blocks")
+	}
+	blocks, err := msg.ResentBlocks()
+	if err != nil || len(blocks) != 2 {
+		t.Fatal("Expected two resent blocks:", blocks, err)
+	}
+	expected := []ResentBlock{
+		{Date: blocks[0].Date, From: "second.forwarder@host.com", Sender: "assistant@host.com", To: "final@host.com", Cc: "archive@host.com"},
+		{Date: blocks[1].Date, From: "first.forwarder@host.com", To: "second.forwarder@host.com", MessageID: "first@host.com"},
+	}
+	if !reflect.DeepEqual(blocks, expected) || blocks[0].Date.Day() != 3 || blocks[1].Date.Day() != 2 {
+		t.Fatalf("Expected %+v, got %+v", expected, blocks)
+	}
+
+	// Blocks without anything between them are split at a repeated field
+	msg.FieldOrder = append(msg.FieldOrder[:5:5], msg.FieldOrder[6:]...)
+	if blocks, err = msg.ResentBlocks(); err != nil || len(blocks) != 2 || blocks[1].MessageID != "first@host.com" {
+		t.Fatal("Expected two consecutive resent blocks:", blocks, err)
+	}
+
+	msg.Header = msg.Header.Clone()
+	msg.Header["Resent-From"] = msg.Header["Resent-From"][:1]
+	if _, err = msg.ResentBlocks(); err == nil {
+		t.Fatal("Expected an error for a block without a Resent-From")
+	}
+	if blocks, err = (&Message{Header: Header{}}).ResentBlocks(); err != nil || len(blocks) != 0 {
+		t.Fatal("Expected no resent blocks:", blocks, err)
+	}
+}
@@ -10,11 +10,14 @@ package email
 
 import (
 	"bytes"
-	"encoding/base64"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"mime/quotedprintable"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -35,6 +38,14 @@ type Message struct {
 	// Header is this message's key-value MIME-style pairs in its header.
 	Header Header
 
+	// FieldOrder is the canonical keys of the header fields in the order they were parsed,
+	// with a key for each value, such as "Received" for each trace field, so that the Header
+	// is written back out in that order rather than sorted. Any values added to a field are
+	// written along with its last value in the order, and fields that are not in it at all,
+	// such as ones added after parsing, are written after the rest, sorted by key.
+	// It is set by ParseMessage and ReadMessage, and for the parts of multipart messages.
+	FieldOrder []string
+
 	// Preamble is any text that appears before the first mime multipart,
 	// and may only be full in the case where this Message has a Content-Type of "multipart".
 	Preamble []byte
@@ -59,6 +70,19 @@ type Message struct {
 	// quoted-printable or base64, and will be re-encoded when written out
 	// based on the Content-Type.
 	Body []byte
+
+	// MissingBoundary is true when this message has a Content-Type of "multipart",
+	// but was parsed from a corrupt body without any boundary delimiters, so the
+	// whole body is in a single part with the same Content-Type as this message.
+	MissingBoundary bool
+
+	// BodyReader is a reader over the body of this message, and is full instead
+	// of any other payload when the message was read with ReadMessage,
+	// so that large messages can be streamed rather than held in memory.
+	// Like Body, it is already decoded if the Content-Transfer-Encoding was
+	// quoted-printable or base64. If there is no Body, it is streamed when written
+	// out (such as for attachments from AttachStream), which can only be done once.
+	BodyReader io.Reader
 }
 
 // Payload will return the payload of the message, which can only be one the
@@ -180,14 +204,188 @@ func contentTypePrefixFilterClosure(contentTypePrefix string) func(*Message) boo
 	}
 }
 
+// addressPattern matches email address-like text in a body.
+var addressPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)
+
+// AllAddresses returns every email address mentioned in this message: those in
+// the From, Reply-To, To, Cc, and Bcc header fields, followed by any address-like
+// text found in the decoded text bodies (such as text/plain and text/html) of this
+// message and all messages contained within it. Addresses are returned bare,
+// without display names, and duplicates are removed case-insensitively.
+func (m *Message) AllAddresses() ([]string, error) {
+	addresses := make([]string, 0, 1)
+	seen := make(map[string]bool)
+	add := func(address string) {
+		if key := strings.ToLower(address); !seen[key] {
+			seen[key] = true
+			addresses = append(addresses, address)
+		}
+	}
+
+	for _, field := range []string{"From", "Reply-To", "To", "Cc", "Bcc"} {
+		if len(m.Header.Get(field)) == 0 {
+			continue
+		}
+		list, err := m.Header.AddressList(field)
+		if err != nil {
+			return addresses, err
+		}
+		for _, address := range list {
+			add(address.Address)
+		}
+	}
+
+	for _, part := range m.MessagesContentTypePrefix("text") {
+		for _, address := range addressPattern.FindAll(part.Body, -1) {
+			add(strings.TrimRight(string(address), "."))
+		}
+	}
+	return addresses, nil
+}
+
 // Methods required for sending a message:
 
-// Save adds headers for the "Message-Id", "Date", and "MIME-Version", if missing.
+// Save adds headers for the "Message-Id", "Date", and "MIME-Version",
+// and "X-Mailer" if XMailer is set, but only where they are missing.
 // An error is returned if the Message-Id can not be created.
 func (m *Message) Save() error {
 	return m.Header.Save()
 }
 
+// ResentBlocks returns the Resent-* blocks like Header.ResentBlocks, but for a parsed message
+// groups the fields by their FieldOrder, so that each block is a run of consecutive Resent-*
+// fields, and may have optional fields that others do not, such as a Resent-Cc. A field that
+// is already in the current block starts a new one. Every block must have a Resent-Date and
+// Resent-From. Without a FieldOrder, it is the same as Header.ResentBlocks.
+func (m *Message) ResentBlocks() ([]ResentBlock, error) {
+	if len(m.FieldOrder) == 0 {
+		return m.Header.ResentBlocks()
+	}
+	var blocks []ResentBlock
+	var fields map[string]string
+	endBlock := func() error {
+		if fields == nil {
+			return nil
+		}
+		for _, field := range []string{"Resent-Date", "Resent-From"} {
+			if _, ok := fields[field]; !ok {
+				return &HeaderFieldError{Field: field, Err: errors.New("Every Resent block must have a Resent-Date and Resent-From")}
+			}
+		}
+		block, err := newResentBlock(func(field string) string { return fields[field] })
+		if err != nil {
+			return err
+		}
+		blocks, fields = append(blocks, block), nil
+		return nil
+	}
+
+	counts := make(map[string]int, len(m.FieldOrder))
+	for _, key := range m.FieldOrder {
+		index := counts[key]
+		counts[key]++
+		if !strings.HasPrefix(key, "Resent-") {
+			if err := endBlock(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if index >= len(m.Header[key]) {
+			continue // removed after it was parsed
+		}
+		if _, ok := fields[key]; ok {
+			if err := endBlock(); err != nil {
+				return nil, err
+			}
+		}
+		if fields == nil {
+			fields = make(map[string]string)
+		}
+		fields[key] = m.Header[key][index]
+	}
+	if err := endBlock(); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// ComplianceLevel is how strictly ComplianceCheck checks a message's header.
+type ComplianceLevel int
+
+const (
+	// ComplianceRFC5322 requires the minimum fields of RFC 5322 3.6: a From, with a Sender
+	// if it has more than one address, a Date, and no more than one of each singleton field.
+	// A missing Message-Id is only recommended, and reported with ErrMissingRecommendedField.
+	ComplianceRFC5322 ComplianceLevel = iota
+	// ComplianceDeliverable also requires what most receiving servers expect: valid address
+	// fields, at least one recipient, a Message-Id, and a MIME-Version if the message is MIME.
+	ComplianceDeliverable
+)
+
+// ErrMissingRecommendedField is reported by ComplianceCheck for a field that should be
+// set, but that is not required at the ComplianceLevel.
+var ErrMissingRecommendedField = errors.New("Message missing recommended header field")
+
+// ComplianceCheck returns every problem with this message's header at the ComplianceLevel,
+// each a *HeaderFieldError naming the field, or nil if there are none.
+// Call Save first for a Message-Id, Date, and MIME-Version to be added where they are missing.
+func (m *Message) ComplianceCheck(level ComplianceLevel) []error {
+	var problems []error
+	report := func(field string, err error) {
+		problems = append(problems, &HeaderFieldError{Field: field, Err: err})
+	}
+	h := m.Header
+
+	if len(strings.TrimSpace(h.Get("From"))) == 0 {
+		report("From", ErrHeadersMissingField)
+	} else if from, err := h.AddressList("From"); err != nil {
+		report("From", err)
+	} else if len(from) > 1 && len(h.Get("Sender")) == 0 {
+		report("Sender", ErrMissingSender)
+	}
+	if len(strings.TrimSpace(h.Get("Date"))) == 0 {
+		report("Date", ErrHeadersMissingField)
+	} else if _, err := h.Date(); err != nil {
+		report("Date", err)
+	}
+	for _, field := range singletonFields {
+		if len(h[field]) > 1 {
+			report(field, errors.New("Header field may only appear once"))
+		}
+	}
+	if len(h.MessageID()) == 0 {
+		if level >= ComplianceDeliverable {
+			report("Message-Id", ErrHeadersMissingField)
+		} else {
+			report("Message-Id", ErrMissingRecommendedField)
+		}
+	}
+	if level < ComplianceDeliverable {
+		return problems
+	}
+
+	if id := h.MessageID(); len(id) > 0 && (!strings.Contains(id, "@") || strings.ContainsAny(id, "<> \t")) {
+		report("Message-Id", fmt.Errorf("Invalid Message-Id: %q", h.Get("Message-Id")))
+	}
+	for _, field := range sortedHeaderFields(h) {
+		if field == "From" || classifyHeader(field) != addressListHeader {
+			continue // the From was already checked
+		}
+		for _, val := range h[field] {
+			if _, err := parseAddressList(val); err != nil {
+				report(field, err)
+			}
+		}
+	}
+	if recipients, err := h.Recipients(); err == nil && len(recipients) == 0 {
+		report("To", errors.New("Message must have a recipient (To, Cc, or Bcc)"))
+	}
+	if _, ok := h.MIMEVersion(); !ok && (h.HasAny("Content-Type", "Content-Transfer-Encoding") || len(m.Parts) > 0 || m.SubMessage != nil) {
+		report("Mime-Version", ErrHeadersMissingField)
+	}
+	return problems
+}
+
 // Bytes returns the bytes representing this message.  It is a convenience
 // method that calls WriteTo on a buffer, returning its bytes.
 func (m *Message) Bytes() ([]byte, error) {
@@ -196,12 +394,105 @@ func (m *Message) Bytes() ([]byte, error) {
 	return buffer.Bytes(), err
 }
 
+// ErrUnknownSize is returned by Size for a message with a body that is streamed
+// from a BodyReader, without a size parameter on its Content-Disposition, or with
+// an encoding whose length depends on the content, such as quoted-printable.
+var ErrUnknownSize = errors.New("Message has a streamed body of unknown size")
+
+// Size returns the size in bytes of this message as it would be written out by WriteTo,
+// including the encoding of its header and bodies, without holding it all in memory.
+// Streamed bodies, such as from AttachStream, are not read, so they must have a known size,
+// and be base64 encoded or binary, but not text, or else an error of ErrUnknownSize is returned.
+func (m *Message) Size() (int64, error) {
+	sized, err := m.sizeable()
+	if err != nil {
+		return 0, err
+	}
+	counter := &countingWriter{}
+	_, err = sized.WriteTo(counter)
+	return counter.n, err
+}
+
+// sizeable returns a copy of this message, and the parts within it, that can be written
+// out to find its size, with any streamed bodies replaced by zeros of the same size.
+// That is only the same size when written if the body is base64 or binary, without any
+// line endings to normalize, since otherwise the written length depends on the content.
+func (m *Message) sizeable() (*Message, error) {
+	sized := *m
+	if m.SubMessage != nil {
+		subMessage, err := m.SubMessage.sizeable()
+		if err != nil {
+			return nil, err
+		}
+		sized.SubMessage = subMessage
+	}
+	sized.Parts = make([]*Message, len(m.Parts))
+	for i, part := range m.Parts {
+		sizedPart, err := part.sizeable()
+		if err != nil {
+			return nil, err
+		}
+		sized.Parts[i] = sizedPart
+	}
+	if len(m.Body) == 0 && m.BodyReader != nil {
+		_, params, _ := m.Header.ContentDisposition()
+		size, err := strconv.ParseInt(params["size"], 10, 64)
+		if err != nil || size < 0 {
+			return nil, ErrUnknownSize
+		}
+		encoding, _ := m.bodyTransferEncoding()
+		if encoding = strings.ToLower(encoding); (encoding != "base64" && encoding != "binary") || m.normalizesBody(encoding) {
+			return nil, ErrUnknownSize
+		}
+		sized.BodyReader = io.LimitReader(zeroReader{}, size)
+	}
+	return &sized, nil
+}
+
+// normalizesBody returns true if the line endings of this message's body are normalized when it
+// is written with the Content-Transfer-Encoding, which they are for text bodies, other than
+// "binary" ones, and a message without a Content-Type is text (RFC 2045 5.2).
+func (m *Message) normalizesBody(encoding string) bool {
+	contentType := strings.ToLower(m.Header.Get("Content-Type"))
+	return !strings.EqualFold(encoding, "binary") && (len(contentType) == 0 || strings.HasPrefix(contentType, "text"))
+}
+
 // WriteTo writes out this Message and its payloads, recursively.
-// Any text bodies will be quoted-printable encoded,
+// Bodies are encoded according to their Content-Transfer-Encoding
+// (quoted-printable and base64 are supported). If it is missing,
+// any text bodies will be quoted-printable encoded,
 // and all other bodies will be base64 encoded.
 func (m *Message) WriteTo(w io.Writer) (int64, error) {
+	return m.WriteToWith(w, WriteOptions{})
+}
 
-	total, err := m.Header.WriteTo(w)
+// WriteToWith writes out this Message and its payloads like WriteTo,
+// using the WriteOptions.
+// With WriteOptions.Strictness, the whole message is checked or repaired before it is written.
+func (m *Message) WriteToWith(w io.Writer, opts WriteOptions) (int64, error) {
+	switch opts.Strictness {
+	case StrictnessStrict:
+		if !opts.UseCRLF {
+			return 0, ErrBareLF
+		}
+		if err := m.requiredFieldsError(); err != nil {
+			return 0, err
+		}
+		if err := m.strictBodyError(); err != nil {
+			return 0, err
+		}
+	case StrictnessLenient:
+		m, opts.UseCRLF = m.repairedCopy(), true
+	}
+	return m.writeTo(w, opts)
+}
+
+// writeTo writes out this Message and its payloads for WriteToWith, recursively.
+func (m *Message) writeTo(w io.Writer, opts WriteOptions) (int64, error) {
+
+	headerOpts := opts
+	headerOpts.fieldOrder = m.FieldOrder
+	total, err := m.Header.WriteToWith(w, headerOpts)
 	if err != nil {
 		return total, err
 	}
@@ -210,33 +501,109 @@ func (m *Message) WriteTo(w io.Writer) (int64, error) {
 	if err != nil && err != ErrHeadersMissingField {
 		return total, err
 	}
-	hasParts := strings.HasPrefix(mediaType, "multipart")
+	// A multipart message from a corrupt body, without parts, is written out as its body
+	hasParts := strings.HasPrefix(mediaType, "multipart") && (len(m.Parts) > 0 || (m.Body == nil && m.BodyReader == nil))
 	hasSubMessage := strings.HasPrefix(mediaType, "message")
 
 	if !hasParts && !hasSubMessage {
-		return m.writeBody(w, total)
+		return m.writeBody(w, total, opts)
 	}
 
-	written, err := io.WriteString(w, "\n")
+	written, err := io.WriteString(w, newline(opts.UseCRLF))
 	total += int64(written)
 	if err != nil {
 		return total, err
 	}
 
 	if hasSubMessage {
-		written2, err := m.SubMessage.WriteTo(w)
+		written2, err := m.SubMessage.writeTo(w, opts)
 		return total + written2, err
 
 	}
 	// hasParts
-	return m.writeParts(w, mediaTypeParams["boundary"], total)
+	return m.writeParts(w, mediaTypeParams["boundary"], total, opts)
+}
+
+// WriteToContext writes out this Message and its payloads like WriteTo, but stops early
+// with the context's error once it is cancelled, such as when sending a message with
+// large attachments over a slow connection. The context is checked before every write.
+func (m *Message) WriteToContext(ctx context.Context, w io.Writer) (int64, error) {
+	return m.WriteToWith(&contextWriter{ctx: ctx, w: w}, WriteOptions{})
+}
+
+// contextWriter writes to w until its context is done.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+// Write ...
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+// WriteCanonical writes out this Message like WriteTo, but with its volatile fields fixed,
+// so that the output is byte-for-byte reproducible, such as for golden file tests.
+// The Message-Id is set to fixedID, the Date to fixedDate, and the boundary of the
+// top multipart to fixedBoundary, with any nested multiparts given boundaries
+// derived from it. This Message is not changed.
+func (m *Message) WriteCanonical(w io.Writer, fixedID, fixedBoundary string, fixedDate time.Time) error {
+	count := 0
+	canonical, err := m.canonicalCopy(fixedBoundary, &count)
+	if err != nil {
+		return err
+	}
+	canonical.Header.SetMessageID(fixedID)
+	canonical.Header.SetDate(fixedDate)
+	_, err = canonical.WriteTo(w)
+	return err
+}
+
+// canonicalCopy returns a copy of this message, and the parts within it, with the
+// boundary of each multipart replaced by the fixedBoundary and a count of those before it.
+func (m *Message) canonicalCopy(fixedBoundary string, count *int) (*Message, error) {
+	canonical := *m
+	canonical.Header = m.Header.Clone()
+	if mediaType, params, err := m.Header.ContentType(); err == nil && strings.HasPrefix(mediaType, "multipart") {
+		params["boundary"] = fixedBoundary
+		if *count > 0 {
+			params["boundary"] = fmt.Sprintf("%s.%d", fixedBoundary, *count)
+		}
+		*count++
+		if !validBoundary(params["boundary"]) {
+			return nil, fmt.Errorf("Invalid boundary: %q", params["boundary"])
+		}
+		if err = canonical.Header.SetContentType(mediaType, params); err != nil {
+			return nil, err
+		}
+	}
+	if m.SubMessage != nil {
+		subMessage, err := m.SubMessage.canonicalCopy(fixedBoundary, count)
+		if err != nil {
+			return nil, err
+		}
+		canonical.SubMessage = subMessage
+	}
+	canonical.Parts = make([]*Message, len(m.Parts))
+	for i, part := range m.Parts {
+		canonicalPart, err := part.canonicalCopy(fixedBoundary, count)
+		if err != nil {
+			return nil, err
+		}
+		canonical.Parts[i] = canonicalPart
+	}
+	return &canonical, nil
 }
 
 // writeParts ...
-func (m *Message) writeParts(w io.Writer, boundary string, total int64) (int64, error) {
+func (m *Message) writeParts(w io.Writer, boundary string, total int64, opts WriteOptions) (int64, error) {
+	nl := newline(opts.UseCRLF)
 
 	if len(m.Preamble) > 0 {
-		written, err := fmt.Fprintf(w, "%s\n", m.Preamble)
+		written, err := fmt.Fprintf(w, "%s%s", normalizeLineEndings(m.Preamble, opts.UseCRLF), nl)
 		total += int64(written)
 		if err != nil {
 			return total, err
@@ -244,26 +611,26 @@ func (m *Message) writeParts(w io.Writer, boundary string, total int64) (int64,
 	}
 
 	for _, part := range m.Parts {
-		written, err := fmt.Fprintf(w, "\n--%s\n", boundary)
+		written, err := fmt.Fprintf(w, "%s--%s%s", nl, boundary, nl)
 		total += int64(written)
 		if err != nil {
 			return total, err
 		}
-		written2, err2 := part.WriteTo(w)
+		written2, err2 := part.writeTo(w, opts)
 		total += written2
 		if err2 != nil {
 			return total, err2
 		}
 	}
 
-	written, err := fmt.Fprintf(w, "\n--%s--\n", boundary)
+	written, err := fmt.Fprintf(w, "%s--%s--%s", nl, boundary, nl)
 	total += int64(written)
 	if err != nil {
 		return total, err
 	}
 
 	if len(m.Epilogue) > 0 {
-		written, err = fmt.Fprintf(w, "%s\n", m.Epilogue)
+		written, err = fmt.Fprintf(w, "%s%s", normalizeLineEndings(m.Epilogue, opts.UseCRLF), nl)
 		total += int64(written)
 		if err != nil {
 			return total, err
@@ -272,53 +639,133 @@ func (m *Message) writeParts(w io.Writer, boundary string, total int64) (int64,
 	return total, err
 }
 
-// writeBody ...
-func (m *Message) writeBody(w io.Writer, total int64) (int64, error) {
-	var written int
-	var err error
+// ErrUnknownTransferEncoding is returned by DecodedBody, along with the raw body, for a
+// Content-Transfer-Encoding that could not be decoded, such as the experimental
+// "x-uuencode" or "x-gzip64" tokens used by some legacy systems.
+var ErrUnknownTransferEncoding = errors.New("Message has an unknown Content-Transfer-Encoding")
+
+// DecodedBody returns the Body with its Content-Transfer-Encoding undone.
+// Parsing already decodes "quoted-printable" and "base64" bodies, so the Body is returned as is
+// unless its encoding is unrecognized, in which case the raw Body is returned with
+// ErrUnknownTransferEncoding, so that the caller may decode it.
+func (m *Message) DecodedBody() ([]byte, error) {
+	if len(m.Header.ContentTransferEncoding()) == 0 {
+		return m.Body, ErrUnknownTransferEncoding
+	}
+	return m.Body, nil
+}
 
-	// Encode if we have Content-Type, and we do not have Content-Transfer-Encoding set
-	if contentType := m.Header.Get("Content-Type"); len(contentType) > 0 && !m.Header.IsSet("Content-Transfer-Encoding") {
+// SetAutoTransferEncoding sets the Content-Transfer-Encoding of this message
+// to the one chosen for its Body by ChooseTransferEncoding, and returns it.
+func (m *Message) SetAutoTransferEncoding() string {
+	encoding := ChooseTransferEncoding(m.Body)
+	m.Header.Set("Content-Transfer-Encoding", encoding)
+	return encoding
+}
 
-		if strings.HasPrefix(contentType, "text") {
-			return m.writeText(w, total)
+// ChooseTransferEncoding returns the most suitable Content-Transfer-Encoding for the body:
+// "7bit" if it is ASCII without NUL bytes, nor lines longer than MaxHeaderTotalLength,
+// "quoted-printable" if no more than a sixth of it must be encoded (such as mostly ASCII
+// text with some accents), and "base64" otherwise (such as binary data).
+func ChooseTransferEncoding(body []byte) string {
+	toEncode := 0
+	lineLen := 0
+	longLines := false
+	for _, b := range body {
+		switch {
+		case b == '\n' || b == '\r':
+			lineLen = 0
+			continue
+		case b == 0 || b > '~' || (b < ' ' && b != '\t'):
+			toEncode++
+		}
+		if lineLen++; lineLen > MaxHeaderTotalLength {
+			longLines = true
 		}
-		return m.writeBase64(w, total)
 	}
-
-	written, err = io.WriteString(w, "\n")
-	total += int64(written)
-	if err != nil {
-		return total, err
+	switch {
+	case toEncode == 0 && !longLines:
+		return "7bit"
+	case toEncode*6 <= len(body):
+		return "quoted-printable"
 	}
-	written, err = w.Write(m.Body)
-	return total + int64(written), err
+	return "base64"
 }
 
-// writeText ...
-func (m *Message) writeText(w io.Writer, total int64) (int64, error) {
-	written, err := io.WriteString(w, "Content-Transfer-Encoding: quoted-printable\n\n")
-	total += int64(written)
-	if err != nil {
-		return total, err
+// bodyTransferEncoding returns the Content-Transfer-Encoding that the body is written with,
+// and whether it is implied because the header field is missing and must be written out.
+func (m *Message) bodyTransferEncoding() (string, bool) {
+	if encoding := m.Header.Get("Content-Transfer-Encoding"); len(encoding) > 0 {
+		return encoding, false
+	}
+	// Encode if we have Content-Type, and we do not have Content-Transfer-Encoding set
+	contentType := m.Header.Get("Content-Type")
+	if len(contentType) == 0 {
+		return "", false
 	}
-	// quotedprintable takes care of wrapping content at a good line length already
-	qpWriter := quotedprintable.NewWriter(w)
-	written, err = qpWriter.Write(m.Body)
-	qpWriter.Close() // Must remember to close the wrapper, as it needs to flush to underlying writer
-	return total + int64(written), err
+	if strings.HasPrefix(contentType, "text") {
+		return "quoted-printable", true
+	}
+	return "base64", true
 }
 
-// writeBase64 ...
-func (m *Message) writeBase64(w io.Writer, total int64) (int64, error) {
-	written, err := io.WriteString(w, "Content-Transfer-Encoding: base64\n\n")
+// writeBody ...
+func (m *Message) writeBody(w io.Writer, total int64, opts WriteOptions) (int64, error) {
+	var written int
+	var err error
+
+	encoding, implied := m.bodyTransferEncoding()
+	if implied {
+		written, err = io.WriteString(w, "Content-Transfer-Encoding: "+encoding+newline(opts.UseCRLF))
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	written, err = io.WriteString(w, newline(opts.UseCRLF))
 	total += int64(written)
 	if err != nil {
 		return total, err
 	}
-	// must wrap content at 76 characters
-	b64Writer := base64.NewEncoder(base64.StdEncoding, &base64Writer{w: w, maxLineLen: MaxBodyLineLength})
-	written, err = b64Writer.Write(m.Body)
-	b64Writer.Close() // Must remember to close the wrapper, as it needs to flush to underlying writer
-	return total + int64(written), err
+
+	body := m.Body
+	stream := len(body) == 0 && m.BodyReader != nil
+	// Base64 encodes the canonical form of text, which always has CRLF line endings
+	// (RFC 2045 6.8), rather than the line endings that are written.
+	isText := m.normalizesBody(encoding)
+	crlf := opts.UseCRLF || strings.EqualFold(encoding, "base64")
+	bodyReader := m.BodyReader
+	if isText && stream {
+		bodyReader = &lineEndingReader{r: bodyReader, crlf: crlf}
+	} else if isText {
+		body = normalizeLineEndings(body, crlf)
+	}
+	// Encoders report the bytes they were given, so the encoded bytes are counted as they are written
+	counter := &countingWriter{w: w}
+	var encoder io.WriteCloser
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		encoder = &quotedPrintableWriter{w: counter, maxLineLen: MaxBodyLineLength, crlf: opts.UseCRLF}
+	case "base64":
+		// must wrap content at 76 characters
+		encoder = &base64Writer{w: counter, maxLineLen: MaxBodyLineLength, crlf: opts.UseCRLF}
+	default:
+		if stream {
+			copied, err := io.Copy(w, bodyReader)
+			return total + copied, err
+		}
+		written, err = w.Write(body)
+		return total + int64(written), err
+	}
+	if stream {
+		_, err = io.Copy(encoder, bodyReader)
+	} else {
+		_, err = encoder.Write(body)
+	}
+	if closeErr := encoder.Close(); err == nil {
+		// Must remember to close the encoder, as it needs to flush to underlying writer
+		err = closeErr
+	}
+	return total + counter.n, err
 }
@@ -0,0 +1,502 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// TestWriteBodyTransferEncoding ...
+func TestWriteBodyTransferEncoding(t *testing.T) {
+	t.Parallel()
+
+	expectedText := "Mostly ASCII text, with an accent: café\nand a second line"
+
+	tests := map[string]string{
+		"":                 "Mostly ASCII text, with an accent: caf=C3=A9\nand a second line",
+		"quoted-printable": "Mostly ASCII text, with an accent: caf=C3=A9\nand a second line",
+		"base64":           "TW9zdGx5IEFTQ0lJIHRleHQsIHdpdGggYW4gYWNjZW50OiBjYWbDqQ0KYW5kIGEgc2Vjb25kIGxp\nbmU=",
+		"8bit":             expectedText,
+	}
+	for encoding, expectedBody := range tests {
+		part := NewPartText(expectedText)
+		if len(encoding) > 0 {
+			part.Header.Set("Content-Transfer-Encoding", encoding)
+		}
+		rawBytes, err := part.Bytes()
+		if err != nil {
+			t.Fatal("Could not write out part:", err)
+		}
+		expectedHeader := "Content-Transfer-Encoding: " + encoding + "\n"
+		if len(encoding) == 0 {
+			expectedHeader = "Content-Transfer-Encoding: quoted-printable\n"
+		}
+		rawParts := strings.SplitN(string(rawBytes), "\n\n", 2)
+		if len(rawParts) != 2 || !strings.Contains(rawParts[0]+"\n", expectedHeader) ||
+			strings.TrimSuffix(rawParts[1], "\n") != expectedBody {
+			t.Fatalf("Body not encoded as %q: %q", encoding, rawBytes)
+		}
+
+		// Base64 encodes the canonical form of the text, with CRLF line endings
+		parsedText := expectedText
+		if encoding == "base64" {
+			parsedText = strings.ReplaceAll(expectedText, "\n", "\r\n")
+		}
+		parsedPart, err := ParseMessage(bytes.NewReader(rawBytes))
+		if err != nil || string(parsedPart.Body) != parsedText {
+			t.Fatal("Could not parse in part:", err, string(parsedPart.Body))
+		}
+	}
+}
+
+// TestAllAddresses ...
+func TestAllAddresses(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("Test Name <test.from@host.com>", "Test Subject", "test.to@host.com")
+	header.SetCc("CC Name <test.cc@host.com>")
+	header.SetBcc("test.bcc@host.com")
+	header.Set("Reply-To", "replies@host.com")
+	text := "Contact support@help.host.com, or reply to Test.From@Host.com.\nThis is not an address: foo@bar"
+	html := "<p>Email <a href=\"mailto:sales@host.co.uk\">sales</a> or support@help.host.com</p>"
+	msg := NewMessage(header, text, html)
+
+	addresses, err := msg.AllAddresses()
+	if err != nil {
+		t.Fatal("Could not get addresses:", err)
+	}
+	expected := []string{"test.from@host.com", "replies@host.com", "test.to@host.com", "test.cc@host.com",
+		"test.bcc@host.com", "support@help.host.com", "sales@host.co.uk"}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Fatalf("Incorrect addresses: %q", addresses)
+	}
+
+	header.SetTo("not an address")
+	if _, err = msg.AllAddresses(); err == nil {
+		t.Fatal("Expected an error for a malformed address field")
+	}
+}
+
+// TestWriteToWithCRLF ...
+func TestWriteToWithCRLF(t *testing.T) {
+	t.Parallel()
+
+	mixed := "First line\nSecond line\r\nThird line\rFourth line\n\nLast line with accent: café"
+	plain := NewPartText(mixed)
+	plain.Header.Set("Content-Transfer-Encoding", "8bit")
+	msg := NewMessage(NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"), mixed, "<p>html</p>")
+	msg.Parts = append(msg.Parts, plain)
+
+	buffer := &bytes.Buffer{}
+	if _, err := msg.WriteToWith(buffer, WriteOptions{UseCRLF: true}); err != nil {
+		t.Fatal("Could not write message:", err)
+	}
+	raw := buffer.String()
+	if strings.Count(raw, "\n") != strings.Count(raw, "\r\n") || strings.Count(raw, "\r") != strings.Count(raw, "\r\n") {
+		t.Fatalf("Expected uniform CRLF line endings: %q", raw)
+	}
+	if !strings.Contains(raw, "\r\n\r\nFirst line\r\nSecond line\r\nThird line\r\nFourth line\r\n\r\nLast line with accent: café\r\n--") ||
+		!strings.Contains(raw, "\r\n\r\nFirst line\r\nSecond line\r\nThird line\r\nFourth line\r\n\r\nLast line with accent: caf=C3=A9\r\n--") {
+		t.Fatalf("Bodies not normalized to CRLF: %q", raw)
+	}
+
+	parsed, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	expected := "First line\r\nSecond line\r\nThird line\r\nFourth line\r\n\r\nLast line with accent: café"
+	if texts := parsed.MessagesContentTypePrefix("text/plain"); len(texts) != 2 ||
+		string(texts[0].Body) != expected || string(texts[1].Body) != expected {
+		t.Fatal("Incorrect parsed bodies:", len(texts))
+	}
+
+	// Without CRLF, bodies are normalized to LF
+	buffer.Reset()
+	if _, err = msg.WriteTo(buffer); err != nil || strings.Contains(buffer.String(), "\r") {
+		t.Fatalf("Expected uniform LF line endings: %q %v", buffer.String(), err)
+	}
+}
+
+// TestWriteToWithCRLFBase64 ...
+func TestWriteToWithCRLFBase64(t *testing.T) {
+	t.Parallel()
+
+	raw := make([]byte, 200)
+	for i := range raw {
+		raw[i] = byte(i)
+	}
+	msg := NewMessage(NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"), "text", "<p>html</p>",
+		NewPartAttachmentFromBytes(raw, "data.bin"))
+
+	buffer := &bytes.Buffer{}
+	if _, err := msg.WriteToWith(buffer, WriteOptions{UseCRLF: true}); err != nil {
+		t.Fatal("Could not write message:", err)
+	}
+	written := buffer.String()
+	if strings.Count(written, "\n") != strings.Count(written, "\r\n") {
+		t.Fatalf("Expected uniform CRLF line endings: %q", written)
+	}
+	encoded := base64.StdEncoding.EncodeToString(raw)
+	if !strings.Contains(written, "\r\n\r\n"+encoded[:76]+"\r\n"+encoded[76:152]+"\r\n"+encoded[152:228]+"\r\n"+encoded[228:]+"\r\n") {
+		t.Fatalf("Expected CRLF between base64 lines: %q", written)
+	}
+
+	parsed, err := ParseMessage(buffer)
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	if parts := parsed.MessagesContentTypePrefix("application/octet-stream"); len(parts) != 1 || !bytes.Equal(parts[0].Body, raw) {
+		t.Fatal("Base64 attachment did not round-trip")
+	}
+}
+
+// TestWriteTextLineEndings ...
+func TestWriteTextLineEndings(t *testing.T) {
+	t.Parallel()
+
+	// Base64 text is encoded in its canonical form, whatever the line endings written
+	for _, crlf := range []bool{false, true} {
+		part := NewPartText("a\nb\rc\r\n")
+		part.Header.Set("Content-Transfer-Encoding", "base64")
+		buffer := &bytes.Buffer{}
+		if _, err := part.WriteToWith(buffer, WriteOptions{UseCRLF: crlf}); err != nil {
+			t.Fatal("Could not write part:", err)
+		}
+		encoded := strings.TrimSpace(strings.SplitN(string(normalizeLineEndings(buffer.Bytes(), false)), "\n\n", 2)[1])
+		if decoded, err := base64.StdEncoding.DecodeString(encoded); err != nil || string(decoded) != "a\r\nb\r\nc\r\n" {
+			t.Fatalf("Expected base64 text with CRLF line endings, UseCRLF %v: %q %v", crlf, decoded, err)
+		}
+	}
+
+	// A part without a Content-Type is text
+	part := &Message{Header: Header{"Content-Transfer-Encoding": {"8bit"}}, Body: []byte("a\r\nb\rc\n")}
+	written, err := part.Bytes()
+	if err != nil || !strings.HasSuffix(string(written), "\n\na\nb\nc\n") {
+		t.Fatalf("Expected a body without a Content-Type to be normalized: %q %v", written, err)
+	}
+
+	// Streamed text is normalized too, including line endings split between reads
+	part = NewPartText("")
+	part.Body = nil
+	part.Header.Set("Content-Transfer-Encoding", "8bit")
+	part.BodyReader = iotest.OneByteReader(strings.NewReader("a\r\nb\rc\n"))
+	buffer := &bytes.Buffer{}
+	if _, err := part.WriteToWith(buffer, WriteOptions{UseCRLF: true}); err != nil ||
+		!strings.HasSuffix(buffer.String(), "\r\n\r\na\r\nb\r\nc\r\n") {
+		t.Fatalf("Expected a streamed body to be normalized: %q %v", buffer.String(), err)
+	}
+}
+
+// TestSize ...
+func TestSize(t *testing.T) {
+	t.Parallel()
+
+	large := make([]byte, 1024*1024+1)
+	for i := range large {
+		large[i] = byte(i * 31)
+	}
+	header := NewHeader("Frédéric <test.from@host.com>", "Test Subject with unicode 非常感谢你", "test.to@host.com")
+	msg := NewMessage(header, "Text with an accent: café", "<p>html</p>", NewPartAttachmentFromBytes(large, "large.bin"))
+	size, err := msg.Size()
+	if err != nil {
+		t.Fatal("Could not get size:", err)
+	}
+	raw, err := msg.Bytes()
+	if err != nil || size != int64(len(raw)) {
+		t.Fatal("Incorrect size:", size, len(raw), err)
+	}
+
+	// Streamed bodies of a known size are not read
+	content := bytes.Repeat([]byte("streamed"), 1000)
+	if err = msg.AttachStream("streamed.bin", "", int64(len(content)), bytes.NewReader(content)); err != nil {
+		t.Fatal("Could not attach stream:", err)
+	}
+	if size, err = msg.Size(); err != nil {
+		t.Fatal("Could not get size with a streamed body:", err)
+	}
+	if raw, err = msg.Bytes(); err != nil || size != int64(len(raw)) {
+		t.Fatal("Incorrect size with a streamed body:", size, len(raw), err)
+	}
+
+	if err = msg.AttachStream("unknown.bin", "", -1, bytes.NewReader(content)); err != nil {
+		t.Fatal("Could not attach stream:", err)
+	}
+	if _, err = msg.Size(); err != ErrUnknownSize {
+		t.Fatal("Expected an error for a streamed body of unknown size:", err)
+	}
+
+	// The written length of streamed text, or quoted-printable, depends on its content
+	msg.Parts = msg.Parts[:len(msg.Parts)-1]
+	if err = msg.AttachStream("notes.txt", "", int64(len(content)), bytes.NewReader(content)); err != nil {
+		t.Fatal("Could not attach stream:", err)
+	}
+	if _, err = msg.Size(); err != ErrUnknownSize {
+		t.Fatal("Expected an error for a streamed text body:", err)
+	}
+	msg.Parts[len(msg.Parts)-1].Header.Set("Content-Type", "application/octet-stream")
+	msg.Parts[len(msg.Parts)-1].Header.Set("Content-Transfer-Encoding", "quoted-printable")
+	if _, err = msg.Size(); err != ErrUnknownSize {
+		t.Fatal("Expected an error for a streamed quoted-printable body:", err)
+	}
+}
+
+// TestWriteToContext ...
+func TestWriteToContext(t *testing.T) {
+	t.Parallel()
+
+	msg := NewMessage(NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"), "text", "<p>html</p>",
+		NewPartAttachmentFromBytes(make([]byte, 100000), "large.bin"))
+
+	expected, err := msg.Bytes()
+	if err != nil {
+		t.Fatal("Could not write message:", err)
+	}
+	buffer := &bytes.Buffer{}
+	if _, err = msg.WriteToContext(context.Background(), buffer); err != nil || !bytes.Equal(buffer.Bytes(), expected) {
+		t.Fatal("Expected the same message without cancelling:", err)
+	}
+
+	// Cancel once the header has been written
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	buffer.Reset()
+	cancelling := writerFunc(func(p []byte) (int, error) {
+		written, err := buffer.Write(p)
+		if bytes.Contains(buffer.Bytes(), []byte("\n\n")) {
+			cancel()
+		}
+		return written, err
+	})
+	total, err := msg.WriteToContext(ctx, cancelling)
+	if err != context.Canceled {
+		t.Fatal("Expected the write to be cancelled:", err)
+	}
+	if total != int64(buffer.Len()) || buffer.Len() >= len(expected) || !bytes.HasPrefix(expected, buffer.Bytes()) {
+		t.Fatal("Expected only the header to be written:", total, buffer.Len())
+	}
+}
+
+// writerFunc ...
+type writerFunc func(p []byte) (int, error)
+
+// Write ...
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// TestWriteCanonical ...
+func TestWriteCanonical(t *testing.T) {
+	t.Parallel()
+
+	fixedDate := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	write := func() string {
+		header := NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+		if err := header.Save(); err != nil {
+			t.Fatal("Could not save header:", err)
+		}
+		msg := NewMessage(header, "text", "<p>html</p>", NewPartAttachmentFromBytes([]byte("raw"), "file.txt"))
+		buffer := &bytes.Buffer{}
+		if err := msg.WriteCanonical(buffer, "fixed@host.com", "fixed-boundary", fixedDate); err != nil {
+			t.Fatal("Could not write canonical message:", err)
+		}
+		if _, params, _ := msg.Header.ContentType(); params["boundary"] == "fixed-boundary" {
+			t.Fatal("Expected the message to not be changed")
+		}
+		return buffer.String()
+	}
+
+	first, second := write(), write()
+	if first != second {
+		t.Fatalf("Canonical messages differ:\n%s\n%s", first, second)
+	}
+	for _, expected := range []string{"\nMessage-Id: <fixed@host.com>\n", "\nDate: " + fixedDate.Format(time.RFC1123Z) + "\n",
+		"Content-Type: multipart/mixed; boundary=fixed-boundary\n", "Content-Type: multipart/alternative; boundary=fixed-boundary.1\n",
+		"\n--fixed-boundary\n", "\n--fixed-boundary.1--\n", "\n--fixed-boundary--\n"} {
+		if !strings.Contains(first, expected) {
+			t.Fatalf("Expected %q in canonical message:\n%s", expected, first)
+		}
+	}
+
+	if err := NewPartMultipart("mixed").WriteCanonical(&bytes.Buffer{}, "fixed@host.com", "not valid ", fixedDate); err == nil {
+		t.Fatal("Expected an error for an invalid boundary")
+	}
+}
+
+// TestChooseTransferEncoding ...
+func TestChooseTransferEncoding(t *testing.T) {
+	t.Parallel()
+
+	binary := make([]byte, 256)
+	for i := range binary {
+		binary[i] = byte(i)
+	}
+	tests := map[string]string{
+		"Plain ASCII text,\r\nwith\ttabs and line breaks.\n": "7bit",
+		"":                        "7bit",
+		strings.Repeat("x", 1000): "quoted-printable",
+		"Mostly ASCII text, with a few accents: café crème brûlée": "quoted-printable",
+		"非常感谢你":        "base64",
+		string(binary): "base64",
+	}
+	for body, expected := range tests {
+		part := &Message{Header: Header{"Content-Type": []string{"application/octet-stream"}}, Body: []byte(body)}
+		if encoding := part.SetAutoTransferEncoding(); encoding != expected || part.Header.Get("Content-Transfer-Encoding") != expected {
+			t.Fatalf("Expected %q for %q, got %q", expected, body, encoding)
+		}
+		raw, err := part.Bytes()
+		if err != nil {
+			t.Fatal("Could not write part:", err)
+		}
+		parsed, err := ParseMessage(bytes.NewReader(raw))
+		if err != nil || string(parsed.Body) != body {
+			t.Fatalf("Body did not round-trip as %q: %v", expected, err)
+		}
+	}
+}
+
+// TestDecodedBodyUnknownTransferEncoding ...
+func TestDecodedBodyUnknownTransferEncoding(t *testing.T) {
+	t.Parallel()
+
+	raw := "From: test.from@host.com\nContent-Type: application/octet-stream\nContent-Transfer-Encoding: x-uuencode\n\nbegin 644 test.txt\n#0V%T\n`\nend\n"
+	msg, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message with an x- transfer encoding:", err)
+	}
+	body, err := msg.DecodedBody()
+	if err != ErrUnknownTransferEncoding || string(body) != "begin 644 test.txt\n#0V%T\n`\nend\n" {
+		t.Fatalf("Expected the raw body with ErrUnknownTransferEncoding, got %q %v", body, err)
+	}
+
+	msg, err = ParseMessage(strings.NewReader("Content-Type: text/plain\nContent-Transfer-Encoding: base64\n\nSGVsbG8=\n"))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	if body, err = msg.DecodedBody(); err != nil || string(body) != "Hello" {
+		t.Fatalf("Expected the decoded body, got %q %v", body, err)
+	}
+}
+
+// TestComplianceCheck ...
+func TestComplianceCheck(t *testing.T) {
+	t.Parallel()
+
+	fields := func(problems []error) []string {
+		var names []string
+		for _, problem := range problems {
+			var fieldErr *HeaderFieldError
+			if !errors.As(problem, &fieldErr) {
+				t.Fatal("Expected a *HeaderFieldError:", problem)
+			}
+			names = append(names, fieldErr.Field)
+		}
+		return names
+	}
+
+	// Compliant once saved
+	msg := NewMessage(NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"), "text", "<p>html</p>")
+	if err := msg.Save(); err != nil {
+		t.Fatal("Could not save message:", err)
+	}
+	for _, level := range []ComplianceLevel{ComplianceRFC5322, ComplianceDeliverable} {
+		if problems := msg.ComplianceCheck(level); len(problems) != 0 {
+			t.Fatal("Expected a saved message to be compliant:", level, problems)
+		}
+	}
+
+	// The RFC 5322 minimum, without a Message-Id, recipient, or MIME-Version
+	minimal := &Message{Header: Header{}, Body: []byte("text")}
+	minimal.Header.SetFrom("test.from@host.com")
+	minimal.Header.Set("Date", "Mon, 02 Jan 2006 15:04:05 -0700")
+	problems := minimal.ComplianceCheck(ComplianceRFC5322)
+	if len(problems) != 1 || !errors.Is(problems[0], ErrMissingRecommendedField) || !reflect.DeepEqual(fields(problems), []string{"Message-Id"}) {
+		t.Fatal("Expected only the Message-Id to be recommended:", problems)
+	}
+	minimal.Header.Set("Content-Type", "text/plain")
+	problems = minimal.ComplianceCheck(ComplianceDeliverable)
+	if !reflect.DeepEqual(fields(problems), []string{"Message-Id", "To", "Mime-Version"}) || !errors.Is(problems[0], ErrHeadersMissingField) {
+		t.Fatal("Expected the Message-Id, recipient, and MIME-Version to be required:", problems)
+	}
+
+	// Non-compliant at every level
+	invalid := &Message{Header: Header{}, Body: []byte("text")}
+	invalid.Header.SetFrom("first@host.com, second@host.com")
+	invalid.Header.Set("Date", "yesterday")
+	invalid.Header.Add("Subject", "First")
+	invalid.Header.Add("Subject", "Second")
+	invalid.Header.SetMessageID("not-an-id")
+	invalid.Header.SetTo("not an address")
+	if problems = invalid.ComplianceCheck(ComplianceRFC5322); !reflect.DeepEqual(fields(problems), []string{"Sender", "Date", "Subject"}) ||
+		!errors.Is(problems[0], ErrMissingSender) {
+		t.Fatal("Incorrect RFC 5322 problems:", problems)
+	}
+	if problems = invalid.ComplianceCheck(ComplianceDeliverable); !reflect.DeepEqual(fields(problems), []string{"Sender", "Date", "Subject", "Message-Id", "To"}) {
+		t.Fatal("Incorrect deliverable problems:", problems)
+	}
+	if problems = (&Message{Header: Header{}}).ComplianceCheck(ComplianceRFC5322); !reflect.DeepEqual(fields(problems), []string{"From", "Date", "Message-Id"}) {
+		t.Fatal("Expected an empty header to fail:", problems)
+	}
+}
+
+// TestWriteToByteCount ...
+func TestWriteToByteCount(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("Zoë Doe <zoe@host.com>", "Café crème, "+strings.Repeat("with a long subject ", 10),
+		"First Recipient <first@host.com>", `"Doe, John" <john@host.com>`, FormatGroup("Team", "team@host.com"))
+	header.Set("X-Long", strings.Repeat("word ", 400))
+	header.Set("X-Ascii", "plain ascii")
+	header.SetBcc("hidden@host.com")
+
+	embedded := NewMessage(NewHeader("inner@host.com", "Inner", "outer@host.com"), "inner text", "")
+	messages := map[string]*Message{
+		"body":      {Header: header.Clone(), Body: []byte("plain body")},
+		"multipart": NewMessage(header.Clone(), "text with ünicode\n"+strings.Repeat("long line ", 50), "<p>html</p>", NewPartAttachmentFromBytes([]byte{0, 1, 2, 0xff}, "a.bin")),
+		"embedded":  NewMessage(header.Clone(), "text", "", NewPartMessage(embedded), NewPartDigest(embedded)),
+	}
+	messages["body"].Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	for _, opts := range []WriteOptions{{}, {UseCRLF: true}, {IncludeBcc: true, MaxLineLength: 40}, {AssertNoBcc: true, Charset: "ISO-8859-1"}, {SMTPUTF8: true}} {
+		buffer := &bytes.Buffer{}
+		n, err := header.WriteToWith(buffer, opts)
+		if err != nil || n != int64(buffer.Len()) {
+			t.Fatalf("Header count %d does not match the %d bytes written with %+v: %v", n, buffer.Len(), opts, err)
+		}
+		for name, msg := range messages {
+			buffer.Reset()
+			n, err = msg.WriteToWith(buffer, opts)
+			if err != nil || n != int64(buffer.Len()) {
+				t.Fatalf("%s count %d does not match the %d bytes written with %+v: %v", name, n, buffer.Len(), opts, err)
+			}
+		}
+	}
+
+	msg := NewMessage(header.Clone(), "text", "<p>html</p>")
+	if err := msg.AttachStream("data.bin", "", 5000, bytes.NewReader(make([]byte, 5000))); err != nil {
+		t.Fatal("Could not attach stream:", err)
+	}
+	raw := &bytes.Buffer{}
+	n, err := msg.WriteTo(raw)
+	if err != nil || n != int64(raw.Len()) {
+		t.Fatalf("Streamed count %d does not match the %d bytes written: %v", n, raw.Len(), err)
+	}
+
+	out, err := messages["multipart"].Bytes()
+	if err != nil {
+		t.Fatal("Could not write message:", err)
+	}
+	if n, err = messages["multipart"].WriteTo(ioutil.Discard); err != nil || n != int64(len(out)) {
+		t.Fatalf("WriteTo count %d does not match the %d bytes from Bytes: %v", n, len(out), err)
+	}
+}
@@ -0,0 +1,268 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"errors"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrPartNotFound ...
+var ErrPartNotFound = errors.New("Message has no part with the requested content type")
+
+// Preview returns a snippet of this message's text, such as for a list of messages,
+// that is at most maxLen characters long (or unlimited if maxLen is not positive).
+// The text comes from the first text/plain body, or if there is none, the first
+// text/html body with its tags removed. Quoted lines (starting with ">") and any
+// signature (following a "-- " line) are left out, and whitespace is collapsed.
+// An error of ErrPartNotFound is returned if there is no text body.
+func (m *Message) Preview(maxLen int) (string, error) {
+	var text string
+	if part := m.firstBody("text/plain"); part != nil {
+		text = string(part.Body)
+	} else if part = m.firstBody("text/html"); part != nil {
+		text = htmlToText(part.Body)
+	} else {
+		return "", ErrPartNotFound
+	}
+
+	lines := make([]string, 0, 1)
+	for _, line := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n") {
+		if line == "-- " || line == "--" {
+			break // The rest is a signature
+		}
+		if !strings.HasPrefix(strings.TrimSpace(line), ">") {
+			lines = append(lines, line)
+		}
+	}
+	preview := strings.Join(strings.Fields(strings.Join(lines, " ")), " ")
+
+	if maxLen > 0 && utf8.RuneCountInString(preview) > maxLen {
+		runes := 0
+		for idx := range preview {
+			if runes == maxLen {
+				preview = strings.TrimRight(preview[:idx], " ")
+				break
+			}
+			runes++
+		}
+	}
+	return preview, nil
+}
+
+// firstBody returns the first message of this content type, potentially
+// including this message and any contained within it, that is not an attachment.
+func (m *Message) firstBody(contentType string) *Message {
+	for _, part := range m.MessagesContentTypePrefix(contentType) {
+		if disposition, _, err := part.Header.ContentDisposition(); err == nil && disposition == "attachment" {
+			continue
+		}
+		if part.HasBody() {
+			return part
+		}
+	}
+	return nil
+}
+
+// PlainText returns the content of the first text/plain body of this message, descending into
+// multipart containers such as multipart/alternative, related, and mixed (but not attachments,
+// or encapsulated messages), with its Content-Transfer-Encoding undone and converted from its charset
+// to UTF-8 using CharsetDecoders. An error of ErrPartNotFound is returned if there is no text/plain body.
+func (m *Message) PlainText() ([]byte, error) {
+	return m.textContent("text/plain")
+}
+
+// HTML returns the content of the first text/html body of this message, found and decoded like
+// PlainText. An error of ErrPartNotFound is returned if there is no text/html body.
+func (m *Message) HTML() ([]byte, error) {
+	return m.textContent("text/html")
+}
+
+// textContent returns the content of the first body of this content type, decoded to UTF-8.
+func (m *Message) textContent(contentType string) ([]byte, error) {
+	part := m.firstContainedBody(contentType)
+	if part == nil {
+		return nil, ErrPartNotFound
+	}
+	body, err := part.DecodedBody()
+	if err != nil {
+		return nil, err
+	}
+	var charset string
+	if part.Header.IsSet("Content-Type") {
+		_, params, err := part.Header.ContentType()
+		if err != nil {
+			return nil, err
+		}
+		charset = params["charset"]
+	}
+	decoded, err := decodeCharset(body, charset)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(decoded), nil
+}
+
+// firstContainedBody returns the first message of this content type, potentially including this
+// message, that is not an attachment, descending only into the parts of multipart messages.
+// A message without a Content-Type is text/plain (RFC 2045 5.2).
+func (m *Message) firstContainedBody(contentType string) *Message {
+	if m.HasParts() {
+		for _, part := range m.Parts {
+			if found := part.firstContainedBody(contentType); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	mediaType := "text/plain"
+	if m.Header.IsSet("Content-Type") {
+		mediaType, _, _ = m.Header.ContentType()
+	}
+	if mediaType != contentType || !m.HasBody() {
+		return nil
+	}
+	if disposition, _, err := m.Header.ContentDisposition(); err == nil && disposition == "attachment" {
+		return nil
+	}
+	return m
+}
+
+var (
+	htmlHiddenPattern = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)\s*>`)
+	htmlBreakPattern  = regexp.MustCompile(`(?i)<(br|/p|/div|/tr|/li|/h[1-6])\b[^>]*>`)
+	htmlTagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// htmlToText returns a basic plain text version of the html, with tags removed,
+// line breaks at the end of paragraphs and other block elements, and entities decoded.
+func htmlToText(raw []byte) string {
+	text := htmlHiddenPattern.ReplaceAllString(string(raw), "")
+	text = htmlBreakPattern.ReplaceAllString(text, "\n")
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	return html.UnescapeString(text)
+}
+
+var (
+	htmlLinkPattern       = regexp.MustCompile(`(?is)<a\b[^>]*?\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))[^>]*>(.*?)</a\s*>`)
+	htmlWhitespacePattern = regexp.MustCompile(`\s+`)
+	blankLinesPattern     = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToPlainText returns a readable plain text version of the html, for a text/plain
+// alternative: like htmlToText, but with the html's own line breaks and indentation
+// collapsed, each link followed by its href in parentheses (unless that is its text),
+// every line trimmed, and no more than one blank line in a row.
+func htmlToPlainText(raw []byte) string {
+	text := htmlLinkPattern.ReplaceAllStringFunc(string(raw), func(link string) string {
+		match := htmlLinkPattern.FindStringSubmatch(link)
+		href := html.UnescapeString(strings.TrimSpace(match[1] + match[2] + match[3]))
+		label := match[4]
+		if strings.TrimSpace(htmlToText([]byte(label))) == href || len(href) == 0 || strings.HasPrefix(href, "#") {
+			return label
+		}
+		return label + " (" + html.EscapeString(href) + ")"
+	})
+	text = htmlWhitespacePattern.ReplaceAllString(text, " ")
+	text = htmlToText([]byte(text))
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// HasPlainTextAlternative returns true if the html of this message has a plain text
+// alternative: a text/plain part in the same multipart/alternative group, such as
+// one made by NewMessage or GeneratePlainFromHTML. HTML-only messages are discouraged
+// for accessibility, and are scored as spam by some filters. A message without any
+// html is not HTML-only, so it is true if it has a text/plain body.
+func (m *Message) HasPlainTextAlternative() bool {
+	if m.firstBody("text/html") == nil {
+		return m.firstBody("text/plain") != nil
+	}
+	for _, group := range m.MessagesContentTypePrefix("multipart/alternative") {
+		if len(group.PartsContentTypePrefix("text/plain")) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// AddPlainTextAlternative is the same as GeneratePlainFromHTML.
+func (m *Message) AddPlainTextAlternative() error {
+	return m.GeneratePlainFromHTML()
+}
+
+// GeneratePlainFromHTML adds a plain text alternative to an HTML-only message, with the text
+// derived from its first text/html body by htmlToPlainText, so the html (along with its
+// multipart/related inline parts, if any) becomes a multipart/alternative with the text before it.
+// Nothing is changed if HasPlainTextAlternative is already true.
+// An error of ErrPartNotFound is returned if there is no text/html body.
+func (m *Message) GeneratePlainFromHTML() error {
+	htmlPart := m.firstBody("text/html")
+	if htmlPart == nil {
+		return ErrPartNotFound
+	}
+	if m.HasPlainTextAlternative() {
+		return nil
+	}
+	m.addTextAlternative(htmlPart, htmlToPlainText(htmlPart.Body))
+	return nil
+}
+
+// addTextAlternative adds the plain text as an alternative to the html part of this message.
+func (m *Message) addTextAlternative(htmlPart *Message, textPlain string) {
+	text := NewPartText(textPlain)
+
+	target := htmlPart
+	if parent := m.parentOf(htmlPart); parent != nil {
+		if mediaType, _, _ := parent.Header.ContentType(); mediaType == "multipart/related" {
+			target = parent
+		}
+	}
+
+	// The target is changed in place, so that the message keeps any other fields in its header,
+	// in their order, with the multipart/alternative Content-Type where the html one was
+	content := &Message{Header: Header{}, Preamble: target.Preamble, Epilogue: target.Epilogue,
+		Parts: target.Parts, SubMessage: target.SubMessage, Body: target.Body, BodyReader: target.BodyReader,
+		MissingBoundary: target.MissingBoundary}
+	for key, values := range target.Header {
+		if strings.HasPrefix(key, "Content-") {
+			content.Header[key] = values
+			delete(target.Header, key)
+		}
+	}
+	var fieldOrder []string
+	for _, key := range target.FieldOrder {
+		if strings.HasPrefix(key, "Content-") {
+			content.FieldOrder = append(content.FieldOrder, key)
+			if key != "Content-Type" {
+				continue
+			}
+		}
+		fieldOrder = append(fieldOrder, key)
+	}
+	alternative := NewPartMultipart("alternative", text, content)
+	*target = Message{Header: target.Header, FieldOrder: fieldOrder, Parts: alternative.Parts}
+	target.Header.Set("Content-Type", alternative.Header.Get("Content-Type"))
+}
+
+// parentOf returns the multipart message, potentially including this message and any contained
+// within it, that has the part as one of its parts, or nil if there is none.
+func (m *Message) parentOf(part *Message) *Message {
+	for _, msg := range m.MessagesAll() {
+		for _, child := range msg.Parts {
+			if child == part {
+				return msg
+			}
+		}
+	}
+	return nil
+}
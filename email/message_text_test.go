@@ -0,0 +1,279 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestPreview ...
+func TestPreview(t *testing.T) {
+	t.Parallel()
+
+	text := "Hi Laura,\n\n  Thanks for   the update.\nOn Monday, John wrote:\n> Are we still meeting?\n> John\n" +
+		"See you at the café on Tuesday.\n\n-- \nCharlie\nSent from my phone"
+	msg := NewMessage(NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"), text, "<p>ignored</p>")
+
+	preview, err := msg.Preview(100)
+	if err != nil || preview != "Hi Laura, Thanks for the update. On Monday, John wrote: See you at the café on Tuesday." {
+		t.Fatalf("Incorrect preview: %q %v", preview, err)
+	}
+
+	// Truncated on a rune boundary
+	preview, err = msg.Preview(75)
+	if err != nil || preview != "Hi Laura, Thanks for the update. On Monday, John wrote: See you at the café" {
+		t.Fatalf("Incorrect truncated preview: %q %v", preview, err)
+	}
+	preview, err = msg.Preview(74)
+	if err != nil || preview != "Hi Laura, Thanks for the update. On Monday, John wrote: See you at the caf" {
+		t.Fatalf("Incorrect truncated preview: %q %v", preview, err)
+	}
+
+	html := "<html><head><title>Newsletter</title><style>p { color: blue; }</style></head>" +
+		"<body><h1>News &amp; Updates</h1><p>The first&nbsp;paragraph.<br>A new line.</p>" +
+		"<script>alert('hidden');</script><div>Café 非常感谢你</div></body></html>"
+	htmlOnly := &Message{Header: Header{"Content-Type": []string{"text/html; charset=\"UTF-8\""}}, Body: []byte(html)}
+	preview, err = htmlOnly.Preview(0)
+	if err != nil || preview != "News & Updates The first paragraph. A new line. Café 非常感谢你" {
+		t.Fatalf("Incorrect html preview: %q %v", preview, err)
+	}
+
+	if _, err = NewPartMultipart("mixed").Preview(100); err != ErrPartNotFound {
+		t.Fatal("Expected no text body to be found:", err)
+	}
+}
+
+// TestHasPlainTextAlternative ...
+func TestHasPlainTextAlternative(t *testing.T) {
+	t.Parallel()
+
+	header := func() Header { return NewHeader("test.from@host.com", "Test Subject", "test.to@host.com") }
+	if !NewMessage(header(), "text", "<p>html</p>").HasPlainTextAlternative() {
+		t.Fatal("Expected a text and html message to have a plain text alternative")
+	}
+	textOnly := &Message{Header: header(), Body: []byte("text")}
+	textOnly.Header.Set("Content-Type", "text/plain")
+	if !textOnly.HasPlainTextAlternative() {
+		t.Fatal("Expected a text only message to not be HTML-only")
+	}
+
+	htmlOnly := NewPartHTML("<html><head><title>Title</title></head><body><p>Hello &amp; welcome</p><p>Bye</p></body></html>")
+	for key, values := range header() {
+		htmlOnly.Header[key] = values
+	}
+	withAttachment := &Message{Header: header(), Parts: []*Message{
+		NewPartHTML("<p>Report attached</p>"), NewPartAttachmentFromBytes([]byte("pdf"), "report.pdf")}}
+	withAttachment.Header.Set("Content-Type", "multipart/mixed; boundary=\"mixed-boundary\"")
+	withInline := NewPartMultipart("mixed",
+		NewPartMultipart("related", NewPartHTML("<p>Logo <img src=\"cid:logo@host.com\"></p>"), NewPartInlineFromBytes([]byte("png"), "logo.png", "logo@host.com")),
+		NewPartAttachmentFromBytes([]byte("pdf"), "report.pdf"))
+	for key, values := range header() {
+		withInline.Header[key] = values
+	}
+
+	tests := []struct {
+		msg      *Message
+		text     string
+		expected []string
+	}{
+		{htmlOnly, "Hello & welcome\nBye", []string{"multipart/alternative", "text/plain", "text/html"}},
+		{withAttachment, "Report attached", []string{"multipart/mixed", "multipart/alternative", "text/plain", "text/html", "application/pdf"}},
+		{withInline, "Logo", []string{"multipart/mixed", "multipart/alternative", "text/plain", "multipart/related", "text/html", "image/png", "application/pdf"}},
+	}
+	for _, test := range tests {
+		if test.msg.HasPlainTextAlternative() {
+			t.Fatal("Expected an HTML-only message to not have a plain text alternative")
+		}
+		if err := test.msg.AddPlainTextAlternative(); err != nil || !test.msg.HasPlainTextAlternative() {
+			t.Fatal("Could not add a plain text alternative:", err)
+		}
+
+		raw, err := test.msg.Bytes()
+		if err != nil {
+			t.Fatal("Could not write message:", err)
+		}
+		parsed, err := ParseMessage(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal("Could not parse message:", err)
+		}
+		all := parsed.MessagesAll()
+		if len(all) != len(test.expected) {
+			t.Fatalf("Incorrect structure: %q", raw)
+		}
+		for i, part := range all {
+			if mediaType, _, _ := part.Header.ContentType(); mediaType != test.expected[i] {
+				t.Fatalf("Expected %q for part %d, got %q", test.expected[i], i, mediaType)
+			}
+		}
+		if parsed.Header.Subject() != "Test Subject" || !parsed.HasPlainTextAlternative() {
+			t.Fatalf("Incorrect header: %q", parsed.Header)
+		}
+		if text := string(parsed.firstBody("text/plain").Body); text != test.text {
+			t.Fatalf("Expected the text %q, got %q", test.text, text)
+		}
+	}
+
+	// Already has a plain text alternative
+	if err := htmlOnly.AddPlainTextAlternative(); err != nil || len(htmlOnly.Parts) != 2 || htmlOnly.Parts[1].HasParts() {
+		t.Fatal("Expected nothing to change:", err)
+	}
+
+	if err := textOnly.AddPlainTextAlternative(); err != ErrPartNotFound {
+		t.Fatal("Expected ErrPartNotFound without html:", err)
+	}
+}
+
+// TestGeneratePlainFromHTML ...
+func TestGeneratePlainFromHTML(t *testing.T) {
+	t.Parallel()
+
+	body := `<html>
+<head><style>p { color: red; }</style></head>
+<body>
+  <h1>Welcome,
+    Laura</h1>
+  <p>Thanks for signing up &amp; welcome to the <b>team</b>.<br>Read the
+    <a href="https://host.com/guide?a=1&amp;b=2">getting started guide</a>, or visit
+    <a href='https://host.com'>https://host.com</a>.</p>
+  <ul><li>First item</li><li>Second&nbsp;item</li></ul>
+  <p><a href="#top">Back to top</a></p>
+</body>
+</html>`
+	expected := "Welcome, Laura\n" +
+		"Thanks for signing up & welcome to the team.\n" +
+		"Read the getting started guide (https://host.com/guide?a=1&b=2), or visit https://host.com.\n" +
+		"First item\n" +
+		"Second item\n" +
+		"Back to top"
+
+	msg := NewPartHTML(body)
+	msg.Header.SetFrom("test.from@host.com")
+	if err := msg.GeneratePlainFromHTML(); err != nil {
+		t.Fatal("Could not generate the plain text:", err)
+	}
+	if mediaType, _, _ := msg.Header.ContentType(); mediaType != "multipart/alternative" || len(msg.Parts) != 2 {
+		t.Fatal("Expected the html to be wrapped in a multipart/alternative:", msg.Header)
+	}
+	if text := string(msg.Parts[0].Body); text != expected {
+		t.Fatalf("Expected %q, got %q", expected, text)
+	}
+	if string(msg.Parts[1].Body) != body || msg.Header.From() != "test.from@host.com" {
+		t.Fatal("Expected the html and other header fields to be kept")
+	}
+
+	// Already has a plain text alternative
+	if err := msg.GeneratePlainFromHTML(); err != nil || len(msg.Parts) != 2 {
+		t.Fatal("Expected nothing to change:", err)
+	}
+}
+
+// TestGeneratePlainFromHTMLFieldOrder ...
+func TestGeneratePlainFromHTMLFieldOrder(t *testing.T) {
+	t.Parallel()
+
+	raw := "Subject: Test Subject\n" +
+		"From: test.from@host.com\n" +
+		"Content-Type: text/html; charset=\"UTF-8\"\n" +
+		"To: test.to@host.com\n" +
+		"Content-Transfer-Encoding: 8bit\n" +
+		"Mime-Version: 1.0\n" +
+		"\n" +
+		"<p>html</p>\n"
+	msg, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	if err = msg.GeneratePlainFromHTML(); err != nil {
+		t.Fatal("Could not generate the plain text:", err)
+	}
+	written, err := msg.Bytes()
+	if err != nil {
+		t.Fatal("Could not write message:", err)
+	}
+
+	sections := strings.SplitN(string(written), "\n\n", 2)
+	last := -1
+	for _, field := range []string{"Subject:", "From:", "Content-Type: multipart/alternative", "To:", "Mime-Version:"} {
+		idx := strings.Index("\n"+sections[0], "\n"+field)
+		if idx <= last {
+			t.Fatalf("Expected %q in its parsed order: %q", field, written)
+		}
+		last = idx
+	}
+	if !strings.Contains(sections[1], "\nContent-Type: text/html; charset=\"UTF-8\"\nContent-Transfer-Encoding: 8bit\n\n<p>html</p>") {
+		t.Fatalf("Expected the html part to keep its field order: %q", written)
+	}
+}
+
+// TestPlainTextHTML ...
+func TestPlainTextHTML(t *testing.T) {
+	t.Parallel()
+
+	raw := "From: test.from@host.com\n" +
+		"To: test.to@host.com\n" +
+		"Subject: Test Subject\n" +
+		"Content-Type: multipart/mixed; boundary=\"mixed\"\n" +
+		"\n" +
+		"--mixed\n" +
+		"Content-Type: multipart/alternative; boundary=\"alternative\"\n" +
+		"\n" +
+		"--alternative\n" +
+		"Content-Type: text/plain; charset=\"iso-8859-1\"\n" +
+		"Content-Transfer-Encoding: quoted-printable\n" +
+		"\n" +
+		"See you at the caf=E9.\n" +
+		"--alternative\n" +
+		"Content-Type: multipart/related; boundary=\"related\"\n" +
+		"\n" +
+		"--related\n" +
+		"Content-Type: text/html; charset=\"UTF-8\"\n" +
+		"Content-Transfer-Encoding: base64\n" +
+		"\n" +
+		"PHA+U2VlIHlvdSBhdCB0aGUgY2Fmw6kuPC9wPg==\n" +
+		"--related\n" +
+		"Content-Type: image/png\n" +
+		"Content-ID: <logo>\n" +
+		"\n" +
+		"png\n" +
+		"--related--\n" +
+		"--alternative--\n" +
+		"--mixed\n" +
+		"Content-Type: message/rfc822\n" +
+		"\n" +
+		"Content-Type: text/html\n" +
+		"\n" +
+		"<p>Forwarded</p>\n" +
+		"--mixed--\n"
+	msg, err := ParseMessage(bytes.NewReader([]byte(raw)))
+	if err != nil {
+		t.Fatal("Unable to parse message:", err)
+	}
+
+	text, err := msg.PlainText()
+	if err != nil || string(text) != "See you at the café." {
+		t.Fatalf("Incorrect plain text: %q %v", text, err)
+	}
+	html, err := msg.HTML()
+	if err != nil || string(html) != "<p>See you at the café.</p>" {
+		t.Fatalf("Incorrect html: %q %v", html, err)
+	}
+
+	textOnly := &Message{Header: NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"), Body: []byte("Just text")}
+	if text, err = textOnly.PlainText(); err != nil || string(text) != "Just text" {
+		t.Fatalf("Incorrect plain text: %q %v", text, err)
+	}
+	if _, err = textOnly.HTML(); err != ErrPartNotFound {
+		t.Fatal("Expected ErrPartNotFound, got:", err)
+	}
+
+	attached := NewMessage(NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"), "Text", "<p>HTML</p>",
+		NewPartAttachmentFromBytes([]byte("<p>Attached</p>"), "page.html"))
+	attached.Parts = attached.Parts[1:]
+	if _, err = attached.HTML(); err != ErrPartNotFound {
+		t.Fatal("Expected ErrPartNotFound for an attachment, got:", err)
+	}
+}
@@ -8,14 +8,17 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
 	"mime/quotedprintable"
-	"net/mail"
+	"net/textproto"
+	"regexp"
 	"strings"
+	"time"
 )
 
 // ParseMessage parses and returns a Message from an io.Reader
@@ -24,17 +27,313 @@ import (
 // or bytes.NewReader() to create a reader.)
 // Any "quoted-printable" or "base64" encoded bodies will be decoded.
 func ParseMessage(r io.Reader) (*Message, error) {
-	msg, err := mail.ReadMessage(&leftTrimReader{r: bufioReader(r)})
+	return ParseMessageWith(r, ParseOptions{})
+}
+
+// ParseOptions controls how strictly messages are parsed.
+// The zero value is lenient, and is what ParseMessage uses.
+type ParseOptions struct {
+	// StrictQuotedPrintable causes any malformed quoted-printable body,
+	// such as one with an "=" not followed by two hex digits or a line break,
+	// to fail with ErrMalformedQuotedPrintable instead of being decoded leniently.
+	StrictQuotedPrintable bool
+
+	// MaxDepth is the maximum depth that multipart and encapsulated messages may be nested to,
+	// to guard against MIME bombs, beyond which parsing fails with ErrPartsTooDeep.
+	// The default if it is 0 is DefaultMaxPartDepth.
+	MaxDepth int
+
+	// MaxParts is the maximum total number of parts in all multiparts of a message, beyond which
+	// parsing fails with ErrTooManyParts. The default if it is 0 is DefaultMaxParts.
+	MaxParts int
+
+	// Strictness checks or repairs what is parsed against RFC 5322 and RFC 2045,
+	// as described for each Strictness. The default parses whatever can be parsed.
+	Strictness Strictness
+
+	depth int  // the depth of the multipart or message being parsed
+	parts *int // the number of parts parsed so far, shared by the whole message
+}
+
+const (
+	// DefaultMaxPartDepth is the default ParseOptions.MaxDepth.
+	DefaultMaxPartDepth = 32
+
+	// DefaultMaxParts is the default ParseOptions.MaxParts.
+	DefaultMaxParts = 10000
+)
+
+// ErrPartsTooDeep is returned when parsing a message with parts nested deeper than ParseOptions.MaxDepth.
+var ErrPartsTooDeep = errors.New("Message parts are nested too deeply")
+
+// ErrTooManyParts is returned when parsing a message with more parts than ParseOptions.MaxParts.
+var ErrTooManyParts = errors.New("Message has too many parts")
+
+// nested returns the ParseOptions for parsing the contents of a multipart or message,
+// one level deeper, or ErrPartsTooDeep.
+func (opts ParseOptions) nested() (ParseOptions, error) {
+	maxDepth := opts.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxPartDepth
+	}
+	if opts.depth++; opts.depth > maxDepth {
+		return opts, ErrPartsTooDeep
+	}
+	if opts.parts == nil {
+		opts.parts = new(int)
+	}
+	return opts, nil
+}
+
+// countPart counts another part towards the ParseOptions.MaxParts, or returns ErrTooManyParts.
+func (opts ParseOptions) countPart() error {
+	maxParts := opts.MaxParts
+	if maxParts <= 0 {
+		maxParts = DefaultMaxParts
+	}
+	if *opts.parts++; *opts.parts > maxParts {
+		return ErrTooManyParts
+	}
+	return nil
+}
+
+// ParseMessageWith parses and returns a Message like ParseMessage,
+// using the ParseOptions.
+func ParseMessageWith(r io.Reader, opts ParseOptions) (*Message, error) {
+	// Nested messages are read from the same reader, and only the whole message is checked
+	strict := opts.Strictness == StrictnessStrict && opts.depth == 0
+	if strict {
+		r = &strictLineReader{r: r}
+		opts.StrictQuotedPrintable = true
+	}
+	bufferedReader := bufioReader(NewTrimReader(r))
+	header, _, order, err := readHeader(bufferedReader)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := parseMessageWithHeader(header, bufferedReader, opts)
+	if err != nil {
+		return nil, err
+	}
+	msg.FieldOrder = order
+	if strict {
+		if err = msg.requiredFieldsError(); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// ReadMessage parses the header of a message from an io.Reader, and returns
+// a Message with a BodyReader over the remaining body, rather than reading the
+// whole payload into memory. Any leading whitespace before the header is skipped.
+// The BodyReader decodes the body if it is "quoted-printable" or "base64" encoded.
+func ReadMessage(r io.Reader) (*Message, error) {
+	bufferedReader := bufioReader(NewTrimReader(r))
+	header, _, order, err := readHeader(bufferedReader)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Header: header, FieldOrder: order, BodyReader: contentReader(header, bufferedReader, ParseOptions{})}, nil
+}
+
+// ReadParts parses the BodyReader of a message that was read with ReadMessage,
+// and has a Content-Type of "multipart", into its parts, using the boundary
+// parameter. Nested multiparts are parsed recursively. The parts, along with
+// any preamble and epilogue, are set on the message, replacing the BodyReader.
+// Any part without a Content-Type is given the default of "text/plain",
+// or "message/rfc822" within a "multipart/digest".
+func (m *Message) ReadParts() ([]*Message, error) {
+	mediaType, mediaTypeParams, err := m.Header.ContentType()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(mediaType, "multipart") || m.BodyReader == nil {
+		return nil, errors.New("Message does not have a multipart body to read")
+	}
+	opts, err := ParseOptions{}.nested()
 	if err != nil {
 		return nil, err
 	}
+	preamble, parts, epilogue, err := readMultipart(bufioReader(m.BodyReader), mediaType, mediaTypeParams["boundary"], opts)
+	if err == errMissingBoundary {
+		preamble, parts, m.MissingBoundary, err = nil, missingBoundaryParts(m.Header, preamble), true, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		for _, msg := range part.MessagesAll() {
+			if !msg.Header.IsSet("Content-Type") {
+				msg.Header.Set("Content-Type", "text/plain; charset=\"us-ascii\"")
+			}
+		}
+	}
+	m.Preamble, m.Parts, m.Epilogue, m.BodyReader = preamble, parts, epilogue, nil
+	return parts, nil
+}
+
+// ReadHeader parses and returns a Header from an io.Reader, reading up to and
+// including the blank line that separates the header from the body.
+// Fields that appear more than once keep all of their values, in order,
+// and any Q-encoded or B-encoded values will be decoded, except in the Content-Type
+// and Content-Disposition, which are kept exactly as written (other than unfolding),
+// rather than reconstructed, so that signatures covering them still verify.
+// If r is a *bufio.Reader, it is left positioned at the start of the body.
+// A leading mbox "From " separator line is skipped (use ReadMboxHeader to keep it).
+func ReadHeader(r io.Reader) (Header, error) {
+	header, _, err := ReadMboxHeader(r)
+	return header, err
+}
+
+// MboxFrom is the envelope from the "From " line that separates messages in an mbox file,
+// such as "From sender@host.com Mon Jan  2 15:04:05 2006".
+type MboxFrom struct {
+	// Sender is the envelope sender address.
+	Sender string
+
+	// Date is when the message was delivered, or the zero time if it could not be parsed.
+	Date time.Time
+}
+
+// ReadMboxHeader parses and returns a Header like ReadHeader, along with the envelope from
+// any leading mbox "From " separator line, which is empty if there is no such line.
+func ReadMboxHeader(r io.Reader) (Header, MboxFrom, error) {
+	header, envelope, _, err := readHeader(r)
+	return header, envelope, err
+}
+
+// readHeader parses and returns a Header like ReadMboxHeader, along with the canonical keys
+// of its fields in the order they were read, with a key for each value, for Message.FieldOrder.
+func readHeader(r io.Reader) (Header, MboxFrom, []string, error) {
+	var envelope MboxFrom
+	bufferedReader := bufioReader(r)
+	if prefix, _ := bufferedReader.Peek(5); string(prefix) == "From " {
+		line, err := bufferedReader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, envelope, nil, err
+		}
+		envelope = parseMboxFrom(line)
+	}
+	// The raw header is read first, as textproto does not keep the order of the fields
+	var raw []byte
+	for {
+		line, err := bufferedReader.ReadBytes('\n')
+		raw = append(raw, line...)
+		if err == io.EOF || len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break
+		}
+		if err != nil {
+			return nil, envelope, nil, err
+		}
+	}
+	mimeHeader, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw))).ReadMIMEHeader()
+	if err != nil && (err != io.EOF || len(mimeHeader) == 0) {
+		return nil, envelope, nil, err
+	}
+	order := headerFieldOrder(raw, mimeHeader)
 	// decode any Q-encoded values
-	for _, values := range msg.Header {
+	for key, values := range mimeHeader {
+		if key == "Content-Type" || key == "Content-Disposition" {
+			// Kept as written, so they are written out verbatim, as the parameters are decoded when parsed
+			continue
+		}
 		for idx, val := range values {
-			values[idx] = decodeRFC2047(val)
+			if classifyHeader(key) == addressListHeader && strings.Contains(val, "=?") {
+				values[idx] = decodeAddressList(val)
+			} else {
+				values[idx] = decodeRFC2047(val)
+			}
+		}
+	}
+	return Header(mimeHeader), envelope, order, nil
+}
+
+// headerFieldOrder returns the canonical keys of the fields in the raw header, in order,
+// with a key for each of their values in the parsed header.
+func headerFieldOrder(raw []byte, parsed textproto.MIMEHeader) []string {
+	order := make([]string, 0, len(parsed))
+	counts := make(map[string]int, len(parsed))
+	for _, line := range bytes.Split(raw, []byte("\n")) {
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 || line[0] == ' ' || line[0] == '\t' {
+			continue // a continuation line, or the end of the header
+		}
+		key := textproto.CanonicalMIMEHeaderKey(string(bytes.TrimRight(line[:colon], " \t")))
+		if counts[key] < len(parsed[key]) {
+			order = append(order, key)
+			counts[key]++
+		}
+	}
+	return order
+}
+
+// encodedWordsPattern matches a run of RFC 2047 encoded-words separated by whitespace.
+var encodedWordsPattern = regexp.MustCompile(`=\?[^?\s]+\?[bBqQ]\?[^?\s]*\?=(\s+=\?[^?\s]+\?[bBqQ]\?[^?\s]*\?=)*`)
+
+// decodeAddressList decodes any encoded-word display names in the address list, quoting
+// them where needed, such as "=?UTF-8?Q?Doe,_John?= <john@host.com>" to
+// "\"Doe, John\" <john@host.com>", so that the list is still valid when it is written out.
+func decodeAddressList(val string) string {
+	var quoted strings.Builder
+	last := 0
+	for _, match := range encodedWordsPattern.FindAllStringIndex(val, -1) {
+		decoded := decodeRFC2047(val[match[0]:match[1]])
+		quoted.WriteString(val[last:match[0]])
+		if inQuotes := strings.Count(strings.Replace(val[:match[0]], `\"`, "", -1), `"`)%2 == 1; inQuotes {
+			// Encoded-words are not allowed in quoted-strings, but are common there
+			decoded = strings.TrimSuffix(strings.TrimPrefix(quoteString(decoded), `"`), `"`)
+		} else {
+			decoded = quoteString(decoded)
+		}
+		quoted.WriteString(decoded)
+		last = match[1]
+	}
+	quoted.WriteString(val[last:])
+
+	groups, err := parseAddressGroups(quoted.String())
+	if err != nil || len(groups) == 0 {
+		return decodeRFC2047(val)
+	}
+	return formatAddressGroups(groups)
+}
+
+// parseMboxFrom parses the sender and the asctime-style date of an mbox "From " line.
+func parseMboxFrom(line string) MboxFrom {
+	fields := strings.Fields(strings.TrimPrefix(line, "From "))
+	if len(fields) == 0 {
+		return MboxFrom{}
+	}
+	envelope := MboxFrom{Sender: fields[0]}
+	date := strings.Join(fields[1:], " ")
+	for _, layout := range []string{"Mon Jan 2 15:04:05 2006", "Mon Jan 2 15:04:05 MST 2006", "Mon Jan 2 15:04:05 2006 -0700"} {
+		if parsed, err := time.Parse(layout, date); err == nil {
+			envelope.Date = parsed
+			break
+		}
+	}
+	return envelope
+}
+
+// ReadSubject reads only as much of the header from an io.Reader as is needed
+// to find the Subject, then returns it unfolded and decoded.
+// An error of ErrHeadersMissingField is returned if the header has no Subject.
+func ReadSubject(r io.Reader) (string, error) {
+	tp := textproto.NewReader(bufioReader(NewTrimReader(r)))
+	for {
+		line, err := tp.ReadContinuedLine()
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		if colon := strings.IndexByte(line, ':'); colon > 0 &&
+			textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(line[:colon])) == "Subject" {
+			return decodeRFC2047(strings.TrimSpace(line[colon+1:])), nil
+		}
+		if len(line) == 0 || err == io.EOF {
+			// Reached the end of the header
+			return "", ErrHeadersMissingField
 		}
 	}
-	return parseMessageWithHeader(Header(msg.Header), msg.Body)
 }
 
 // parseMessageWithHeader parses and returns a Message from an already filled
@@ -42,9 +341,9 @@ func ParseMessage(r io.Reader) (*Message, error) {
 // (If the raw body is a string or []byte, use strings.NewReader()
 // or bytes.NewReader() to create a reader.)
 // Any "quoted-printable" or "base64" encoded bodies will be decoded.
-func parseMessageWithHeader(headers Header, bodyReader io.Reader) (*Message, error) {
+func parseMessageWithHeader(headers Header, bodyReader io.Reader, opts ParseOptions) (*Message, error) {
 
-	bufferedReader := contentReader(headers, bodyReader)
+	bufferedReader := contentReader(headers, bodyReader, opts)
 
 	var err error
 	var mediaType string
@@ -57,24 +356,29 @@ func parseMessageWithHeader(headers Header, bodyReader io.Reader) (*Message, err
 
 	if contentType := headers.Get("Content-Type"); len(contentType) > 0 {
 		mediaType, mediaTypeParams, err = mime.ParseMediaType(contentType)
+		if err != nil && opts.Strictness == StrictnessLenient {
+			// A malformed Content-Type is treated as plain text (RFC 2045 5.2)
+			mediaType, mediaTypeParams, err = "text/plain", nil, nil
+		}
 		if err != nil {
 			return nil, err
 		}
 	} // Lack of contentType is not a problem
 
 	// Can only have one of the following: Parts, SubMessage, or Body
+	missingBoundary := false
 	if strings.HasPrefix(mediaType, "multipart") {
-		boundary := mediaTypeParams["boundary"]
-		preamble, err = readPreamble(bufferedReader, boundary)
-		if err == nil {
-			parts, err = readParts(bufferedReader, boundary)
-			if err == nil {
-				epilogue, err = readEpilogue(bufferedReader)
-			}
+		if opts, err = opts.nested(); err == nil {
+			preamble, parts, epilogue, err = readMultipart(bufferedReader, mediaType, mediaTypeParams["boundary"], opts)
+		}
+		if err == errMissingBoundary {
+			preamble, parts, missingBoundary, err = nil, missingBoundaryParts(headers, preamble), true, nil
 		}
 
 	} else if strings.HasPrefix(mediaType, "message") {
-		subMessage, err = ParseMessage(bufferedReader)
+		if opts, err = opts.nested(); err == nil {
+			subMessage, err = ParseMessageWith(bufferedReader, opts)
+		}
 
 	} else {
 		body, err = ioutil.ReadAll(bufferedReader)
@@ -84,35 +388,196 @@ func parseMessageWithHeader(headers Header, bodyReader io.Reader) (*Message, err
 	}
 
 	return &Message{
-		Header:     headers,
-		Preamble:   preamble,
-		Epilogue:   epilogue,
-		Body:       body,
-		SubMessage: subMessage,
-		Parts:      parts,
+		Header:          headers,
+		Preamble:        preamble,
+		Epilogue:        epilogue,
+		Body:            body,
+		SubMessage:      subMessage,
+		Parts:           parts,
+		MissingBoundary: missingBoundary,
 	}, nil
 }
 
-// readParts parses out the parts of a multipart body, including the preamble and epilogue.
-func readParts(bodyReader io.Reader, boundary string) ([]*Message, error) {
+// errMissingBoundary is returned by readMultipart when the body
+// has no boundary delimiters at all, along with the whole body as the preamble.
+var errMissingBoundary = errors.New("Multipart body has no boundary delimiters")
+
+// missingBoundaryParts returns the whole body of a corrupt multipart message, that has
+// no boundary delimiters, as a single part with the declared Content-Type.
+func missingBoundaryParts(headers Header, body []byte) []*Message {
+	if body == nil {
+		body = []byte{}
+	}
+	return []*Message{{Header: Header{"Content-Type": []string{headers.Get("Content-Type")}}, Body: body}}
+}
+
+// readMultipart parses out the preamble, parts, and epilogue of a multipart body,
+// of the multipart mediaType, such as "multipart/mixed".
+func readMultipart(r *bufio.Reader, mediaType string, boundary string, opts ParseOptions) ([]byte, []*Message, []byte, error) {
+	preamble, err := readPreamble(r, boundary)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if _, err = r.Peek(1); err == io.EOF {
+		return preamble, nil, nil, errMissingBoundary
+	}
+	defaultType := ""
+	if mediaType == "multipart/digest" {
+		// Parts of a digest are messages unless they say otherwise (RFC 2046 5.1.5)
+		defaultType = "message/rfc822"
+	}
+	parts, err := readParts(r, boundary, defaultType, opts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	epilogue, err := readEpilogue(r)
+	return preamble, parts, epilogue, err
+}
+
+// readParts parses out the parts of a multipart body, leaving the epilogue unread.
+// Any part without a Content-Type is given the defaultType, if it is not empty.
+func readParts(bodyReader *bufio.Reader, boundary string, defaultType string, opts ParseOptions) ([]*Message, error) {
 
 	parts := make([]*Message, 0, 1)
-	multipartReader := multipart.NewReader(bodyReader, boundary)
+	reader := &partsReader{r: bodyReader, delimiter: []byte("--" + boundary), closing: []byte("--" + boundary + "--"), lineStart: true}
+	multipartReader := multipart.NewReader(reader, boundary)
 
 	for part, partErr := multipartReader.NextPart(); partErr != io.EOF; part, partErr = multipartReader.NextPart() {
 		if partErr != nil && partErr != io.EOF {
 			return []*Message{}, partErr
 		}
-		newEmailPart, msgErr := parseMessageWithHeader(Header(part.Header), part)
+		if err := opts.countPart(); err != nil {
+			return []*Message{}, err
+		}
+		if len(defaultType) > 0 && !Header(part.Header).IsSet("Content-Type") {
+			Header(part.Header).Set("Content-Type", defaultType)
+		}
+		// The whole header of the part has been read, so the reader has seen its raw fields
+		var rawHeader []byte
+		if len(parts) < len(reader.headers) {
+			rawHeader = reader.headers[len(parts)]
+		}
+		order := headerFieldOrder(rawHeader, part.Header)
+		newEmailPart, msgErr := parseMessageWithHeader(Header(part.Header), part, opts)
 		part.Close()
 		if msgErr != nil {
 			return []*Message{}, msgErr
 		}
+		newEmailPart.FieldOrder = order
 		parts = append(parts, newEmailPart)
 	}
+	// Discard the remainder of the closing delimiter line
+	if _, err := bodyReader.ReadSlice('\n'); err != nil && err != io.EOF {
+		return []*Message{}, err
+	}
 	return parts, nil
 }
 
+// partsReader reads the parts of a multipart body, up to and including the
+// closing --boundary--, then EOF. The multipart.Reader buffers ahead of what
+// it has parsed, so without this the epilogue would be lost to its buffer.
+// It also keeps the raw header of each part, which the multipart.Reader does not,
+// for the order of their fields.
+type partsReader struct {
+	r         *bufio.Reader
+	delimiter []byte // the --boundary before each part
+	closing   []byte
+	lineStart bool // the next byte starts a new line
+	done      bool
+
+	line     []byte   // the current line, or as much of its start as is needed to tell if it is a delimiter
+	cut      bool     // the current line is longer than what is kept of it
+	inHeader bool     // the current line is in the header of a part
+	headers  [][]byte // the raw header of each part, in order
+}
+
+// Read ...
+func (r *partsReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	if len(p) > r.r.Size() {
+		p = p[:r.r.Size()]
+	}
+	peek, err := r.r.Peek(len(p))
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return 0, fmt.Errorf("Parts Read: %v", err)
+	}
+	if len(peek) == 0 {
+		return 0, io.EOF
+	}
+
+	toRead := len(peek)
+	if idx := r.closingIndex(peek); idx >= 0 {
+		r.done = true
+		toRead = idx + len(r.closing)
+	} else if err == nil {
+		// Couldn't find the closing boundary, so read all the bytes we can,
+		// but leave room for a closing boundary that got cut in half by the buffer
+		toRead = max(1, len(peek)-(len(r.closing)+1))
+	}
+	n, err := r.r.Read(p[:toRead])
+	if n > 0 {
+		r.lineStart = p[n-1] == '\n'
+		r.scanHeaders(p[:n])
+	}
+	return n, err
+}
+
+// maxDelimiterPadding is the most whitespace after a --boundary that is kept to recognize it.
+const maxDelimiterPadding = 80
+
+// scanHeaders keeps the raw header lines of each part in the bytes that were read, which follow
+// a delimiter line (the --boundary, and any whitespace) up to the blank line that ends them.
+func (r *partsReader) scanHeaders(read []byte) {
+	for len(read) > 0 {
+		chunk := read
+		end := bytes.IndexByte(read, '\n')
+		if end >= 0 {
+			chunk = read[:end+1]
+		}
+		read = read[len(chunk):]
+		if !r.inHeader {
+			// Only the start of a line outside of a header is kept
+			if room := len(r.delimiter) + maxDelimiterPadding - len(r.line); len(chunk) > room {
+				chunk, r.cut = chunk[:max(0, room)], true
+			}
+		}
+		r.line = append(r.line, chunk...)
+		if end < 0 {
+			continue
+		}
+		line := bytes.TrimRight(r.line, "\r\n")
+		switch {
+		case r.inHeader && len(line) == 0:
+			r.inHeader = false
+		case r.inHeader:
+			last := len(r.headers) - 1
+			r.headers[last] = append(r.headers[last], r.line...)
+		case !r.cut && bytes.HasPrefix(line, r.delimiter) && len(bytes.TrimSpace(line[len(r.delimiter):])) == 0:
+			r.inHeader = true
+			r.headers = append(r.headers, []byte{})
+		}
+		r.line, r.cut = r.line[:0], false
+	}
+}
+
+// closingIndex returns the index of the closing --boundary-- at the start of a line, or -1.
+func (r *partsReader) closingIndex(peek []byte) int {
+	for offset := 0; offset < len(peek); {
+		idx := bytes.Index(peek[offset:], r.closing)
+		if idx < 0 {
+			return -1
+		}
+		idx += offset
+		if (idx == 0 && r.lineStart) || (idx > 0 && peek[idx-1] == '\n') {
+			return idx
+		}
+		offset = idx + 1
+	}
+	return -1
+}
+
 // readEpilogue ...
 func readEpilogue(r io.Reader) ([]byte, error) {
 	epilogue, err := ioutil.ReadAll(r)
@@ -178,18 +643,116 @@ func (r *preambleReader) Read(p []byte) (int, error) {
 }
 
 // contentReader ...
-func contentReader(headers Header, bodyReader io.Reader) *bufio.Reader {
-	if headers.Get("Content-Transfer-Encoding") == "quoted-printable" {
+func contentReader(headers Header, bodyReader io.Reader, opts ParseOptions) *bufio.Reader {
+	if headers.ContentTransferEncoding() == "quoted-printable" {
 		headers.Del("Content-Transfer-Encoding")
+		if opts.StrictQuotedPrintable {
+			return bufioReader(newStrictQuotedPrintableReader(bodyReader))
+		}
 		return bufioReader(quotedprintable.NewReader(bodyReader))
 	}
-	if headers.Get("Content-Transfer-Encoding") == "base64" {
+	if headers.ContentTransferEncoding() == "base64" {
 		headers.Del("Content-Transfer-Encoding")
 		return bufioReader(base64.NewDecoder(base64.StdEncoding, bodyReader))
 	}
 	return bufioReader(bodyReader)
 }
 
+// ErrMalformedQuotedPrintable ...
+var ErrMalformedQuotedPrintable = errors.New("Malformed quoted-printable encoding")
+
+// strictQuotedPrintableReader decodes quoted-printable, failing with
+// ErrMalformedQuotedPrintable instead of decoding malformed input leniently.
+type strictQuotedPrintableReader struct {
+	decoder io.Reader
+	checker *quotedPrintableChecker
+}
+
+// newStrictQuotedPrintableReader ...
+func newStrictQuotedPrintableReader(r io.Reader) *strictQuotedPrintableReader {
+	checker := &quotedPrintableChecker{r: r}
+	return &strictQuotedPrintableReader{decoder: quotedprintable.NewReader(checker), checker: checker}
+}
+
+// Read ...
+func (r *strictQuotedPrintableReader) Read(p []byte) (int, error) {
+	n, err := r.decoder.Read(p)
+	if r.checker.err != nil {
+		// The decoder may have reported its own error for the malformed input
+		return n, r.checker.err
+	}
+	return n, err
+}
+
+// quotedPrintableChecker passes through quoted-printable encoded bytes unchanged,
+// but returns ErrMalformedQuotedPrintable on the first "=" that is neither followed
+// by two hex digits, nor a soft line break ending its line.
+type quotedPrintableChecker struct {
+	r     io.Reader
+	state int
+	err   error
+}
+
+// States of the quotedPrintableChecker
+const (
+	qpText      = iota // outside of any "=" sequence
+	qpEquals           // following an "="
+	qpHex              // following an "=" and one hex digit
+	qpSoftBreak        // following an "=" and whitespace, which must end the line
+)
+
+// Read ...
+func (r *quotedPrintableChecker) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.r.Read(p)
+	for _, b := range p[:n] {
+		switch r.state {
+		case qpEquals:
+			switch {
+			case isHexDigit(b):
+				r.state = qpHex
+			case b == ' ' || b == '\t' || b == '\r':
+				r.state = qpSoftBreak
+			case b == '\n':
+				r.state = qpText
+			default:
+				r.err = ErrMalformedQuotedPrintable
+				return n, r.err
+			}
+		case qpHex:
+			if !isHexDigit(b) {
+				r.err = ErrMalformedQuotedPrintable
+				return n, r.err
+			}
+			r.state = qpText
+		case qpSoftBreak:
+			if b == '\n' {
+				r.state = qpText
+			} else if b != ' ' && b != '\t' && b != '\r' {
+				r.err = ErrMalformedQuotedPrintable
+				return n, r.err
+			}
+		default:
+			if b == '=' {
+				r.state = qpEquals
+			}
+		}
+	}
+	if err == io.EOF && r.state != qpText {
+		// A trailing "=" must still be followed by a line break
+		r.err = ErrMalformedQuotedPrintable
+		return n, r.err
+	}
+	return n, err
+}
+
+// isHexDigit ...
+func isHexDigit(b byte) bool {
+	return '0' <= b && b <= '9' || 'A' <= b && b <= 'F' || 'a' <= b && b <= 'f'
+}
+
 // decodeRFC2047 ...
 func decodeRFC2047(s string) string {
 	// GO 1.5 does not decode headers, but this may change in future releases...
@@ -0,0 +1,540 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestReadHeader ...
+func TestReadHeader(t *testing.T) {
+	t.Parallel()
+
+	raw := "Received: from a.host.com by b.host.com;\r\n" +
+		"\tMon, 2 Jan 2006 15:04:05 -0700\r\n" +
+		"Received: from c.host.com by a.host.com;\r\n" +
+		" Mon, 2 Jan 2006 15:04:01 -0700\r\n" +
+		"Subject: =?UTF-8?B?6Z2e5bi45oSf6LCi5L2g?= and a\r\n" +
+		"  folded subject\r\n" +
+		"to: test.to@host.com\r\n" +
+		"\r\n" +
+		"The body.\r\n"
+
+	expected := Header{
+		"Received": []string{
+			"from a.host.com by b.host.com; Mon, 2 Jan 2006 15:04:05 -0700",
+			"from c.host.com by a.host.com; Mon, 2 Jan 2006 15:04:01 -0700"},
+		"Subject": []string{"非常感谢你 and a folded subject"},
+		"To":      []string{"test.to@host.com"},
+	}
+
+	reader := bufio.NewReader(strings.NewReader(raw))
+	header, err := ReadHeader(reader)
+	if err != nil {
+		t.Fatal("Could not read header:", err)
+	}
+	if !reflect.DeepEqual(header, expected) {
+		t.Fatalf("Header does not match expected: %q", header)
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	if err != nil || string(body) != "The body.\r\n" {
+		t.Fatalf("Reader not positioned at the body: %q %v", body, err)
+	}
+}
+
+// TestReadSubject ...
+func TestReadSubject(t *testing.T) {
+	t.Parallel()
+
+	raw := "From: test.from@host.com\n" +
+		"To: test.to@host.com\n" +
+		"Date: Mon, 2 Jan 2006 15:04:05 -0700\n" +
+		"Subject: =?UTF-8?Q?Caf=C3=A9?= meeting,\n" +
+		" rescheduled\n" +
+		"Message-Id: <1234.5678@host.com>\n" +
+		"\n" +
+		"Subject: this is the body, not the header\n"
+
+	subject, err := ReadSubject(strings.NewReader(raw))
+	if err != nil || subject != "Café meeting, rescheduled" {
+		t.Fatalf("Incorrect subject: %q %v", subject, err)
+	}
+
+	// Subject only appears after the end of the header
+	raw = "From: test.from@host.com\n\nSubject: this is the body, not the header\n"
+	if subject, err = ReadSubject(strings.NewReader(raw)); err != ErrHeadersMissingField {
+		t.Fatalf("Expected missing subject: %q %v", subject, err)
+	}
+}
+
+// TestReadMessage ...
+func TestReadMessage(t *testing.T) {
+	t.Parallel()
+
+	expectedBody := "This is a base64 encoded body, with some unicode: 非常感谢你\n"
+	raw := "\n\n  From: test.from@host.com\n" +
+		"Content-Type: text/plain; charset=\"UTF-8\"\n" +
+		"Content-Transfer-Encoding: base64\n" +
+		"\n" +
+		"VGhpcyBpcyBhIGJhc2U2NCBlbmNvZGVkIGJvZHksIHdpdGggc29tZSB1bmljb2RlOiDpnZ7luLjm\n" +
+		"hJ/osKLkvaAK\n"
+
+	msg, err := ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not read message:", err)
+	}
+	if msg.Header.From() != "test.from@host.com" || msg.Header.IsSet("Content-Transfer-Encoding") {
+		t.Fatalf("Incorrect header: %q", msg.Header)
+	}
+	if msg.Body != nil || msg.BodyReader == nil {
+		t.Fatal("Expected the body to be a reader")
+	}
+	body, err := ioutil.ReadAll(msg.BodyReader)
+	if err != nil || string(body) != expectedBody {
+		t.Fatalf("Body was not decoded: %q %v", body, err)
+	}
+}
+
+// TestReadParts ...
+func TestReadParts(t *testing.T) {
+	t.Parallel()
+
+	raw := "From: test.from@host.com\n" +
+		"Content-Type: multipart/mixed; boundary=\"outer\"\n" +
+		"\n" +
+		"This is the preamble.\n" +
+		"--outer\n" +
+		"Content-Type: multipart/alternative; boundary=\"inner\"\n" +
+		"\n" +
+		"--inner\n" +
+		"\n" +
+		"Plain text without a Content-Type.\n" +
+		"--inner\n" +
+		"Content-Type: text/html; charset=\"UTF-8\"\n" +
+		"Content-Transfer-Encoding: quoted-printable\n" +
+		"\n" +
+		"<p>caf=C3=A9</p>\n" +
+		"--inner--\n" +
+		"\n" +
+		"--outer\n" +
+		"Content-Type: application/octet-stream\n" +
+		"Content-Transfer-Encoding: base64\n" +
+		"\n" +
+		"AAECAw==\n" +
+		"--outer--\n" +
+		"This is the epilogue.\n"
+
+	msg, err := ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not read message:", err)
+	}
+	parts, err := msg.ReadParts()
+	if err != nil {
+		t.Fatal("Could not read parts:", err)
+	}
+	if !confirmHasParts(msg, 2, true, true) || msg.BodyReader != nil || !reflect.DeepEqual(parts, msg.Parts) ||
+		string(msg.Preamble) != "This is the preamble." || string(msg.Epilogue) != "This is the epilogue." {
+		t.Fatal("Message does not match expected structure")
+	}
+
+	alternative := parts[0]
+	if !confirmContentType(alternative, "Content-Type", "multipart/alternative", map[string]string{"boundary": ""}) ||
+		!confirmHasParts(alternative, 2, false, false) {
+		t.Fatal("Nested multipart does not match expected structure")
+	}
+	if !confirmContentType(alternative.Parts[0], "Content-Type", "text/plain", map[string]string{"charset": "us-ascii"}) ||
+		string(alternative.Parts[0].Body) != "Plain text without a Content-Type." {
+		t.Fatal("Part without a Content-Type does not default to text/plain")
+	}
+	if !confirmContentType(alternative.Parts[1], "Content-Type", "text/html", map[string]string{"charset": "UTF-8"}) ||
+		string(alternative.Parts[1].Body) != "<p>café</p>" {
+		t.Fatal("Nested part was not decoded")
+	}
+	if !reflect.DeepEqual(parts[1].Body, []byte{0, 1, 2, 3}) {
+		t.Fatal("Attachment was not decoded")
+	}
+
+	if _, err = NewPartText("not multipart").ReadParts(); err == nil {
+		t.Fatal("Expected an error for a message without a multipart body")
+	}
+}
+
+// TestParseStrictQuotedPrintable ...
+func TestParseStrictQuotedPrintable(t *testing.T) {
+	t.Parallel()
+
+	header := "Content-Type: text/plain; charset=\"UTF-8\"\nContent-Transfer-Encoding: quoted-printable\n\n"
+	strict := ParseOptions{StrictQuotedPrintable: true}
+
+	// Valid soft line breaks, with and without transport padding, are removed
+	valid := header + "A soft=\nbreak, a padded soft= \t\r\nbreak, and caf=C3=A9\n"
+	for _, opts := range []ParseOptions{{}, strict} {
+		msg, err := ParseMessageWith(strings.NewReader(valid), opts)
+		if err != nil || string(msg.Body) != "A softbreak, a padded softbreak, and café\n" {
+			t.Fatalf("Valid quoted-printable not decoded: %q %v", msg.Body, err)
+		}
+	}
+
+	for _, malformed := range []string{"A trailing equals sign=", "An invalid =ZZ octet\n", "Text after a soft break= text\n"} {
+		// Lenient by default
+		if _, err := ParseMessage(strings.NewReader(header + malformed)); err != nil {
+			t.Fatal("Expected malformed quoted-printable to be decoded leniently:", err)
+		}
+		if _, err := ParseMessageWith(strings.NewReader(header+malformed), strict); err != ErrMalformedQuotedPrintable {
+			t.Fatalf("Expected malformed quoted-printable to be reported for %q: %v", malformed, err)
+		}
+	}
+}
+
+// TestParseMissingBoundary ...
+func TestParseMissingBoundary(t *testing.T) {
+	t.Parallel()
+
+	body := "This body was meant to have parts,\nbut the --delimiters were lost.\n"
+	raw := "From: test.from@host.com\n" +
+		"Content-Type: multipart/alternative; boundary=\"lost\"\n" +
+		"\n" + body
+
+	msg, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	if !msg.MissingBoundary || len(msg.Parts) != 1 || msg.Preamble != nil ||
+		msg.Parts[0].Header.Get("Content-Type") != "multipart/alternative; boundary=\"lost\"" || string(msg.Parts[0].Body) != body {
+		t.Fatal("Expected the whole body as a single part:", msg.MissingBoundary, len(msg.Parts))
+	}
+
+	read, err := ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not read message:", err)
+	}
+	if parts, err := read.ReadParts(); err != nil || !read.MissingBoundary || len(parts) != 1 || string(parts[0].Body) != body {
+		t.Fatal("Expected the whole body as a single part when reading parts:", err)
+	}
+
+	// A body with boundaries is not a fallback
+	msg, err = ParseMessage(strings.NewReader(strings.Replace(raw, body, "--lost\n\ntext\n--lost--\n", 1)))
+	if err != nil || msg.MissingBoundary || len(msg.Parts) != 1 || string(msg.Parts[0].Body) != "text" {
+		t.Fatal("Expected a normal multipart:", err)
+	}
+}
+
+// TestReadMboxHeader ...
+func TestReadMboxHeader(t *testing.T) {
+	t.Parallel()
+
+	raw := "From sender@host.com  Mon Jan  2 15:04:05 2006\n" +
+		"From: Test Name <test.from@host.com>\n" +
+		"Subject: Test Subject\n" +
+		"\n" +
+		"The body.\n"
+
+	reader := bufio.NewReader(strings.NewReader(raw))
+	header, envelope, err := ReadMboxHeader(reader)
+	if err != nil || header.From() != "Test Name <test.from@host.com>" || header.Subject() != "Test Subject" || len(header) != 2 {
+		t.Fatalf("Incorrect header: %q %v", header, err)
+	}
+	if envelope.Sender != "sender@host.com" || !envelope.Date.Equal(time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)) {
+		t.Fatal("Incorrect envelope:", envelope)
+	}
+	if body, err := ioutil.ReadAll(reader); err != nil || string(body) != "The body.\n" {
+		t.Fatalf("Reader not positioned at the body: %q %v", body, err)
+	}
+
+	msg, err := ParseMessage(strings.NewReader("\n" + raw))
+	if err != nil || msg.Header.Subject() != "Test Subject" || string(msg.Body) != "The body.\n" {
+		t.Fatal("Could not parse an mbox-prefixed message:", err)
+	}
+
+	// The "From:" header field is not a separator
+	header, envelope, err = ReadMboxHeader(strings.NewReader(raw[strings.Index(raw, "\n")+1:]))
+	if err != nil || envelope != (MboxFrom{}) || header.From() != "Test Name <test.from@host.com>" {
+		t.Fatal("Expected no envelope:", envelope, err)
+	}
+}
+
+// TestParseMessagePartLimits ...
+func TestParseMessagePartLimits(t *testing.T) {
+	t.Parallel()
+
+	nested := NewPartText("innermost")
+	for i := 0; i < DefaultMaxPartDepth; i++ {
+		nested = NewPartMultipart("mixed", nested)
+	}
+	raw, err := nested.Bytes()
+	if err != nil {
+		t.Fatal("Could not write nested message:", err)
+	}
+	if _, err = ParseMessage(bytes.NewReader(raw)); err != nil {
+		t.Fatal("Expected nesting up to the default depth to parse:", err)
+	}
+
+	nested = NewPartMultipart("mixed", nested)
+	if raw, err = nested.Bytes(); err != nil {
+		t.Fatal("Could not write nested message:", err)
+	}
+	if _, err = ParseMessage(bytes.NewReader(raw)); err != ErrPartsTooDeep {
+		t.Fatal("Expected the default depth limit to be exceeded:", err)
+	}
+	if _, err = ParseMessageWith(bytes.NewReader(raw), ParseOptions{MaxDepth: 4}); err != ErrPartsTooDeep {
+		t.Fatal("Expected the depth limit to be exceeded:", err)
+	}
+	msg, err := ParseMessageWith(bytes.NewReader(raw), ParseOptions{MaxDepth: DefaultMaxPartDepth + 1})
+	if err != nil || len(msg.MessagesAll()) != DefaultMaxPartDepth+2 {
+		t.Fatal("Expected nesting within the depth limit to parse:", err)
+	}
+
+	// Encapsulated messages count towards the depth
+	encapsulated := []byte("Content-Type: message/rfc822\n\n" + "Content-Type: message/rfc822\n\n" + "Subject: innermost\n\nbody")
+	if _, err = ParseMessageWith(bytes.NewReader(encapsulated), ParseOptions{MaxDepth: 1}); err != ErrPartsTooDeep {
+		t.Fatal("Expected the depth limit to be exceeded by encapsulated messages:", err)
+	}
+
+	// Parts are counted across all multiparts
+	parts := []*Message{NewPartMultipart("alternative", NewPartText("text"), NewPartHTML("<p>html</p>"))}
+	for i := 0; i < 3; i++ {
+		parts = append(parts, NewPartAttachmentFromBytes([]byte("data"), "file.bin"))
+	}
+	if raw, err = NewPartMultipart("mixed", parts...).Bytes(); err != nil {
+		t.Fatal("Could not write message:", err)
+	}
+	if _, err = ParseMessageWith(bytes.NewReader(raw), ParseOptions{MaxParts: 6}); err != nil {
+		t.Fatal("Expected parts within the limit to parse:", err)
+	}
+	if _, err = ParseMessageWith(bytes.NewReader(raw), ParseOptions{MaxParts: 5}); err != ErrTooManyParts {
+		t.Fatal("Expected the part limit to be exceeded:", err)
+	}
+}
+
+// TestParseKeepsContentTypeVerbatim ...
+func TestParseKeepsContentTypeVerbatim(t *testing.T) {
+	t.Parallel()
+
+	contentType := "Content-Type: multipart/mixed ;  BOUNDARY = \"b-1\" ;charset=utf-8;x-extra=\"a;b\"\n"
+	partType := "Content-Type: application/PDF;name=\"=?UTF-8?B?UmVww7hydC5wZGY=?=\" ; x-mac-type=\"50444620\"\n"
+	disposition := "Content-Disposition: attachment ;filename=\"=?UTF-8?B?UmVww7hydC5wZGY=?=\";  size=3\n"
+	raw := contentType + "Subject: Test Subject\n\n--b-1\n" + partType + disposition + "Content-Transfer-Encoding: base64\n\ncGRm\n--b-1--\n"
+
+	msg, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	written, err := msg.Bytes()
+	if err != nil {
+		t.Fatal("Could not write message:", err)
+	}
+	for _, line := range []string{contentType, partType, disposition} {
+		if !strings.Contains(string(written), line) {
+			t.Fatalf("Expected %q to be written verbatim: %q", line, written)
+		}
+	}
+
+	// The parameters are still decoded
+	if _, params, err := msg.Header.ContentType(); err != nil || params["boundary"] != "b-1" {
+		t.Fatal("Incorrect Content-Type parameters:", params, err)
+	}
+	if _, params, err := msg.Parts[0].Header.ContentDisposition(); err != nil || params["filename"] != "Repørt.pdf" {
+		t.Fatal("Expected the encoded filename to be decoded:", params, err)
+	}
+}
+
+// TestHeaderRoundTrip ...
+func TestHeaderRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	raw := "Subject: =?UTF-8?B?6Z2e5bi45oSf6LCi5L2g?= and a\n" +
+		"  folded =?ISO-8859-1?Q?caf=E9?= subject\n" +
+		"To: =?UTF-8?Q?Doe,_John?= <john@host.com>, \"Smith, Jane\" <jane@host.com>,\n" +
+		" Plain Name <plain@host.com>, =?UTF-8?B?WsO2ZQ==?= <zoe@host.com>, bare@host.com\n" +
+		"Cc: \"=?UTF-8?B?WsO2ZQ==?=\" <zoe.cc@host.com>\n" +
+		"From: \"Quote \\\"Me\\\"\" <q@host.com>\n" +
+		"Received: from a.host.com by b.host.com; Mon, 02 Jan 2006 15:04:05 -0700\n" +
+		"Received: from b.host.com by c.host.com; Mon, 02 Jan 2006 15:04:06 -0700\n" +
+		"X-Long: " + strings.Repeat("folded words ", 100) + "\n" +
+		"\n" +
+		"body"
+	msg, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	written, err := msg.Bytes()
+	if err != nil {
+		t.Fatal("Could not write message:", err)
+	}
+	reparsed, err := ParseMessage(bytes.NewReader(written))
+	if err != nil {
+		t.Fatal("Could not parse the written message:", err)
+	}
+
+	// Byte equality with the original is not possible, as values are unfolded and re-encoded,
+	// so the same fields with the same decoded values are expected instead
+	if !reflect.DeepEqual(reparsed.Header, msg.Header) {
+		t.Fatalf("Header changed in the round trip:\n%q\n%q", msg.Header, reparsed.Header)
+	}
+	expected := map[string]string{
+		"Subject": "非常感谢你 and a folded café subject",
+		"To":      "\"Doe, John\" <john@host.com>, \"Smith, Jane\" <jane@host.com>, Plain Name <plain@host.com>, Zöe <zoe@host.com>, bare@host.com",
+		"Cc":      "Zöe <zoe.cc@host.com>",
+	}
+	for field, val := range expected {
+		if msg.Header.Get(field) != val {
+			t.Fatalf("Expected %s %q, got %q", field, val, msg.Header.Get(field))
+		}
+	}
+	if received := reparsed.Header.Received(); len(received) != 2 || received[0].From != "a.host.com" || received[1].From != "b.host.com" {
+		t.Fatal("Expected multi-valued fields to keep their order:", reparsed.Header["Received"])
+	}
+
+	// Display names survive as addresses, and not just as strings
+	addresses, err := reparsed.Header.AddressList("To")
+	if err != nil || len(addresses) != 5 || addresses[0].Name != "Doe, John" || addresses[3].Name != "Zöe" {
+		t.Fatal("Incorrect addresses after the round trip:", addresses, err)
+	}
+
+	// Once written, writing again is stable byte for byte
+	rewritten, err := reparsed.Bytes()
+	if err != nil || !bytes.Equal(rewritten, written) {
+		t.Fatalf("Expected the written message to be stable:\n%s\n%s", written, rewritten)
+	}
+}
+
+// TestParseKeepsFieldOrder ...
+func TestParseKeepsFieldOrder(t *testing.T) {
+	t.Parallel()
+
+	raw := "Received: from b.host.com by c.host.com\n" +
+		"X-Spam-Score: 1\n" +
+		"Received: from a.host.com by b.host.com\n" +
+		"Subject: Test Subject\n" +
+		"From: test.from@host.com\n" +
+		"To: test.to@host.com\n" +
+		"Date: Mon, 02 Jan 2006 15:04:05 +0000\n" +
+		"Content-Type: text/plain; charset=\"UTF-8\"\n" +
+		"Content-Transfer-Encoding: 7bit\n" +
+		"\n" +
+		"Body\n"
+	msg, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unable to parse message:", err)
+	}
+	expected := []string{"Received", "X-Spam-Score", "Received", "Subject", "From", "To", "Date", "Content-Type", "Content-Transfer-Encoding"}
+	if !reflect.DeepEqual(msg.FieldOrder, expected) {
+		t.Fatalf("Incorrect field order: %q", msg.FieldOrder)
+	}
+	written, err := msg.Bytes()
+	if err != nil || string(written) != raw {
+		t.Fatalf("Expected the header in its original order, got:\n%s\n%v", written, err)
+	}
+
+	// New trace fields stay with the others, and new fields go after the rest
+	msg.Header["Received"] = append([]string{"from c.host.com by d.host.com"}, msg.Header["Received"]...)
+	msg.Header.Set("X-Mailer", "Test")
+	msg.Header.Set("Subject", "Re: Test Subject")
+	msg.Header.Del("X-Spam-Score")
+	expectedRaw := "Received: from c.host.com by d.host.com\n" +
+		"Received: from b.host.com by c.host.com\n" +
+		"Received: from a.host.com by b.host.com\n" +
+		"Subject: Re: Test Subject\n" +
+		"From: test.from@host.com\n" +
+		"To: test.to@host.com\n" +
+		"Date: Mon, 02 Jan 2006 15:04:05 +0000\n" +
+		"Content-Type: text/plain; charset=\"UTF-8\"\n" +
+		"Content-Transfer-Encoding: 7bit\n" +
+		"X-Mailer: Test\n" +
+		"\n" +
+		"Body\n"
+	if written, err = msg.Bytes(); err != nil || string(written) != expectedRaw {
+		t.Fatalf("Expected:\n%s\ngot:\n%s\n%v", expectedRaw, written, err)
+	}
+
+	// An explicit FieldLess takes precedence
+	buffer := &bytes.Buffer{}
+	if _, err = msg.WriteToWith(buffer, WriteOptions{FieldLess: func(a, b string) bool { return a < b }}); err != nil ||
+		!strings.HasPrefix(buffer.String(), "Content-Transfer-Encoding: 7bit\nContent-Type: ") {
+		t.Fatalf("Expected the header sorted by FieldLess, got:\n%s\n%v", buffer.String(), err)
+	}
+
+	read, err := ReadMessage(strings.NewReader(raw))
+	if err != nil || !reflect.DeepEqual(read.FieldOrder, expected) {
+		t.Fatalf("Incorrect field order from ReadMessage: %q %v", read.FieldOrder, err)
+	}
+}
+
+// TestParseKeepsPartFieldOrder ...
+func TestParseKeepsPartFieldOrder(t *testing.T) {
+	t.Parallel()
+
+	raw := "Subject: Test Subject\n" +
+		"From: test.from@host.com\n" +
+		"Content-Type: multipart/mixed; boundary=\"b\"\n" +
+		"\n" +
+		"--b\n" +
+		"Content-Type: text/plain; charset=\"UTF-8\"\n" +
+		"X-Part: 1\n" +
+		"Content-Transfer-Encoding: 7bit\n" +
+		"\n" +
+		"Text\n" +
+		"--b\n" +
+		"Content-Type: multipart/alternative; boundary=\"b.1\"\n" +
+		"\n" +
+		"--b.1\n" +
+		"X-Part: 2\n" +
+		"Content-Type: text/html\n" +
+		"Content-Transfer-Encoding: 7bit\n" +
+		"\n" +
+		"<p>HTML</p>\n" +
+		"--b.1--\n" +
+		"--b\n" +
+		"\n" +
+		"No header\n" +
+		"--b--\n"
+	msg, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Unable to parse message:", err)
+	}
+	expected := [][]string{
+		{"Subject", "From", "Content-Type"},
+		{"Content-Type", "X-Part", "Content-Transfer-Encoding"},
+		{"Content-Type"},
+		{"X-Part", "Content-Type", "Content-Transfer-Encoding"},
+		{},
+	}
+	all := msg.MessagesAll()
+	if len(all) != len(expected) {
+		t.Fatal("Incorrect number of parts:", len(all))
+	}
+	for i, part := range all {
+		if !reflect.DeepEqual(part.FieldOrder, expected[i]) {
+			t.Fatalf("Incorrect field order of part %d: %q", i, part.FieldOrder)
+		}
+	}
+	if written, err := msg.Bytes(); err != nil || !strings.Contains(string(written), "X-Part: 2\nContent-Type: text/html\n") ||
+		!strings.Contains(string(written), "Content-Type: text/plain; charset=\"UTF-8\"\nX-Part: 1\n") {
+		t.Fatalf("Expected the part headers in their original order, got:\n%s\n%v", written, err)
+	}
+
+	// A constructed message is written in sorted order, which is what is parsed
+	constructed := NewMessage(NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"), "Text", "<p>HTML</p>")
+	written, err := constructed.Bytes()
+	if err != nil {
+		t.Fatal("Unable to write message:", err)
+	}
+	if msg, err = ParseMessage(bytes.NewReader(written)); err != nil {
+		t.Fatal("Unable to parse message:", err)
+	}
+	for _, part := range msg.MessagesAll() {
+		if !sort.StringsAreSorted(part.FieldOrder) || len(part.FieldOrder) == 0 {
+			t.Fatal("Message header was not written in sorted order:", part.FieldOrder)
+		}
+	}
+}
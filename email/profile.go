@@ -0,0 +1,213 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Profile describes the constraints of a sending service, such as an email provider,
+// that a message must meet to be accepted. The zero value has no constraints.
+type Profile struct {
+	// MaxSize is the maximum size in bytes of the written message, or unlimited if 0.
+	MaxSize int64
+
+	// TransferEncodings are the allowed Content-Transfer-Encoding values of the
+	// written bodies, such as "7bit", "quoted-printable", and "base64", or all if empty.
+	TransferEncodings []string
+
+	// RequiredFields are header fields that must be present, such as "Message-Id".
+	RequiredFields []string
+
+	// Bulk requires the List-Unsubscribe header field, as for newsletters and other bulk mail.
+	Bulk bool
+}
+
+// CheckProfile returns all of the ways in which this message, as it would be written
+// out, does not meet the constraints of the profile, or nil if it meets them all.
+func (m *Message) CheckProfile(p Profile) []error {
+	var errs []error
+
+	raw, err := m.Bytes()
+	if err != nil {
+		return append(errs, err)
+	}
+	if p.MaxSize > 0 && int64(len(raw)) > p.MaxSize {
+		errs = append(errs, fmt.Errorf("Message size of %d bytes exceeds the maximum of %d bytes", len(raw), p.MaxSize))
+	}
+
+	if len(p.TransferEncodings) > 0 {
+		for _, part := range m.MessagesAll() {
+			if !part.HasBody() {
+				continue
+			}
+			encoding, _ := part.bodyTransferEncoding()
+			if len(encoding) == 0 {
+				encoding = "7bit"
+			}
+			if len(knownValue(encoding, p.TransferEncodings)) == 0 {
+				errs = append(errs, &HeaderFieldError{Field: "Content-Transfer-Encoding",
+					Err: fmt.Errorf("%q is not allowed", strings.ToLower(encoding))})
+			}
+		}
+	}
+
+	required := p.RequiredFields
+	if p.Bulk {
+		required = append(required[:len(required):len(required)], "List-Unsubscribe")
+	}
+	for _, field := range required {
+		if !m.Header.IsSet(field) {
+			errs = append(errs, &HeaderFieldError{Field: field, Err: ErrHeadersMissingField})
+		}
+	}
+	return errs
+}
+
+// Strictness is how strictly the rules of RFC 5322 and RFC 2045 are enforced when a message
+// is parsed, with ParseOptions.Strictness, or written, with WriteOptions.Strictness.
+type Strictness int
+
+const (
+	// StrictnessDefault parses whatever can be parsed, and writes messages as they are.
+	StrictnessDefault Strictness = iota
+
+	// StrictnessStrict rejects what breaks the rules instead: lines longer than
+	// MaxHeaderTotalLength (998) with ErrMessageLineTooLong, line breaks that are a bare LF
+	// with ErrBareLF (so writing requires WriteOptions.UseCRLF), and a message missing a field
+	// required by RFC 5322 3.6, such as a From or a Date, with the first such problem that
+	// ComplianceCheck reports. Parsing also sets ParseOptions.StrictQuotedPrintable.
+	// Writing checks the whole message before anything is written, other than bodies that
+	// are streamed from a BodyReader.
+	StrictnessStrict
+
+	// StrictnessLenient repairs what breaks the rules instead. Parsing treats a malformed
+	// Content-Type as "text/plain" (RFC 2045 5.2). Writing uses CRLF line breaks, and writes
+	// "7bit" and "8bit" bodies with lines that are too long as "quoted-printable", without
+	// changing the Message. Repaired also adds a missing Date, Message-Id, and MIME-Version.
+	StrictnessLenient
+)
+
+// ErrBareLF is returned with StrictnessStrict for a line break that is a LF without a CR.
+var ErrBareLF = errors.New("Message line ends with a bare LF")
+
+// ErrMessageLineTooLong is returned with StrictnessStrict for a line longer than MaxHeaderTotalLength.
+var ErrMessageLineTooLong = errors.New("Message line too long")
+
+// strictLineReader reads from r, failing at the first line that ends with a bare LF,
+// or that is longer than MaxHeaderTotalLength.
+type strictLineReader struct {
+	r       io.Reader
+	lineLen int
+	afterCR bool
+}
+
+// Read ...
+func (s *strictLineReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	for i, b := range p[:n] {
+		switch {
+		case b == '\n' && !s.afterCR:
+			return i, ErrBareLF
+		case b == '\n':
+			s.lineLen = 0
+		case b != '\r':
+			if s.lineLen++; s.lineLen > MaxHeaderTotalLength {
+				return i, ErrMessageLineTooLong
+			}
+		}
+		s.afterCR = b == '\r'
+	}
+	return n, err
+}
+
+// hasLongLine returns true if the body has a line longer than MaxHeaderTotalLength.
+func hasLongLine(body []byte) bool {
+	for len(body) > 0 {
+		line := body
+		if end := bytes.IndexByte(body, '\n'); end >= 0 {
+			line, body = body[:end], body[end+1:]
+		} else {
+			body = nil
+		}
+		if len(bytes.TrimSuffix(line, []byte("\r"))) > MaxHeaderTotalLength {
+			return true
+		}
+	}
+	return false
+}
+
+// strictBodyError returns ErrMessageLineTooLong or ErrBareLF for the first body in this message
+// that is written out as it is, rather than encoded, and has a line that breaks the rules.
+// Text bodies have their line breaks normalized when they are written, and "binary" bodies may
+// have any lines. Bodies that are streamed from a BodyReader are not checked.
+func (m *Message) strictBodyError() error {
+	for _, part := range m.MessagesAll() {
+		encoding, _ := part.bodyTransferEncoding()
+		switch strings.ToLower(encoding) {
+		case "quoted-printable", "base64", "binary":
+			continue
+		}
+		if hasLongLine(part.Body) {
+			return ErrMessageLineTooLong
+		}
+		isText := strings.HasPrefix(strings.ToLower(part.Header.Get("Content-Type")), "text")
+		if !isText && bytes.Count(part.Body, []byte("\n")) != bytes.Count(part.Body, []byte("\r\n")) {
+			return ErrBareLF
+		}
+	}
+	return nil
+}
+
+// requiredFieldsError returns the first problem that ComplianceCheck reports with the
+// fields required by RFC 5322, or nil if there is none.
+func (m *Message) requiredFieldsError() error {
+	for _, problem := range m.ComplianceCheck(ComplianceRFC5322) {
+		if !errors.Is(problem, ErrMissingRecommendedField) {
+			return problem
+		}
+	}
+	return nil
+}
+
+// Repaired returns a copy of this message, and the parts within it, as StrictnessLenient writes it,
+// along with any missing Date, Message-Id, and MIME-Version added like Save, so that it may be
+// written out more than once, such as for Size and then Send, with the same fields each time.
+// An error is returned if the Message-Id can not be created. This message is not changed.
+func (m *Message) Repaired() (*Message, error) {
+	repaired := m.repairedCopy()
+	if err := repaired.Header.Save(); err != nil {
+		return nil, err
+	}
+	return repaired, nil
+}
+
+// repairedCopy returns a copy of this message, and the parts within it, with "7bit" and "8bit"
+// bodies that have lines that are too long changed to "quoted-printable".
+func (m *Message) repairedCopy() *Message {
+	repaired := *m
+	repaired.Header = m.Header.Clone()
+	if repaired.Header == nil {
+		repaired.Header = Header{}
+	}
+	switch strings.ToLower(m.Header.Get("Content-Transfer-Encoding")) {
+	case "7bit", "8bit":
+		if hasLongLine(m.Body) {
+			repaired.Header.Set("Content-Transfer-Encoding", "quoted-printable")
+		}
+	}
+	if m.SubMessage != nil {
+		repaired.SubMessage = m.SubMessage.repairedCopy()
+	}
+	repaired.Parts = make([]*Message, len(m.Parts))
+	for i, part := range m.Parts {
+		repaired.Parts[i] = part.repairedCopy()
+	}
+	return &repaired
+}
@@ -0,0 +1,151 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestCheckProfile ...
+func TestCheckProfile(t *testing.T) {
+	t.Parallel()
+
+	// A transactional API that rejects large messages and 8bit bodies
+	transactional := Profile{
+		MaxSize:           10 * 1024,
+		TransferEncodings: []string{"7bit", "quoted-printable", "base64"},
+		RequiredFields:    []string{"Date", "Message-Id"},
+	}
+	// A bulk service that requires an unsubscribe link
+	bulk := Profile{MaxSize: 1024 * 1024, Bulk: true}
+
+	header := NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	msg := NewMessage(header, "text", "<p>html</p>", NewPartAttachmentFromBytes([]byte("raw"), "file.txt"))
+	if errs := msg.CheckProfile(transactional); len(errs) != 2 {
+		t.Fatal("Expected the missing Date and Message-Id:", errs)
+	}
+	if err := msg.Save(); err != nil {
+		t.Fatal("Could not save message:", err)
+	}
+	if errs := msg.CheckProfile(transactional); len(errs) != 0 {
+		t.Fatal("Expected the message to meet the profile:", errs)
+	}
+	if errs := msg.CheckProfile(Profile{}); len(errs) != 0 {
+		t.Fatal("Expected no constraints:", errs)
+	}
+
+	msg.Parts[0].Parts[0].Header.Set("Content-Transfer-Encoding", "8bit")
+	msg.Parts = append(msg.Parts, NewPartAttachmentFromBytes(make([]byte, 10*1024), "large.bin"))
+	errs := msg.CheckProfile(transactional)
+	var fieldErr *HeaderFieldError
+	if len(errs) != 2 || !errors.As(errs[1], &fieldErr) || fieldErr.Field != "Content-Transfer-Encoding" {
+		t.Fatal("Expected the size and 8bit body to be rejected:", errs)
+	}
+
+	errs = msg.CheckProfile(bulk)
+	if len(errs) != 1 || !errors.As(errs[0], &fieldErr) || fieldErr.Field != "List-Unsubscribe" ||
+		!errors.Is(errs[0], ErrHeadersMissingField) {
+		t.Fatal("Expected the missing List-Unsubscribe:", errs)
+	}
+	msg.Header.SetListUnsubscribe("mailto:unsubscribe@host.com")
+	if errs = msg.CheckProfile(bulk); len(errs) != 0 {
+		t.Fatal("Expected the message to meet the bulk profile:", errs)
+	}
+}
+
+// TestStrictness ...
+func TestStrictness(t *testing.T) {
+	t.Parallel()
+
+	valid := "From: test.from@host.com\r\nDate: Mon, 02 Jan 2006 15:04:05 -0700\r\nContent-Type: text/plain\r\n\r\nHello\r\n"
+	strict := ParseOptions{Strictness: StrictnessStrict}
+	if msg, err := ParseMessageWith(strings.NewReader(valid), strict); err != nil || string(msg.Body) != "Hello\r\n" {
+		t.Fatal("Unable to parse a valid message strictly:", err)
+	}
+	if _, err := ParseMessageWith(strings.NewReader(strings.Replace(valid, "\r\n", "\n", -1)), strict); !errors.Is(err, ErrBareLF) {
+		t.Fatal("Expected ErrBareLF parsing strictly, got:", err)
+	}
+	if _, err := ParseMessageWith(strings.NewReader(valid+strings.Repeat("a", 999)+"\r\n"), strict); !errors.Is(err, ErrMessageLineTooLong) {
+		t.Fatal("Expected ErrMessageLineTooLong parsing strictly, got:", err)
+	}
+	if _, err := ParseMessageWith(strings.NewReader(strings.Replace(valid, "Date", "X-Date", 1)), strict); !errors.Is(err, ErrHeadersMissingField) {
+		t.Fatal("Expected ErrHeadersMissingField parsing strictly, got:", err)
+	}
+	if _, err := ParseMessage(strings.NewReader(strings.Replace(valid, "\r\n", "\n", -1))); err != nil {
+		t.Fatal("Unable to parse a message with bare LFs by default:", err)
+	}
+
+	malformed := strings.Replace(valid, "text/plain", "text/plain; charset", 1)
+	if _, err := ParseMessage(strings.NewReader(malformed)); err == nil {
+		t.Fatal("Expected an error parsing a malformed Content-Type by default")
+	}
+	msg, err := ParseMessageWith(strings.NewReader(malformed), ParseOptions{Strictness: StrictnessLenient})
+	if err != nil || string(msg.Body) != "Hello\r\n" {
+		t.Fatal("Unable to parse a malformed Content-Type leniently:", err)
+	}
+
+	long := strings.Repeat("a", 1000)
+	msg = &Message{Header: Header{"Content-Type": []string{"text/plain"}, "Content-Transfer-Encoding": []string{"7bit"}},
+		Body: []byte("Hello\n" + long + "\n")}
+	msg.Header.SetTo("test.to@host.com")
+	if _, err = msg.WriteToWith(&bytes.Buffer{}, WriteOptions{Strictness: StrictnessStrict, UseCRLF: true}); !errors.Is(err, ErrHeadersMissingField) {
+		t.Fatal("Expected ErrHeadersMissingField writing strictly, got:", err)
+	}
+	msg.Header.SetFrom("test.from@host.com")
+	msg.Header.Set("Date", "Mon, 02 Jan 2006 15:04:05 -0700")
+	if _, err = msg.WriteToWith(&bytes.Buffer{}, WriteOptions{Strictness: StrictnessStrict}); err != ErrBareLF {
+		t.Fatal("Expected ErrBareLF writing strictly without CRLF, got:", err)
+	}
+	buffer := &bytes.Buffer{}
+	if _, err = msg.WriteToWith(buffer, WriteOptions{Strictness: StrictnessStrict, UseCRLF: true}); err != ErrMessageLineTooLong || buffer.Len() > 0 {
+		t.Fatal("Expected ErrMessageLineTooLong writing strictly, before anything is written, got:", err, buffer.Len())
+	}
+	multipart := NewPartMultipart("mixed", NewPartText("text"),
+		&Message{Header: Header{"Content-Type": []string{"application/octet-stream"}, "Content-Transfer-Encoding": []string{"8bit"}}, Body: []byte("a\nb")})
+	for key, values := range msg.Header {
+		if !strings.HasPrefix(key, "Content-") {
+			multipart.Header[key] = values
+		}
+	}
+	if _, err = multipart.WriteToWith(buffer, WriteOptions{Strictness: StrictnessStrict, UseCRLF: true}); err != ErrBareLF || buffer.Len() > 0 {
+		t.Fatal("Expected ErrBareLF writing an 8bit part strictly, before anything is written, got:", err, buffer.Len())
+	}
+
+	if _, err = msg.WriteToWith(buffer, WriteOptions{Strictness: StrictnessLenient}); err != nil {
+		t.Fatal("Unable to write leniently:", err)
+	}
+	if msg.Header.IsSet("Message-Id") || msg.Header.Get("Content-Transfer-Encoding") != "7bit" {
+		t.Fatal("Writing leniently should not change the message:", msg.Header)
+	}
+	again := &bytes.Buffer{}
+	if _, err = msg.WriteToWith(again, WriteOptions{Strictness: StrictnessLenient}); err != nil || again.String() != buffer.String() ||
+		strings.Contains(buffer.String(), "Message-Id") {
+		t.Fatalf("Expected the same message written leniently twice, without a Message-Id:\n%s", again.Bytes())
+	}
+
+	saved, err := msg.Repaired()
+	if err != nil || msg.Header.IsSet("Message-Id") {
+		t.Fatal("Unable to repair the message without changing it:", err)
+	}
+	buffer.Reset()
+	again.Reset()
+	if _, err = saved.WriteToWith(buffer, WriteOptions{Strictness: StrictnessLenient}); err != nil {
+		t.Fatal("Unable to write leniently:", err)
+	}
+	if _, err = saved.WriteToWith(again, WriteOptions{Strictness: StrictnessLenient}); err != nil || again.String() != buffer.String() {
+		t.Fatalf("Expected the same repaired message written twice:\n%s", again.Bytes())
+	}
+	repaired, err := ParseMessageWith(bytes.NewReader(buffer.Bytes()), strict)
+	if err != nil {
+		t.Fatalf("Unable to parse a leniently written message strictly: %v\n%s", err, buffer.Bytes())
+	}
+	if !bytes.Contains(buffer.Bytes(), []byte("Content-Transfer-Encoding: quoted-printable\r\n")) || len(repaired.Header.MessageID()) == 0 ||
+		repaired.Header.Get("Mime-Version") != "1.0" || string(repaired.Body) != "Hello\r\n"+long+"\r\n" {
+		t.Fatalf("Incorrect message written leniently:\n%s", buffer.Bytes())
+	}
+}
@@ -5,51 +5,72 @@
 package email
 
 import (
+	"bytes"
 	"errors"
-	"net/mail"
 	"net/smtp"
+	"strings"
 )
 
 // Send this email using the SMTP Address:Port, and optionally any SMTP Auth.
-// Send will call Save() on the message before sending.
+// The envelope is from SMTPEnvelope, so it is sent from the Return-Path, Sender,
+// or From, and to every recipient in the To, Cc, and Bcc (which is never written out).
+// Send will call Save() on the message before sending, and fails with ErrBccWritten,
+// without sending, if a Bcc would be written out (see WriteOptions.AssertNoBcc).
+// The Return-Path is not written out, as it is added on delivery (see WriteOptions.OmitReturnPath).
 func (m *Message) Send(smtpAddressPort string, auth smtp.Auth) error {
 
-	to := m.Header.To()
-	cc := m.Header.Cc()
-	bcc := m.Header.Bcc()
-	all := make([]string, 0, len(to)+len(cc)+len(bcc))
-
-	all = append(append(append(all, to...), cc...), bcc...)
-	for i := 0; i < len(all); i++ {
-		address, err := mail.ParseAddress(all[i])
-		if err != nil {
-			return err
-		}
-		all[i] = address.Address
-	}
-
-	if len(all) == 0 {
-		return errors.New("May not send email without a recipient (To, CC, or Bcc)")
+	from, recipients, err := m.SMTPEnvelope()
+	if err != nil {
+		return err
 	}
 
-	from, err := mail.ParseAddress(m.Header.From())
+	err = m.Save()
 	if err != nil {
 		return err
 	}
 
-	if len(from.Address) == 0 {
-		return errors.New("May not send email without a From address")
+	buffer := &bytes.Buffer{}
+	if _, err = m.WriteToWith(buffer, WriteOptions{AssertNoBcc: true, OmitReturnPath: true}); err != nil {
+		return err
 	}
 
-	err = m.Save()
+	return smtp.SendMail(smtpAddressPort, auth, from, recipients, buffer.Bytes())
+}
+
+// SMTPEnvelope returns the bare addresses to use for the SMTP MAIL FROM, which is the
+// first available of the Return-Path, Sender, or From, and for the RCPT TO, which is every
+// one of the Recipients in the To, Cc, and Bcc. A Return-Path of "<>" is the null
+// reverse-path, such as for bounces, so the MAIL FROM is empty.
+func (m *Message) SMTPEnvelope() (mailFrom string, rcptTo []string, err error) {
+
+	recipients, err := m.Header.Recipients()
 	if err != nil {
-		return err
+		return "", nil, err
+	}
+	rcptTo = make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		rcptTo = append(rcptTo, recipient.Address)
 	}
 
-	b, err := m.Bytes()
-	if err != nil {
-		return err
+	if len(rcptTo) == 0 {
+		return "", nil, errors.New("May not send email without a recipient (To, CC, or Bcc)")
 	}
 
-	return smtp.SendMail(smtpAddressPort, auth, from.Address, all, b)
+	// An empty ReturnPath is the null reverse-path "<>"
+	if len(strings.TrimSpace(m.Header.Get("Return-Path"))) > 0 {
+		return m.Header.ReturnPath(), rcptTo, nil
+	}
+	for _, field := range []string{"Sender", "From"} {
+		if len(strings.TrimSpace(m.Header.Get(field))) == 0 {
+			continue
+		}
+		from, err := parseAddress(m.Header.Get(field))
+		if err != nil {
+			return "", nil, err
+		}
+		if len(from.Address) > 0 {
+			return from.Address, rcptTo, nil
+		}
+	}
+	return "", nil, errors.New("May not send email without a From address")
 }
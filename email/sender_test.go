@@ -0,0 +1,182 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSend ...
+func TestSend(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("Test Name <test.from@host.com>", "Test Subject", "test.to@host.com", "Another To <another.to@host.com>")
+	header.SetCc("CC Name <test.cc@host.com>")
+	header.SetBcc("Hidden Name <test.bcc@host.com>", "another.bcc@host.com")
+	msg := NewMessage(header, "text", "<p>html</p>")
+
+	addr, transactions := fakeSMTPServer(t)
+	if err := msg.Send(addr, nil); err != nil {
+		t.Fatal("Could not send message:", err)
+	}
+	transaction := <-transactions
+
+	expectedRecipients := []string{"test.to@host.com", "another.to@host.com", "test.cc@host.com", "test.bcc@host.com", "another.bcc@host.com"}
+	if transaction.from != "test.from@host.com" || !reflect.DeepEqual(transaction.recipients, expectedRecipients) {
+		t.Fatalf("Incorrect envelope: %q %q", transaction.from, transaction.recipients)
+	}
+
+	parsed, err := ParseMessage(strings.NewReader(transaction.data))
+	if err != nil {
+		t.Fatal("Could not parse sent message:", err)
+	}
+	if parsed.Header.IsSet("Bcc") || strings.Contains(transaction.data, "bcc@host.com") {
+		t.Fatal("Sent message must not contain the Bcc:", transaction.data)
+	}
+	if parsed.Header.Get("To") != header.Get("To") || len(parsed.Header.MessageID()) == 0 {
+		t.Fatalf("Sent message header is incorrect: %q", parsed.Header)
+	}
+}
+
+// TestSendFromSender ...
+func TestSendFromSender(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("first@host.com, second@host.com", "Test Subject", "test.to@host.com")
+	header.SetSender("secretary@host.com")
+
+	addr, transactions := fakeSMTPServer(t)
+	if err := NewMessage(header, "text", "<p>html</p>").Send(addr, nil); err != nil {
+		t.Fatal("Could not send message:", err)
+	}
+	if transaction := <-transactions; transaction.from != "secretary@host.com" {
+		t.Fatal("Expected the envelope to be from the Sender:", transaction.from)
+	}
+}
+
+// TestSendFromReturnPath ...
+func TestSendFromReturnPath(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("test.from@host.com", "Test Subject", "test.to@host.com")
+	header.SetSender("secretary@host.com")
+	header.SetReturnPath("bounces+test.to=host.com@host.com")
+
+	addr, transactions := fakeSMTPServer(t)
+	if err := NewMessage(header, "text", "<p>html</p>").Send(addr, nil); err != nil {
+		t.Fatal("Could not send message:", err)
+	}
+	transaction := <-transactions
+	if transaction.from != "bounces+test.to=host.com@host.com" {
+		t.Fatal("Expected the envelope to be from the Return-Path:", transaction.from)
+	}
+	if strings.Contains(transaction.data, "Return-Path") {
+		t.Fatal("Sent message must not contain the Return-Path:", transaction.data)
+	}
+}
+
+// smtpTransaction is what a fakeSMTPServer received for a single message.
+type smtpTransaction struct {
+	from       string
+	recipients []string
+	data       string
+}
+
+// TestSMTPEnvelope ...
+func TestSMTPEnvelope(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("Test Name <test.from@host.com>", "Test Subject", "test.to@host.com", "\"Doe, John\" <john@host.com>")
+	header.SetCc("cc@host.com, Test.To@host.com")
+	header.SetBcc("bcc@host.com")
+	msg := NewMessage(header, "text", "<p>html</p>")
+
+	mailFrom, rcptTo, err := msg.SMTPEnvelope()
+	expected := []string{"test.to@host.com", "john@host.com", "cc@host.com", "bcc@host.com"}
+	if err != nil || mailFrom != "test.from@host.com" || !reflect.DeepEqual(rcptTo, expected) {
+		t.Fatal("Incorrect envelope:", mailFrom, rcptTo, err)
+	}
+
+	header.SetSender("Secretary <secretary@host.com>")
+	if mailFrom, _, err = msg.SMTPEnvelope(); err != nil || mailFrom != "secretary@host.com" {
+		t.Fatal("Expected the Sender to take precedence over the From:", mailFrom, err)
+	}
+	header.Set("Return-Path", "<bounces@host.com>")
+	if mailFrom, _, err = msg.SMTPEnvelope(); err != nil || mailFrom != "bounces@host.com" {
+		t.Fatal("Expected the Return-Path to take precedence:", mailFrom, err)
+	}
+	header.Set("Return-Path", "<>")
+	if mailFrom, rcptTo, err = msg.SMTPEnvelope(); err != nil || mailFrom != "" || len(rcptTo) != 4 {
+		t.Fatal("Expected the null reverse-path:", mailFrom, err)
+	}
+	header.Set("Return-Path", "< >")
+	if mailFrom, _, err = msg.SMTPEnvelope(); err != nil || mailFrom != "" {
+		t.Fatal("Expected the null reverse-path with a space:", mailFrom, err)
+	}
+
+	header.Del("To")
+	header.Del("Cc")
+	header.Del("Bcc")
+	if _, _, err = msg.SMTPEnvelope(); err == nil {
+		t.Fatal("Expected an error without any recipients")
+	}
+}
+
+// fakeSMTPServer accepts a single SMTP connection, sending what it received
+// on the returned channel once the client quits.
+func fakeSMTPServer(t *testing.T) (string, <-chan smtpTransaction) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Could not listen:", err)
+	}
+	transactions := make(chan smtpTransaction, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		transaction := smtpTransaction{}
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				tp.PrintfLine("250 localhost")
+			case strings.HasPrefix(command, "MAIL FROM:"):
+				transaction.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+				tp.PrintfLine("250 OK")
+			case strings.HasPrefix(command, "RCPT TO:"):
+				transaction.recipients = append(transaction.recipients, strings.Trim(line[len("RCPT TO:"):], "<> "))
+				tp.PrintfLine("250 OK")
+			case command == "DATA":
+				tp.PrintfLine("354 Go ahead")
+				data, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				transaction.data = string(data)
+				tp.PrintfLine("250 OK")
+			case command == "QUIT":
+				tp.PrintfLine("221 Bye")
+				transactions <- transaction
+				return
+			default:
+				tp.PrintfLine("502 Not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String(), transactions
+}
@@ -8,17 +8,44 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"math"
 	"math/big"
 	"os"
 	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 var maxInt64 = big.NewInt(math.MaxInt64)
 
+// MessageIDHost, if set, is used as the domain after the "@" in generated
+// Message-IDs and Content-IDs, such as a public sending domain, instead of this
+// machine's hostname, which may be an internal name that should not be leaked.
+var MessageIDHost string
+
+// Rand, Clock, and ProcessID are the sources of what varies between generated messages.
+// Production code should leave them as they are. Tests may set them to deterministic sources,
+// such as a math/rand Rand with a fixed seed for Rand, along with MessageIDHost, to produce
+// the same messages on every run.
+var (
+	// Rand is the source of randomness for generated Message-IDs, Content-IDs, and boundaries,
+	// and for DKIM signing.
+	Rand io.Reader = rand.Reader
+
+	// Clock returns the current time, for the Date set by Save, the time in generated
+	// Message-IDs, Content-IDs, and readable boundaries, and the DKIM signature timestamp.
+	Clock = time.Now
+
+	// ProcessID returns the process ID in generated Message-IDs and Content-IDs.
+	ProcessID = os.Getpid
+)
+
 // GenMessageID creates and returns a Message-ID, without surrounding angle brackets.
 func GenMessageID() (string, error) {
 	return generateID("")
@@ -31,18 +58,22 @@ func GenContentID(filename string) (string, error) {
 
 // generateID creates a globally unique identifier in the Message-ID format (subset of email address),
 // optionally having an additional string appended to the local part.
+// The domain is MessageIDHost if set, otherwise the hostname, or localhost if that is unavailable.
 // Example: 11223344556677889900.11.1234567890@localhost
 func generateID(appendWith string) (string, error) {
-	random, err := rand.Int(rand.Reader, maxInt64)
+	random, err := rand.Int(Rand, maxInt64)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
-	hostname, err := os.Hostname()
-	if err != nil {
-		hostname = "localhost"
+	hostname := MessageIDHost
+	if len(hostname) == 0 {
+		hostname, err = os.Hostname()
+		if err != nil || len(hostname) == 0 {
+			hostname = "localhost"
+		}
 	}
-	pid := os.Getpid()
-	nanoTime := time.Now().UTC().UnixNano()
+	pid := ProcessID()
+	nanoTime := Clock().UTC().UnixNano()
 	if len(appendWith) == 0 {
 		return fmt.Sprintf("%d.%d.%d@%s", nanoTime, pid, random, hostname), nil
 	}
@@ -53,13 +84,222 @@ func generateID(appendWith string) (string, error) {
 // It is copied from multipart.Writer.randomBoundary()
 func randomBoundary() string {
 	var buf [30]byte
-	_, err := io.ReadFull(rand.Reader, buf[:])
+	_, err := io.ReadFull(Rand, buf[:])
 	if err != nil {
 		panic(err)
 	}
 	return fmt.Sprintf("%x", buf[:])
 }
 
+// BoundaryGenerator creates the boundary for each new multipart message or part.
+// It defaults to random hex boundaries, and may be set to GenReadableBoundary
+// for boundaries that are easier to spot while debugging raw messages.
+// Generated boundaries that are not valid per RFC 2046 are replaced with random ones.
+var BoundaryGenerator = randomBoundary
+
+// readableBoundaryCount makes every readable boundary unique within this process.
+var readableBoundaryCount uint64
+
+// GenReadableBoundary returns a unique boundary that is recognizable as such,
+// in the style of "----=_Part_0_1234567890.1234567890123".
+func GenReadableBoundary() string {
+	random, err := rand.Int(Rand, big.NewInt(1e10))
+	if err != nil {
+		panic(err)
+	}
+	count := atomic.AddUint64(&readableBoundaryCount, 1) - 1
+	millis := Clock().UTC().UnixNano() / int64(time.Millisecond)
+	return fmt.Sprintf("----=_Part_%d_%010d.%d", count, random, millis)
+}
+
+// newBoundary returns a boundary from BoundaryGenerator, if it is valid.
+func newBoundary() string {
+	if boundary := BoundaryGenerator(); validBoundary(boundary) {
+		return boundary
+	}
+	return randomBoundary()
+}
+
+// GenSafeBoundary returns a boundary from BoundaryGenerator, like any multipart message
+// or part gets, that is confirmed to not occur anywhere in the parts, including their
+// headers, bodies, and any parts nested within them, regenerating it if it does.
+// Bodies that are streamed from a BodyReader can not be checked.
+func GenSafeBoundary(parts ...*Message) string {
+	boundary := newBoundary()
+	for boundaryOccurs(boundary, parts) {
+		boundary = randomBoundary()
+	}
+	return boundary
+}
+
+// boundaryOccurs returns true if the boundary is a substring of anything in the parts.
+func boundaryOccurs(boundary string, parts []*Message) bool {
+	for _, part := range parts {
+		if part == nil {
+			continue
+		}
+		for _, msg := range part.MessagesAll() {
+			for field, values := range msg.Header {
+				for _, val := range values {
+					if strings.Contains(field, boundary) || strings.Contains(val, boundary) {
+						return true
+					}
+				}
+			}
+			for _, content := range [][]byte{msg.Preamble, msg.Epilogue, msg.Body} {
+				if bytes.Contains(content, []byte(boundary)) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// validBoundary returns true if the boundary is 1 to 70 characters
+// from the RFC 2046 bchars set, and does not end with a space.
+func validBoundary(boundary string) bool {
+	if len(boundary) == 0 || len(boundary) > 70 || boundary[len(boundary)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(boundary); i++ {
+		c := boundary[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || strings.IndexByte("'()+_,-./:=? ", c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// knownValue returns the value from known that matches val case-insensitively,
+// ignoring surrounding whitespace, or an empty string if none match.
+func knownValue(val string, known []string) string {
+	val = strings.TrimSpace(val)
+	for _, k := range known {
+		if strings.EqualFold(val, k) {
+			return k
+		}
+	}
+	return ""
+}
+
+// isASCII ...
+func isASCII(val string) bool {
+	for i := 0; i < len(val); i++ {
+		if val[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// isPrintableASCII returns true if every byte of val is printable ASCII, or a space.
+func isPrintableASCII(val string) bool {
+	for i := 0; i < len(val); i++ {
+		if val[i] < ' ' || val[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// isToken reports whether val is a MIME token (RFC 2045), such as a parameter attribute.
+func isToken(val string) bool {
+	if len(val) == 0 {
+		return false
+	}
+	for i := 0; i < len(val); i++ {
+		if val[i] <= ' ' || val[i] >= 0x7f || strings.IndexByte(`()<>@,;:\"/[]?=`, val[i]) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeRFC2231 encodes the parameter value as UTF-8 with percent-encoding (RFC 2231),
+// prefixed with its charset, for use as an extended parameter value.
+func encodeRFC2231(val string) string {
+	return "UTF-8''" + percentEncode(val)
+}
+
+// percentEncode percent-encodes every byte of the value that can not be in an extended
+// parameter value (RFC 2231 7).
+func percentEncode(val string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(val); i++ {
+		if c := val[i]; isToken(val[i:i+1]) && c != '*' && c != '\'' && c != '%' {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return encoded.String()
+}
+
+// maxParamLength is the longest parameter that fits on a folded line, after the whitespace
+// and before the semicolon, beyond which it is split into continuations.
+const maxParamLength = MaxHeaderLineLength - 2
+
+// formatParam returns the parameter of a Content-Type or Content-Disposition, such as a filename,
+// with the value quoted if it is ASCII, or otherwise encoded according to RFC 2231. One longer
+// than maxParamLength is split into numbered continuations (RFC 2231 3) that each fit on a line,
+// such as `filename*0*=...; filename*1*=...`, and only between characters,
+// as some mail clients decode each section on its own.
+func formatParam(attribute, val string) string {
+	extended := !isASCII(val)
+	single := attribute + "=" + quoteString(val)
+	if extended {
+		single = attribute + "*=" + encodeRFC2231(val)
+	}
+	if len(single) <= maxParamLength {
+		return single
+	}
+
+	var sections []string
+	section, start := "", 0
+	if extended {
+		section, start = "UTF-8''", len("UTF-8''")
+	}
+	appendSection := func() {
+		if extended {
+			sections = append(sections, fmt.Sprintf("%s*%d*=%s", attribute, len(sections), section))
+		} else {
+			sections = append(sections, fmt.Sprintf("%s*%d=\"%s\"", attribute, len(sections), section))
+		}
+	}
+	for i := 0; i < len(val); {
+		_, size := utf8.DecodeRuneInString(val[i:])
+		char := val[i : i+size]
+		i += size
+		if extended {
+			char = percentEncode(char)
+		} else {
+			char = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(char)
+		}
+		// The attribute, "*" and the section number, and "*=", or "=" and quotes around the value
+		overhead := len(attribute) + len(strconv.Itoa(len(sections))) + 4
+		if overhead+len(section)+len(char) > maxParamLength && len(section) > start {
+			appendSection()
+			section, start = "", 0
+		}
+		section += char
+	}
+	appendSection()
+	return strings.Join(sections, "; ")
+}
+
+// dispositionWithFilename returns a Content-Disposition value with the filename,
+// which is quoted if it is ASCII, or otherwise encoded according to RFC 2231,
+// and split into continuations if it is too long for a line.
+func dispositionWithFilename(disposition string, filename string) string {
+	return disposition + "; " + formatParam("filename", filename)
+}
+
+// quoteString returns the value as a quoted-string, with any backslashes and quotes escaped.
+func quoteString(val string) string {
+	return "\"" + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(val) + "\""
+}
+
 // max ...
 func max(x, y int) int {
 	if x > y {
@@ -88,72 +328,450 @@ func bufioReader(r io.Reader) *bufio.Reader {
 	return bufio.NewReader(r)
 }
 
-// headerWriter ...
+// minHeaderLineLength is the shortest line length a header can be folded to,
+// as a continuation line needs its whitespace indent and at least one other character.
+const minHeaderLineLength = 2
+
+// errHeaderLineLength ...
+var errHeaderLineLength = fmt.Errorf("Header line length must be at least %d to fold", minHeaderLineLength)
+
+// ErrHeaderLineTooLong is returned when writing a header field that has a run of characters
+// without whitespace to fold at, which is longer than the limit of MaxHeaderTotalLength (RFC 5322).
+var ErrHeaderLineTooLong = fmt.Errorf("Header line can not be folded to within %d characters", MaxHeaderTotalLength)
+
+// headerWriter buffers a single header field, then folds it onto continuation
+// lines when it is flushed, so that the fold points can be chosen with the whole
+// field in view.
 type headerWriter struct {
 	w          io.Writer
-	curLineLen int
+	field      []byte
 	maxLineLen int
+	fold       foldFunc
+	crlf       bool           // terminate lines with CRLF instead of LF
+	encoding   HeaderEncoding // how the field was encoded, for DryRun
+	folds      []int          // the length of each line that was folded, for DryRun
+}
+
+// noteEncoding records the encoding of part of the field, if it is the most significant yet.
+func (w *headerWriter) noteEncoding(encoding HeaderEncoding) {
+	if headerEncodingRanks[encoding] > headerEncodingRanks[w.encoding] {
+		w.encoding = encoding
+	}
+}
+
+// atValueStart returns true if only the field name has been written, and none of the value.
+func (w *headerWriter) atValueStart() bool {
+	return bytes.IndexByte(w.field, ':') == len(bytes.TrimRight(w.field, " "))-1
 }
 
 // Write ...
 func (w *headerWriter) Write(p []byte) (int, error) {
+	w.field = append(w.field, p...)
+	return len(p), nil
+}
+
+// Flush folds the buffered header field, and writes it out terminated by a new line.
+// Nothing is written if a line can not be folded to within MaxHeaderTotalLength.
+func (w *headerWriter) Flush() (int, error) {
 	// TODO: logic for wrapping headers is actually pretty complex for some header types, like received headers
 	var total int
-	for len(p)+w.curLineLen > w.maxLineLen {
-		toWrite := w.maxLineLen - w.curLineLen
-		// Wrap at last space, if any
-		lastSpace := bytes.LastIndexByte(p[:toWrite], byte(' '))
-		if lastSpace > 0 {
-			toWrite = lastSpace
+	line := w.field
+	w.field = w.field[:0]
+	if w.maxLineLen < minHeaderLineLength {
+		return total, errHeaderLineLength
+	}
+	var lines [][]byte
+	for len(line) > w.maxLineLen {
+		folding := line
+		if len(lines) == 0 {
+			folding = hideValueStart(line)
+		}
+		toWrite := w.fold(folding, w.maxLineLen)
+		if toWrite <= 0 || toWrite >= len(line) {
+			break // Nowhere to fold that makes progress, so the line stays long
 		}
-		written, err := w.w.Write(p[:toWrite])
+		lines = append(lines, line[:toWrite])
+		line = line[toWrite:] // Continuation lines are indented by the whitespace folded at
+	}
+	lines = append(lines, line)
+	w.folds = nil
+	for i, l := range lines {
+		if len(l) > MaxHeaderTotalLength {
+			return total, ErrHeaderLineTooLong
+		}
+		if i < len(lines)-1 {
+			w.folds = append(w.folds, len(l))
+		}
+	}
+	for _, l := range lines {
+		// Lines share the field buffer, so the line ending is written separately
+		written, err := w.w.Write(l)
 		total += written
 		if err != nil {
 			return total, err
 		}
-		written, err = w.w.Write([]byte("\n"))
+		written, err = io.WriteString(w.w, newline(w.crlf))
 		total += written
 		if err != nil {
 			return total, err
 		}
-		p = p[toWrite:]
-		w.curLineLen = 1 // Continuation lines are indented
 	}
-	written, err := w.w.Write(p)
-	total += written
-	w.curLineLen += written
-	return total, err
+	return total, nil
+}
+
+// hideValueStart returns a copy of the first line of a header field, with the whitespace after the
+// field name's colon replaced, so that it is never folded before the first token of the value,
+// which would leave the field name on a line of its own, without making the first line any shorter.
+func hideValueStart(line []byte) []byte {
+	colon := bytes.IndexByte(line, ':')
+	if colon < 0 {
+		return line
+	}
+	hidden := append([]byte(nil), line...)
+	for i := colon + 1; i < len(hidden) && (hidden[i] == ' ' || hidden[i] == '\t'); i++ {
+		hidden[i] = ':'
+	}
+	return hidden
 }
 
-// base64Writer ...
+// foldFunc returns the index at which a header field line should be folded,
+// so that line[:index] is no longer than limit, or if that is not possible,
+// as soon after the limit as possible. It returns -1 if there is nowhere to fold.
+// The byte at the index must be whitespace, which becomes the continuation line's indent.
+type foldFunc func(line []byte, limit int) int
+
+// foldUnstructured folds at the last whitespace that fits.
+func foldUnstructured(line []byte, limit int) int {
+	return foldAtPoints(line, foldPoints(line, false), limit, 0)
+}
+
+// foldAfter returns a foldFunc that folds at the last whitespace that fits
+// and follows the separator, such as a comma between addresses,
+// falling back to any whitespace that fits. It never folds inside a quoted-string.
+func foldAfter(separator byte) foldFunc {
+	return func(line []byte, limit int) int {
+		return foldAtPoints(line, foldPoints(line, true), limit, separator)
+	}
+}
+
+// foldBetweenAddresses folds an address list at the last whitespace that fits and follows
+// a comma, so that each line has whole addresses. If no address fits, it folds after the
+// first address instead, letting that line run past the limit up to MaxHeaderTotalLength,
+// and only falls back to whitespace within an address when that is too long.
+// It never folds inside a quoted-string.
+func foldBetweenAddresses(line []byte, limit int) int {
+	points := foldPoints(line, true)
+	last := limit
+	if last > len(line)-1 {
+		last = len(line) - 1
+	}
+	for i := last; i > 0; i-- {
+		if points[i] && line[i-1] == ',' {
+			return i
+		}
+	}
+	for i := last + 1; i < len(line) && i <= MaxHeaderTotalLength; i++ {
+		if points[i] && line[i-1] == ',' {
+			return i
+		}
+	}
+	if len(line) <= MaxHeaderTotalLength {
+		return -1 // The last address fits within a line on its own
+	}
+	return foldAtPoints(line, points, limit, 0)
+}
+
+// foldAtPoints returns the index of the last fold point in line[1:limit+1] that
+// follows the separator, or if there is none, the last fold point in line[1:limit+1],
+// or if there is none, the first fold point after the limit, or -1.
+func foldAtPoints(line []byte, points []bool, limit int, separator byte) int {
+	last := limit
+	if last > len(line)-1 {
+		last = len(line) - 1
+	}
+	if separator != 0 {
+		for i := last; i > 0; i-- {
+			if points[i] && line[i-1] == separator {
+				return i
+			}
+		}
+	}
+	for i := last; i > 0; i-- {
+		if points[i] {
+			return i
+		}
+	}
+	for i := max(1, limit+1); i < len(line); i++ {
+		if points[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+// foldPoints returns whether a line may be folded at each of its indexes,
+// which must be whitespace that does not follow other whitespace,
+// so that no line is left empty or made of only whitespace.
+// Optionally, whitespace inside quoted-strings is excluded.
+func foldPoints(line []byte, skipQuoted bool) []bool {
+	points := make([]bool, len(line))
+	quoted := false
+	escaped := false
+	for i, b := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && b == '\\':
+			escaped = true
+		case skipQuoted && b == '"':
+			quoted = !quoted
+		case !quoted && i > 0 && (b == ' ' || b == '\t'):
+			points[i] = line[i-1] != ' ' && line[i-1] != '\t'
+		}
+	}
+	return points
+}
+
+// base64Writer base64 encodes everything written to it, wrapping the encoded
+// output at maxLineLen characters, and terminating the final line.
+// Close must be called to flush the final, possibly padded, group of bytes.
 type base64Writer struct {
 	w          io.Writer
+	pending    []byte // bytes that do not yet make up a full group of 3
 	curLineLen int
 	maxLineLen int
+	crlf       bool // terminate lines with CRLF instead of LF
 }
 
 // Write ...
 func (w *base64Writer) Write(p []byte) (int, error) {
-	var total int
+	data := append(w.pending, p...)
+	full := len(data) / 3 * 3
+	w.pending = append([]byte{}, data[full:]...)
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(full))
+	base64.StdEncoding.Encode(encoded, data[:full])
+	if err := w.writeLines(encoded); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close encodes any pending bytes, and terminates the final line.
+func (w *base64Writer) Close() error {
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(w.pending)))
+	base64.StdEncoding.Encode(encoded, w.pending)
+	w.pending = nil
+	if err := w.writeLines(encoded); err != nil {
+		return err
+	}
+	if w.curLineLen > 0 {
+		w.curLineLen = 0
+		_, err := io.WriteString(w.w, newline(w.crlf))
+		return err
+	}
+	return nil
+}
+
+// writeLines writes out the encoded bytes, wrapping lines at maxLineLen.
+func (w *base64Writer) writeLines(p []byte) error {
 	for len(p)+w.curLineLen > w.maxLineLen {
 		toWrite := w.maxLineLen - w.curLineLen
-		written, err := w.w.Write(p[:toWrite])
-		total += written
-		if err != nil {
-			return total, err
+		if _, err := w.w.Write(p[:toWrite]); err != nil {
+			return err
 		}
-		written, err = w.w.Write([]byte("\n"))
-		total += written
-		if err != nil {
-			return total, err
+		if _, err := io.WriteString(w.w, newline(w.crlf)); err != nil {
+			return err
 		}
 		p = p[toWrite:]
 		w.curLineLen = 0
 	}
 	written, err := w.w.Write(p)
-	total += written
 	w.curLineLen += written
-	return total, err
+	return err
+}
+
+// quotedPrintableWriter quoted-printable encodes everything written to it,
+// inserting soft line breaks so that no encoded line exceeds maxLineLen characters.
+// Line breaks in the input are written out as hard line breaks, and any whitespace
+// at the end of a line is encoded so that it survives transport.
+// Close must be called to flush the final line to the underlying writer.
+type quotedPrintableWriter struct {
+	w          io.Writer
+	line       []byte
+	maxLineLen int
+	crlf       bool // write line breaks as CRLF instead of LF
+	cr         bool // last byte was a carriage return
+}
+
+// Write ...
+func (w *quotedPrintableWriter) Write(p []byte) (int, error) {
+	for i, b := range p {
+		switch {
+		case b == '\n' && w.cr:
+			// second half of a CRLF, the line was already broken on the CR
+			w.cr = false
+			continue
+		case b == '\n' || b == '\r':
+			w.cr = b == '\r'
+			if err := w.flushLine(true); err != nil {
+				return i, err
+			}
+			continue
+		}
+		w.cr = false
+		encoded := []byte{b}
+		if b == '=' || (b < ' ' && b != '\t') || b > '~' {
+			encoded = []byte(fmt.Sprintf("=%02X", b))
+		}
+		// Leave room for the "=" of a soft line break
+		if len(w.line)+len(encoded) > w.maxLineLen-1 {
+			if err := w.softBreak(); err != nil {
+				return i, err
+			}
+		}
+		w.line = append(w.line, encoded...)
+	}
+	return len(p), nil
+}
+
+// Close flushes the final line, without adding a line break.
+func (w *quotedPrintableWriter) Close() error {
+	return w.flushLine(false)
+}
+
+// flushLine encodes any trailing whitespace, then writes out the current line,
+// optionally followed by a hard line break.
+func (w *quotedPrintableWriter) flushLine(hardBreak bool) error {
+	if last := len(w.line) - 1; last >= 0 && (w.line[last] == ' ' || w.line[last] == '\t') {
+		encoded := []byte(fmt.Sprintf("=%02X", w.line[last]))
+		w.line = w.line[:last]
+		if len(w.line)+len(encoded) > w.maxLineLen {
+			if err := w.softBreak(); err != nil {
+				return err
+			}
+		}
+		w.line = append(w.line, encoded...)
+	}
+	if hardBreak {
+		w.line = append(w.line, newline(w.crlf)...)
+	}
+	_, err := w.w.Write(w.line)
+	w.line = w.line[:0]
+	return err
+}
+
+// softBreak writes out the current line followed by a soft line break.
+func (w *quotedPrintableWriter) softBreak() error {
+	_, err := w.w.Write(append(append(w.line, '='), newline(w.crlf)...))
+	w.line = w.line[:0]
+	return err
+}
+
+// newline returns the line ending, which is CRLF if crlf is true, otherwise LF.
+func newline(crlf bool) string {
+	if crlf {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// NormalizeCRLF returns a copy of the body with all of its line endings, whether
+// LF, CR, or CRLF, converted to CRLF, as required by MIME (RFC 2045).
+func NormalizeCRLF(body []byte) []byte {
+	return normalizeLineEndings(body, true)
+}
+
+// normalizeLineEndings returns a copy of the body with all of its line endings,
+// whether LF, CR, or CRLF, converted to CRLF if crlf is true, otherwise LF.
+func normalizeLineEndings(body []byte, crlf bool) []byte {
+	ending := newline(crlf)
+	normalized := make([]byte, 0, len(body)+len(body)/40)
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '\r':
+			if i+1 < len(body) && body[i+1] == '\n' {
+				i++
+			}
+			normalized = append(normalized, ending...)
+		case '\n':
+			normalized = append(normalized, ending...)
+		default:
+			normalized = append(normalized, body[i])
+		}
+	}
+	return normalized
+}
+
+// lineEndingReader normalizes the line endings of what is read from r like normalizeLineEndings,
+// so that a text body can be streamed.
+type lineEndingReader struct {
+	r       io.Reader
+	crlf    bool
+	cr      bool   // the last byte read was a CR, so a LF after it is part of the same line ending
+	pending []byte // normalized bytes that did not fit in the last Read
+}
+
+// Read ...
+func (r *lineEndingReader) Read(p []byte) (int, error) {
+	var err error
+	if len(r.pending) == 0 {
+		raw := make([]byte, len(p))
+		var n int
+		n, err = r.r.Read(raw)
+		for _, b := range raw[:n] {
+			switch {
+			case b == '\n' && r.cr:
+				r.cr = false
+			case b == '\r' || b == '\n':
+				r.pending = append(r.pending, newline(r.crlf)...)
+				r.cr = b == '\r'
+			default:
+				r.pending = append(r.pending, b)
+				r.cr = false
+			}
+		}
+	}
+	copied := copy(p, r.pending)
+	r.pending = r.pending[copied:]
+	if len(r.pending) > 0 {
+		return copied, nil
+	}
+	return copied, err
+}
+
+// countingWriter counts the bytes written to it, and writes them through to w,
+// or discards them if w is nil.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write ...
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.w == nil {
+		w.n += int64(len(p))
+		return len(p), nil
+	}
+	written, err := w.w.Write(p)
+	w.n += int64(written)
+	return written, err
+}
+
+// zeroReader reads an endless stream of zero bytes.
+type zeroReader struct{}
+
+// Read ...
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// NewTrimReader returns a reader that skips any leading whitespace in r, and then
+// reads the rest of r unchanged, such as for a message with blank lines before its header.
+func NewTrimReader(r io.Reader) io.Reader {
+	return &leftTrimReader{r: bufioReader(r)}
 }
 
 // leftTrimReader ...
@@ -163,31 +781,19 @@ type leftTrimReader struct {
 }
 
 // Read ...
-func (r *leftTrimReader) Read(p []byte) (n3 int, err3 error) {
-	if r.done {
-		// Delegate
-		return r.r.Read(p)
-	}
-	// Peek and discard any whitespace, until we hit the first non-whitespace byte, then delegate
-	r.r.Peek(1) // force a buffer load if empty
-	maxBuffered := r.r.Buffered()
-	if maxBuffered == 0 {
-		r.done = true
-		return r.r.Read(p)
-	}
-	peek, _ := r.r.Peek(maxBuffered)
-	maxBuffered = len(peek)
-	whiteSpaceCount := 0
-	for whiteSpaceCount < maxBuffered && isASCIISpace(peek[whiteSpaceCount]) {
-		whiteSpaceCount++
-	}
-	if whiteSpaceCount > 0 {
-		discarded, err := r.r.Discard(whiteSpaceCount)
-		if err == nil && discarded == whiteSpaceCount && whiteSpaceCount == maxBuffered {
-			return r.Read(p)
-		}
-	}
-	r.done = true
+func (r *leftTrimReader) Read(p []byte) (int, error) {
+	for !r.done {
+		// Peek and discard any whitespace, until we hit the first non-whitespace byte, then delegate
+		r.r.Peek(1) // force a buffer load if empty
+		peek, _ := r.r.Peek(r.r.Buffered())
+		whiteSpaceCount := 0
+		for whiteSpaceCount < len(peek) && isASCIISpace(peek[whiteSpaceCount]) {
+			whiteSpaceCount++
+		}
+		r.r.Discard(whiteSpaceCount)
+		// Keep going while the whole buffer was whitespace, until the end of the reader
+		r.done = len(peek) == 0 || whiteSpaceCount < len(peek)
+	}
 	return r.r.Read(p)
 }
 
@@ -0,0 +1,299 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	mathrand "math/rand"
+	"mime/quotedprintable"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// TestQuotedPrintableWriterSoftBreaks ...
+func TestQuotedPrintableWriterSoftBreaks(t *testing.T) {
+	t.Parallel()
+
+	raw := strings.Repeat("This line is much too long to fit = on a single quoted-printable line. ", 4) + "非常感谢你"
+
+	buffer := &bytes.Buffer{}
+	qpWriter := &quotedPrintableWriter{w: buffer, maxLineLen: MaxBodyLineLength}
+	if _, err := qpWriter.Write([]byte(raw)); err != nil {
+		t.Fatal("Could not write quoted-printable:", err)
+	}
+	if err := qpWriter.Close(); err != nil {
+		t.Fatal("Could not close quoted-printable writer:", err)
+	}
+
+	lines := strings.Split(buffer.String(), "\n")
+	if len(lines) < 5 {
+		t.Fatal("Expected long line to be soft broken:", buffer.String())
+	}
+	for i, line := range lines {
+		if len(line) > MaxBodyLineLength {
+			t.Fatal("Quoted-printable line too long:", len(line), line)
+		}
+		if i < len(lines)-1 && !strings.HasSuffix(line, "=") {
+			t.Fatal("Expected a soft line break:", line)
+		}
+		if strings.HasSuffix(line, "=3") || strings.HasSuffix(line, "=E") {
+			t.Fatal("Soft line break split an encoded octet:", line)
+		}
+	}
+
+	decoded, err := ioutil.ReadAll(quotedprintable.NewReader(buffer))
+	if err != nil || string(decoded) != raw {
+		t.Fatal("Quoted-printable did not decode back to the original:", err, string(decoded))
+	}
+}
+
+// TestQuotedPrintableWriterTrailingWhitespace ...
+func TestQuotedPrintableWriterTrailingWhitespace(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"trailing space \nnext":       "trailing space=20\nnext",
+		"trailing tab\t\r\nnext":      "trailing tab=09\nnext",
+		"inner \t whitespace":         "inner \t whitespace",
+		"ends with whitespace \t":     "ends with whitespace =09",
+		"a=b\r\nc\rd":                 "a=3Db\nc\nd",
+		strings.Repeat("x", 75) + " ": strings.Repeat("x", 75) + "=\n=20",
+	}
+	for raw, expected := range tests {
+		buffer := &bytes.Buffer{}
+		qpWriter := &quotedPrintableWriter{w: buffer, maxLineLen: MaxBodyLineLength}
+		qpWriter.Write([]byte(raw))
+		qpWriter.Close()
+		if buffer.String() != expected {
+			t.Fatalf("Expected %q, got %q", expected, buffer.String())
+		}
+	}
+}
+
+// TestGenReadableBoundary ...
+func TestGenReadableBoundary(t *testing.T) {
+	t.Parallel()
+
+	format := regexp.MustCompile(`^----=_Part_\d+_\d{10}\.\d+$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		boundary := GenReadableBoundary()
+		if !format.MatchString(boundary) || !validBoundary(boundary) {
+			t.Fatal("Boundary is not in the expected format:", boundary)
+		}
+		if seen[boundary] {
+			t.Fatal("Boundary was generated twice:", boundary)
+		}
+		seen[boundary] = true
+	}
+}
+
+// TestBoundaryGenerator ...
+func TestBoundaryGenerator(t *testing.T) {
+	defer func(original func() string) { BoundaryGenerator = original }(BoundaryGenerator)
+
+	BoundaryGenerator = GenReadableBoundary
+	part := NewPartMultipart("mixed")
+	if _, params, err := part.Header.ContentType(); err != nil || !strings.HasPrefix(params["boundary"], "----=_Part_") {
+		t.Fatal("Multipart part did not use the selected boundary generator:", part.Header.Get("Content-Type"))
+	}
+
+	BoundaryGenerator = func() string { return "not a valid boundary because it ends with a space " }
+	part = NewPartMultipart("mixed")
+	if _, params, err := part.Header.ContentType(); err != nil || !validBoundary(params["boundary"]) {
+		t.Fatal("Invalid boundary was not replaced:", part.Header.Get("Content-Type"))
+	}
+}
+
+// TestBase64WriterLineLength ...
+func TestBase64WriterLineLength(t *testing.T) {
+	t.Parallel()
+
+	// 57 raw bytes encode to exactly one 76 character line
+	for _, rawLen := range []int{0, 1, 56, 57, 58, 114, 200} {
+		raw := make([]byte, rawLen)
+		for i := range raw {
+			raw[i] = byte(i * 7)
+		}
+
+		// Write in a single call, and one byte at a time, which must produce the same output
+		oneShot := &bytes.Buffer{}
+		b64Writer := &base64Writer{w: oneShot, maxLineLen: MaxBodyLineLength}
+		if _, err := b64Writer.Write(raw); err != nil || b64Writer.Close() != nil {
+			t.Fatal("Could not write base64:", err)
+		}
+		byteAtATime := &bytes.Buffer{}
+		b64Writer = &base64Writer{w: byteAtATime, maxLineLen: MaxBodyLineLength}
+		for i := range raw {
+			b64Writer.Write(raw[i : i+1])
+		}
+		b64Writer.Close()
+		if oneShot.String() != byteAtATime.String() {
+			t.Fatalf("Output depends on how it was written: %q %q", oneShot.String(), byteAtATime.String())
+		}
+
+		expected := base64.StdEncoding.EncodeToString(raw)
+		encoded := oneShot.String()
+		if rawLen == 0 {
+			if len(encoded) != 0 {
+				t.Fatalf("Expected no output for an empty body: %q", encoded)
+			}
+			continue
+		}
+		if !strings.HasSuffix(encoded, "\n") || strings.HasSuffix(encoded, "\n\n") {
+			t.Fatalf("Expected exactly one final line terminator for %d bytes: %q", rawLen, encoded)
+		}
+		lines := strings.Split(strings.TrimSuffix(encoded, "\n"), "\n")
+		for i, line := range lines {
+			if len(line) > MaxBodyLineLength || (i < len(lines)-1 && len(line) != MaxBodyLineLength) {
+				t.Fatalf("Incorrect line length %d for %d bytes: %q", len(line), rawLen, encoded)
+			}
+		}
+		if strings.Join(lines, "") != expected {
+			t.Fatalf("Incorrect encoding for %d bytes: %q", rawLen, encoded)
+		}
+	}
+}
+
+// TestMessageIDHost ...
+func TestMessageIDHost(t *testing.T) {
+	defer func(original func() int) { ProcessID = original }(ProcessID)
+	defer func(original string) { MessageIDHost = original }(MessageIDHost)
+
+	MessageIDHost = "mail.example.com"
+	messageID, err := GenMessageID()
+	if err != nil || !strings.HasSuffix(messageID, "@mail.example.com") || strings.Count(messageID, "@") != 1 {
+		t.Fatal("Message-ID does not use the host override:", messageID, err)
+	}
+	contentID, err := GenContentID("image.png")
+	if err != nil || !strings.HasSuffix(contentID, ".image.png@mail.example.com") {
+		t.Fatal("Content-ID does not use the host override:", contentID, err)
+	}
+
+	MessageIDHost = ""
+	if messageID, err = GenMessageID(); err != nil || strings.HasSuffix(messageID, "@") {
+		t.Fatal("Message-ID does not fall back to a hostname:", messageID, err)
+	}
+}
+
+// TestDeterministicRandAndClock ...
+func TestDeterministicRandAndClock(t *testing.T) {
+	defer func(original io.Reader) { Rand = original }(Rand)
+	defer func(original func() time.Time) { Clock = original }(Clock)
+	defer func(original func() int) { ProcessID = original }(ProcessID)
+	defer func(original string) { MessageIDHost = original }(MessageIDHost)
+
+	Clock = func() time.Time { return time.Date(2020, time.March, 4, 5, 6, 7, 8, time.UTC) }
+	ProcessID = func() int { return 1234 }
+	MessageIDHost = "mail.host.com"
+	serialize := func() string {
+		Rand = mathrand.New(mathrand.NewSource(1))
+		contentID, err := GenContentID("logo.png")
+		if err != nil {
+			t.Fatal("Unable to generate Content-ID:", err)
+		}
+		inline := NewPartInlineFromBytes([]byte("png"), "logo.png", contentID)
+		msg := NewMessageWithInlines(NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"),
+			"Text", "<img src=\"cid:"+contentID+"\">", []*Message{inline})
+		if err = msg.Save(); err != nil {
+			t.Fatal("Unable to save message:", err)
+		}
+		raw, err := msg.Bytes()
+		if err != nil {
+			t.Fatal("Unable to write message:", err)
+		}
+		return string(raw)
+	}
+
+	first := serialize()
+	if second := serialize(); second != first {
+		t.Fatalf("Expected the same message twice, got:\n%s\nand:\n%s", first, second)
+	}
+	messageID := "1583298367000000008.1234.841526052024071897@mail.host.com"
+	contentID := "1583298367000000008.1234.5980212987775051087.logo.png@mail.host.com"
+	if !strings.Contains(first, "\nDate: "+formatDate(Clock())+"\n") ||
+		!strings.Contains(first, "\nMessage-Id: <"+messageID+">\n") || !strings.Contains(first, "\nContent-Id: <"+contentID+">\n") {
+		t.Fatal("Expected the Date and IDs to use the Clock, Rand, and ProcessID:", first)
+	}
+}
+
+// TestNormalizeCRLF ...
+func TestNormalizeCRLF(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"":                 "",
+		"no line endings":  "no line endings",
+		"lf\ncrlf\r\ncr\r": "lf\r\ncrlf\r\ncr\r\n",
+		"\r\n\n\r\r\n":     "\r\n\r\n\r\n\r\n",
+		"\n\r":             "\r\n\r\n",
+	}
+	for raw, expected := range tests {
+		if normalized := string(NormalizeCRLF([]byte(raw))); normalized != expected {
+			t.Fatalf("Expected %q, got %q", expected, normalized)
+		}
+	}
+}
+
+// TestNewTrimReader ...
+func TestNewTrimReader(t *testing.T) {
+	t.Parallel()
+
+	// More whitespace than fits in the buffer, so it spans several buffer refills
+	long := strings.Repeat(" \r\n\t", 5000)
+	tests := map[string]string{
+		"":                         "",
+		" \n\t\r\n ":               "",
+		"From: a@host.com\n\nbody": "From: a@host.com\n\nbody",
+		"\n\n  From: a@host.com\n": "From: a@host.com\n",
+		long:                       "",
+		long + "text \n more ":     "text \n more ",
+	}
+	for raw, expected := range tests {
+		for _, r := range []io.Reader{strings.NewReader(raw), iotest.OneByteReader(strings.NewReader(raw))} {
+			trimmed, err := ioutil.ReadAll(NewTrimReader(r))
+			if err != nil || string(trimmed) != expected {
+				t.Fatalf("Expected %q, got %q %v", expected, trimmed, err)
+			}
+		}
+	}
+}
+
+// TestGenSafeBoundary ...
+func TestGenSafeBoundary(t *testing.T) {
+	defer func(original func() string) { BoundaryGenerator = original }(BoundaryGenerator)
+
+	BoundaryGenerator = func() string { return "candidate-boundary" }
+	if boundary := GenSafeBoundary(NewPartText("No conflict here")); boundary != "candidate-boundary" {
+		t.Fatal("Expected the generated boundary when it does not occur in the parts:", boundary)
+	}
+
+	nested := NewPartMultipart("alternative", NewPartText("text"), NewPartHTML("<p>--candidate-boundary</p>"))
+	for _, parts := range [][]*Message{
+		{NewPartText("Body quoting another message:\n--candidate-boundary\nContent-Type: text/plain")},
+		{NewPartText("text"), nested},
+		{{Header: Header{"X-Boundary": []string{"candidate-boundary"}}, Body: []byte("body")}},
+	} {
+		boundary := GenSafeBoundary(parts...)
+		if boundary == "candidate-boundary" || !validBoundary(boundary) || boundaryOccurs(boundary, parts) {
+			t.Fatal("Expected a boundary that does not occur in the parts:", boundary)
+		}
+	}
+
+	// Constructors check their parts
+	msg := NewMessage(NewHeader("test.from@host.com", "Test Subject", "test.to@host.com"), "--candidate-boundary", "<p>html</p>")
+	if _, params, err := msg.Header.ContentType(); err != nil || params["boundary"] == "candidate-boundary" {
+		t.Fatal("Expected the message boundary to be regenerated:", params, err)
+	}
+	if _, params, err := msg.Parts[0].Header.ContentType(); err != nil || params["boundary"] == "candidate-boundary" {
+		t.Fatal("Expected the alternative part boundary to be regenerated:", params, err)
+	}
+}